@@ -7,6 +7,9 @@ toolchain go1.23.2
 require (
 	github.com/docker/machine v0.16.2
 	github.com/lxc/incus/v6 v6.6.0
+	golang.org/x/crypto v0.28.0
+	golang.org/x/sync v0.8.0
+	gopkg.in/yaml.v2 v2.4.0
 )
 
 require (
@@ -30,10 +33,8 @@ require (
 	go.opentelemetry.io/otel v1.30.0 // indirect
 	go.opentelemetry.io/otel/metric v1.30.0 // indirect
 	go.opentelemetry.io/otel/trace v1.30.0 // indirect
-	golang.org/x/crypto v0.28.0 // indirect
 	golang.org/x/oauth2 v0.23.0 // indirect
 	golang.org/x/sys v0.26.0 // indirect
 	golang.org/x/term v0.25.0 // indirect
 	golang.org/x/text v0.19.0 // indirect
-	gopkg.in/yaml.v2 v2.4.0 // indirect
 )
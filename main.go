@@ -1,10 +1,65 @@
 package main
 
 import (
+	"fmt"
+	"os"
+
 	"github.com/docker/machine/libmachine/drivers/plugin"
 	"github.com/edorid/docker-machine-driver-incus/pkg/drivers/incus"
 )
 
 func main() {
+	if len(os.Args) > 1 && os.Args[1] == "--rancher-schema" {
+		schema, err := incus.GenerateSchema()
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		fmt.Println(string(schema))
+		return
+	}
+
+	if len(os.Args) > 1 && os.Args[1] == "--list-images" {
+		filter := ""
+		if len(os.Args) > 2 {
+			filter = os.Args[2]
+		}
+		if err := incus.ListImages(os.Stdout, filter); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		return
+	}
+
+	if len(os.Args) > 1 && os.Args[1] == "--list-machines" {
+		if err := incus.ListMachines(os.Stdout); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		return
+	}
+
+	if len(os.Args) > 3 && os.Args[1] == "--rotate-credentials" {
+		token := ""
+		if len(os.Args) > 4 {
+			token = os.Args[4]
+		}
+		if err := incus.RotateCredentials(os.Stdout, os.Args[2], os.Args[3], token); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		return
+	}
+
+	if len(os.Args) > 1 {
+		if ok, err := incus.RunMachineCommand(os.Stdout, os.Args[1], os.Args[2:]); ok {
+			if err != nil {
+				fmt.Fprintln(os.Stderr, err)
+				os.Exit(1)
+			}
+			return
+		}
+	}
+
 	plugin.RegisterDriver(incus.NewDriver("", ""))
 }
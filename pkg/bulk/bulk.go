@@ -0,0 +1,139 @@
+// Package bulk runs the operations of the Incus driver on many machines
+// concurrently, sharing one connection, for tooling embedding the driver
+// instead of going through the docker-machine plugin of each node
+package bulk
+
+import (
+	"fmt"
+	"os"
+	"sync"
+
+	"github.com/docker/machine/libmachine/drivers"
+	"github.com/docker/machine/libmachine/state"
+	"github.com/edorid/docker-machine-driver-incus/pkg/drivers/incus"
+	client "github.com/lxc/incus/v6/client"
+	"golang.org/x/sync/errgroup"
+)
+
+const defaultConcurrency = 4
+
+// Result is the outcome of an operation on one machine, State is None
+// after Remove
+type Result struct {
+	Name  string
+	State state.State
+	Err   error
+}
+
+// Runner runs the operations against one Incus server
+type Runner struct {
+	client      client.InstanceServer
+	concurrency int
+
+	// one client per project, each client opens its own event websocket
+	projectsLock sync.Mutex
+	projects     map[string]client.InstanceServer
+}
+
+// NewRunner returns a runner using the connected client for all machines,
+// running at most concurrency operations at once
+func NewRunner(server client.InstanceServer, concurrency int) *Runner {
+	if concurrency < 1 {
+		concurrency = defaultConcurrency
+	}
+
+	return &Runner{
+		client:      server,
+		concurrency: concurrency,
+		projects:    map[string]client.InstanceServer{},
+	}
+}
+
+// projectClient returns the client shared by the machines of the project
+func (r *Runner) projectClient(project string) client.InstanceServer {
+	r.projectsLock.Lock()
+	defer r.projectsLock.Unlock()
+
+	if _, ok := r.projects[project]; !ok {
+		r.projects[project] = r.client.UseProject(project)
+	}
+
+	return r.projects[project]
+}
+
+// NewMachine configures the driver of a machine from the create flags and
+// makes it use the client of the runner; storePath is the docker-machine
+// store the SSH key of the machine is written to
+func (r *Runner) NewMachine(name, storePath string, opts drivers.DriverOptions) (*incus.Driver, error) {
+	d := incus.NewDriver(name, storePath).(*incus.Driver)
+	if err := d.SetConfigFromFlags(opts); err != nil {
+		return nil, fmt.Errorf("invalid config of %s: %w", name, err)
+	}
+
+	// docker-machine creates the machine directory before calling the driver
+	if err := os.MkdirAll(d.ResolveStorePath("."), 0700); err != nil {
+		return nil, err
+	}
+
+	d.SetClient(r.projectClient(d.Project))
+	return d, nil
+}
+
+// Create runs the pre-create checks and creates each machine
+func (r *Runner) Create(machines []*incus.Driver) []Result {
+	return r.run(machines, func(d *incus.Driver) error {
+		if err := d.PreCreateCheck(); err != nil {
+			return err
+		}
+		return d.Create()
+	}, true)
+}
+
+// Remove deletes each machine along with the resources the driver created
+func (r *Runner) Remove(machines []*incus.Driver) []Result {
+	return r.run(machines, (*incus.Driver).Remove, false)
+}
+
+// Start starts each machine
+func (r *Runner) Start(machines []*incus.Driver) []Result {
+	return r.run(machines, (*incus.Driver).Start, true)
+}
+
+// Stop stops each machine
+func (r *Runner) Stop(machines []*incus.Driver) []Result {
+	return r.run(machines, (*incus.Driver).Stop, true)
+}
+
+// State returns the state of each machine
+func (r *Runner) State(machines []*incus.Driver) []Result {
+	return r.run(machines, func(*incus.Driver) error { return nil }, true)
+}
+
+// run calls fn for each machine and collects the results in the order of
+// the machines, recording the state each machine is left in when withState
+// is set
+func (r *Runner) run(machines []*incus.Driver, fn func(d *incus.Driver) error, withState bool) []Result {
+	results := make([]Result, len(machines))
+
+	var g errgroup.Group
+	g.SetLimit(r.concurrency)
+	for i, d := range machines {
+		g.Go(func() error {
+			result := Result{Name: d.GetMachineName(), Err: fn(d)}
+
+			if withState {
+				st, err := d.GetState()
+				if err != nil && result.Err == nil {
+					result.Err = err
+				}
+				result.State = st
+			}
+
+			results[i] = result
+			return nil
+		})
+	}
+	_ = g.Wait()
+
+	return results
+}
@@ -0,0 +1,110 @@
+package incus
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/docker/machine/libmachine/log"
+)
+
+// dockerPlatforms maps the Incus architecture names to the Docker platforms
+// of their images
+var dockerPlatforms = map[string]string{
+	"x86_64":  "linux/amd64",
+	"aarch64": "linux/arm64",
+	"armv7l":  "linux/arm/v7",
+	"i686":    "linux/386",
+	"ppc64le": "linux/ppc64le",
+	"s390x":   "linux/s390x",
+	"riscv64": "linux/riscv64",
+}
+
+// engineScriptArchitectures are the architectures the Docker repositories,
+// which the default engine install script uses, ship packages for
+var engineScriptArchitectures = []string{"x86_64", "aarch64", "armv7l", "ppc64le", "s390x"}
+
+// guestArchitecture returns the architecture of the instance, the one of the
+// image resolved by the pre-create checks or else the requested one, empty
+// when neither is known
+func (d *Driver) guestArchitecture() string {
+	if d.ImageArchitecture != "" {
+		return d.ImageArchitecture
+	}
+
+	return d.Architecture
+}
+
+// dockerPlatform returns the Docker platform of the instance, empty when
+// its architecture is not known
+func (d *Driver) dockerPlatform() string {
+	return dockerPlatforms[d.guestArchitecture()]
+}
+
+// getTargetArchitectures returns the architectures the instance can run
+// on, taken from the target cluster member when one was selected or from
+// the members of the target group
+func (d *Driver) getTargetArchitectures() ([]string, error) {
+	client, err := d.getClient()
+	if err != nil {
+		return nil, err
+	}
+
+	if name := d.targetMember(); name != "" && client.IsClustered() {
+		member, _, err := client.GetClusterMember(name)
+		if err != nil {
+			return nil, fmt.Errorf("cluster member %s not found: %w", name, err)
+		}
+		return []string{member.Architecture}, nil
+	}
+
+	if d.targetGroup() != "" && client.IsClustered() {
+		members, err := d.candidateMembers(client)
+		if err != nil {
+			return nil, err
+		}
+
+		archs := []string{}
+		for _, member := range members {
+			if !slices.Contains(archs, member.Architecture) {
+				archs = append(archs, member.Architecture)
+			}
+		}
+		return archs, nil
+	}
+
+	server, err := d.getServer()
+	if err != nil {
+		return nil, err
+	}
+
+	return server.Environment.Architectures, nil
+}
+
+// checkArchitecture verifies the image is available for the architecture
+// of the server the instance is created on
+func (d *Driver) checkArchitecture() error {
+	if len(d.imageArchitectures) == 0 {
+		return nil
+	}
+
+	targetArchs, err := d.getTargetArchitectures()
+	if err != nil {
+		return err
+	}
+
+	for _, arch := range targetArchs {
+		if d.Architecture != "" && arch != d.Architecture {
+			continue
+		}
+
+		if slices.Contains(d.imageArchitectures, arch) {
+			log.Infof("Using %s image architecture", arch)
+			d.ImageArchitecture = arch
+			return nil
+		}
+	}
+
+	return fmt.Errorf("image %s is available for %s but the server runs %s",
+		d.Image, strings.Join(d.imageArchitectures, ", "), strings.Join(targetArchs, ", "))
+}
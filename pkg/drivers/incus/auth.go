@@ -0,0 +1,28 @@
+package incus
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/lxc/incus/v6/shared/api"
+)
+
+// permissionError names the fine-grained authorization entitlement missing
+// when the server denies a request, so admins can grant the minimal role
+func permissionError(err error, entitlement, objectType, object string) error {
+	if err == nil || !api.StatusErrorCheck(err, http.StatusForbidden) {
+		return err
+	}
+
+	return fmt.Errorf("%w (the driver needs the %s entitlement on %s %s)", err, entitlement, objectType, object)
+}
+
+// projectPermissionError wraps a denied request against the project
+func (d *Driver) projectPermissionError(err error, entitlement string) error {
+	return permissionError(err, entitlement, "project", d.Project)
+}
+
+// instancePermissionError wraps a denied request against the instance
+func (d *Driver) instancePermissionError(err error, entitlement string) error {
+	return permissionError(err, entitlement, "instance", d.Project+"/"+d.MachineName)
+}
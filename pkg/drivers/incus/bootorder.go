@@ -0,0 +1,65 @@
+package incus
+
+import (
+	"fmt"
+	"slices"
+	"strconv"
+
+	"github.com/lxc/incus/v6/shared/api"
+)
+
+// bootDevices are the disks --incus-boot-priority sets the priority of
+var bootDevices = []string{"root", "iso", "data"}
+
+// default boot priorities, the ISO boots before the root disk while it is
+// attached and the data disk, without priority, is tried only after both
+var defaultBootPriorities = map[string]int{
+	"root": 5,
+	"iso":  10,
+}
+
+// parseBootPriorities parses device=priority pairs, the device with the
+// highest priority booting first and 0 leaving the priority unset; Incus
+// has no way to take a disk out of the boot order, a disk without priority
+// is still tried after the ones having one
+func parseBootPriorities(value string) (map[string]int, error) {
+	pairs, err := parseKeyValues(value)
+	if err != nil {
+		return nil, fmt.Errorf("invalid boot priorities: %w", err)
+	}
+
+	priorities := map[string]int{}
+	for device, value := range pairs {
+		if !slices.Contains(bootDevices, device) {
+			return nil, fmt.Errorf("invalid boot priority device %q, expected one of: root, iso, data", device)
+		}
+
+		priority, err := strconv.Atoi(value)
+		if err != nil || priority < 0 {
+			return nil, fmt.Errorf("invalid boot priority %q of %s", value, device)
+		}
+		priorities[device] = priority
+	}
+
+	return priorities, nil
+}
+
+// setBootPriority sets the boot.priority of the disk of a virtual machine,
+// machines created by older drivers keep the defaults, a disk without
+// priority still boots after the others
+func (d *Driver) setBootPriority(device map[string]string, role string) {
+	if d.instanceType() != api.InstanceTypeVM {
+		return
+	}
+
+	priority, ok := d.BootPriorities[role]
+	if !ok {
+		priority, ok = defaultBootPriorities[role]
+	}
+	if !ok || priority == 0 {
+		delete(device, "boot.priority")
+		return
+	}
+
+	device["boot.priority"] = strconv.Itoa(priority)
+}
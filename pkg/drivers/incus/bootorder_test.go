@@ -0,0 +1,67 @@
+package incus
+
+import (
+	"maps"
+	"strings"
+	"testing"
+)
+
+func TestParseBootPriorities(t *testing.T) {
+	tests := []struct {
+		name    string
+		value   string
+		want    map[string]int
+		wantErr string
+	}{
+		{name: "empty", value: "", want: map[string]int{}},
+		{name: "priorities", value: "root=5, iso=10,data=0", want: map[string]int{"root": 5, "iso": 10, "data": 0}},
+		{name: "unknown device", value: "nic=1", wantErr: `invalid boot priority device "nic"`},
+		{name: "negative priority", value: "root=-1", wantErr: `invalid boot priority "-1" of root`},
+		{name: "not a number", value: "root=first", wantErr: `invalid boot priority "first" of root`},
+		{name: "missing priority", value: "root", wantErr: "expected key=value"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			priorities, err := parseBootPriorities(tt.value)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("error = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if !maps.Equal(priorities, tt.want) {
+				t.Errorf("priorities = %v, want %v", priorities, tt.want)
+			}
+		})
+	}
+}
+
+func TestSetBootPriority(t *testing.T) {
+	tests := []struct {
+		name   string
+		driver *Driver
+		role   string
+		device map[string]string
+		want   string
+	}{
+		{name: "default root", driver: &Driver{}, role: "root", device: map[string]string{}, want: "5"},
+		{name: "default iso", driver: &Driver{}, role: "iso", device: map[string]string{}, want: "10"},
+		{name: "default data", driver: &Driver{}, role: "data", device: map[string]string{"boot.priority": "1"}, want: ""},
+		{name: "configured", driver: &Driver{BootPriorities: map[string]int{"data": 20}}, role: "data", device: map[string]string{}, want: "20"},
+		{name: "unset", driver: &Driver{BootPriorities: map[string]int{"root": 0}}, role: "root", device: map[string]string{"boot.priority": "1"}, want: ""},
+		{name: "container", driver: &Driver{InstanceType: "container"}, role: "root", device: map[string]string{"boot.priority": "1"}, want: "1"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.driver.setBootPriority(tt.device, tt.role)
+			if tt.device["boot.priority"] != tt.want {
+				t.Errorf("boot.priority = %q, want %q", tt.device["boot.priority"], tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,68 @@
+package incus
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/docker/machine/libmachine/log"
+	incus "github.com/lxc/incus/v6/client"
+	"github.com/lxc/incus/v6/shared/api"
+)
+
+// cloneIdentityScript resets the identity a cloned guest inherits from its
+// source so cloned nodes don't collide
+const cloneIdentityScript = `set -e
+rm -f /etc/ssh/ssh_host_*
+ssh-keygen -A
+rm -f /etc/machine-id /var/lib/dbus/machine-id
+systemd-machine-id-setup
+[ -d /var/lib/dbus ] && ln -sf /etc/machine-id /var/lib/dbus/machine-id
+systemctl restart ssh 2>/dev/null || systemctl restart sshd
+if [ -f /etc/docker/key.json ]; then
+  rm -f /etc/docker/key.json
+  systemctl restart docker || true
+fi
+`
+
+// getCloneSource returns the source copying the template instance or
+// snapshot given as instance or instance/snapshot
+func (d *Driver) getCloneSource() (*api.InstanceSource, error) {
+	client, err := d.getClient()
+	if err != nil {
+		return nil, err
+	}
+
+	instance, snapshot, isSnapshot := strings.Cut(d.CloneSource, "/")
+	if isSnapshot {
+		if _, _, err := client.GetInstanceSnapshot(instance, snapshot); err != nil {
+			return nil, fmt.Errorf("snapshot %s not found: %w", d.CloneSource, err)
+		}
+	} else if _, _, err := client.GetInstance(instance); err != nil {
+		return nil, fmt.Errorf("instance %s not found: %w", d.CloneSource, err)
+	}
+
+	return &api.InstanceSource{
+		Type:         "copy",
+		Source:       d.CloneSource,
+		InstanceOnly: true,
+	}, nil
+}
+
+// finalizeClone regenerates the SSH host keys, machine-id and Docker key of
+// a cloned guest before its host keys get recorded
+func (d *Driver) finalizeClone(client incus.InstanceServer) error {
+	if d.CloneSource == "" {
+		return nil
+	}
+
+	if err := d.waitForAgent(client); err != nil {
+		return fmt.Errorf("failed to finalize clone: %w", err)
+	}
+
+	log.Infof("Regenerating identity of cloned instance %s...", d.MachineName)
+	if _, err := d.exec(client, "sh", "-c", cloneIdentityScript); err != nil {
+		return fmt.Errorf("failed to finalize clone: %w", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,190 @@
+package incus
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/docker/machine/libmachine/log"
+	incus "github.com/lxc/incus/v6/client"
+	"gopkg.in/yaml.v2"
+)
+
+// cloudConfigKeys are the top level keys of the cloud-config modules, an
+// unknown key close to one of them is most likely a typo cloud-init ignores,
+// so it is reported as such without failing the preflight
+var cloudConfigKeys = []string{
+	"allow_public_ssh_keys", "ansible", "apk_repos", "apt", "apt_pipelining", "autoinstall",
+	"bootcmd", "byobu_by_default", "ca_certs", "chef", "chpasswd", "cloud_config_modules",
+	"cloud_final_modules", "cloud_init_modules", "create_hostname_file", "datasource",
+	"datasource_list", "device_aliases", "disable_ec2_metadata", "disable_root",
+	"disable_root_opts", "disk_setup", "drivers", "fan", "final_message", "fqdn", "fs_setup",
+	"groups", "growpart", "hostname", "keyboard", "landscape", "locale", "locale_configfile",
+	"lxd", "manage_etc_hosts", "manage_resolv_conf", "mcollective", "merge_how", "merge_type",
+	"mount_default_fields", "mounts", "no_ssh_fingerprints", "ntp", "output", "package_reboot_if_required",
+	"package_update", "package_upgrade", "packages", "password", "phone_home", "power_state",
+	"prefer_fqdn_over_hostname", "preserve_hostname", "puppet", "random_seed", "reporting",
+	"resize_rootfs", "resolv_conf", "rh_subscription", "rsyslog", "runcmd", "salt_minion",
+	"seed_random", "snap", "spacewalk", "ssh", "ssh_authorized_keys", "ssh_deletekeys",
+	"ssh_fp_console_blacklist", "ssh_genkeytypes", "ssh_import_id", "ssh_key_console_blacklist",
+	"ssh_keys", "ssh_publish_hostkeys", "ssh_pwauth", "ssh_quiet_keygen", "swap", "system_info",
+	"timezone", "ubuntu_advantage", "ubuntu_pro", "updates", "user", "users", "vendor_data",
+	"wireguard", "write_files", "yum_repo_dir", "yum_repos", "zypper",
+}
+
+// cloudConfigLists are the keys cloud-init only accepts as a list
+var cloudConfigLists = []string{"bootcmd", "packages", "runcmd", "ssh_authorized_keys", "write_files"}
+
+// checkCloudInit parses the cloud-init documents the instance ends up with,
+// its own keys over the ones of the profile, so a malformed document fails
+// the preflight instead of the SSH wait once the guest booted
+func (d *Driver) checkCloudInit(client incus.InstanceServer) error {
+	vendorData, err := d.getVendorData()
+	if err != nil {
+		return err
+	}
+
+	config := map[string]string{}
+	profile, _, err := client.GetProfile(d.Profile)
+	if err != nil {
+		return fmt.Errorf("profile %s not found: %w", d.Profile, err)
+	}
+	for _, key := range []string{"vendor-data", "user-data", "network-config"} {
+		for _, prefix := range []string{"user.", "cloud-init."} {
+			if value, ok := profile.Config[prefix+key]; ok {
+				config["cloud-init."+key] = value
+			}
+		}
+	}
+
+	if err := d.cloudInitConfig(vendorData)(config); err != nil {
+		return err
+	}
+
+	for _, key := range []string{"vendor-data", "user-data"} {
+		value, ok := config["cloud-init."+key]
+		if !ok {
+			continue
+		}
+		if err := checkCloudConfig(value); err != nil {
+			return fmt.Errorf("invalid cloud-init %s: %w", key, err)
+		}
+	}
+
+	if value, ok := config["cloud-init.network-config"]; ok {
+		if err := checkNetworkConfig(value); err != nil {
+			return fmt.Errorf("invalid cloud-init network-config: %w", err)
+		}
+	}
+
+	return nil
+}
+
+// checkCloudConfig parses a #cloud-config document, the other user-data
+// formats only being checked for their header
+func checkCloudConfig(value string) error {
+	format, err := userDataFormat([]byte(value))
+	if err != nil {
+		return err
+	}
+	if format != "cloud-config" {
+		return nil
+	}
+
+	document := yaml.MapSlice{}
+	if err := yaml.Unmarshal([]byte(value), &document); err != nil {
+		return err
+	}
+
+	for _, item := range document {
+		key, ok := item.Key.(string)
+		if !ok {
+			return fmt.Errorf("invalid key %v", item.Key)
+		}
+		// cloud-init still accepts the dashed spelling of older modules
+		key = strings.ReplaceAll(key, "-", "_")
+
+		if !slices.Contains(cloudConfigKeys, key) {
+			// cloud-init keys are lower case, a known key in another case is
+			// certainly a mistake, while a key close to a known one may as well
+			// be a module the list misses
+			if slices.Contains(cloudConfigKeys, strings.ToLower(key)) {
+				return fmt.Errorf("unknown key %q, cloud-config keys are lower case", key)
+			}
+			if known := closestCloudConfigKey(key); known != "" {
+				log.Warnf("Unknown cloud-config key %q, did you mean %q?", key, known)
+				continue
+			}
+			log.Warnf("Unknown cloud-config key %q, cloud-init ignores it", key)
+			continue
+		}
+
+		if slices.Contains(cloudConfigLists, key) {
+			if _, ok := item.Value.([]interface{}); !ok && item.Value != nil {
+				return fmt.Errorf("%s must be a list", key)
+			}
+		}
+	}
+
+	return nil
+}
+
+// checkNetworkConfig parses a network-config document of version 1 or 2,
+// optionally nested under a network key
+func checkNetworkConfig(value string) error {
+	document := map[string]interface{}{}
+	if err := yaml.Unmarshal([]byte(value), &document); err != nil {
+		return err
+	}
+
+	if network, ok := document["network"].(map[interface{}]interface{}); ok {
+		document = map[string]interface{}{}
+		for key, value := range network {
+			document[fmt.Sprint(key)] = value
+		}
+	}
+
+	switch version := document["version"]; version {
+	case 1, 2:
+		return nil
+	case nil:
+		return fmt.Errorf("missing version")
+	default:
+		return fmt.Errorf("unsupported version %v, expected 1 or 2", version)
+	}
+}
+
+// closestCloudConfigKey returns the known key within one edit of key, a
+// few more for the longer keys
+func closestCloudConfigKey(key string) string {
+	for _, known := range cloudConfigKeys {
+		if editDistance(strings.ToLower(key), known) <= 1+len(known)/8 {
+			return known
+		}
+	}
+
+	return ""
+}
+
+// editDistance returns the Levenshtein distance between two strings
+func editDistance(a, b string) int {
+	previous := make([]int, len(b)+1)
+	for j := range previous {
+		previous[j] = j
+	}
+
+	for i := 1; i <= len(a); i++ {
+		current := make([]int, len(b)+1)
+		current[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			current[j] = min(previous[j]+1, current[j-1]+1, previous[j-1]+cost)
+		}
+		previous = current
+	}
+
+	return previous[len(b)]
+}
@@ -0,0 +1,161 @@
+package incus
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestCheckCloudConfig(t *testing.T) {
+	tests := []struct {
+		name    string
+		value   string
+		wantErr string
+	}{
+		{
+			name:  "known keys",
+			value: "#cloud-config\npackages: [jq]\nruncmd:\n  - echo ready\n",
+		},
+		{
+			name:  "dashed spelling",
+			value: "#cloud-config\nca-certs:\n  trusted: []\n",
+		},
+		{
+			name:  "empty list",
+			value: "#cloud-config\npackages:\n",
+		},
+		{
+			name:  "unknown key",
+			value: "#cloud-config\nrancher: {}\n",
+		},
+		{
+			name:  "key close to a known one",
+			value: "#cloud-config\npackage: [jq]\n",
+		},
+		{
+			name:  "valid key missing from the known keys",
+			value: "#cloud-config\ngrub_dpkg:\n  enabled: false\n",
+		},
+		{
+			name:  "script",
+			value: "#!/bin/sh\necho: ready\n",
+		},
+		{
+			name:    "key in another case",
+			value:   "#cloud-config\nRuncmd: [reboot]\n",
+			wantErr: `unknown key "Runcmd", cloud-config keys are lower case`,
+		},
+		{
+			name:    "list key given a string",
+			value:   "#cloud-config\nruncmd: reboot\n",
+			wantErr: "runcmd must be a list",
+		},
+		{
+			name:    "invalid YAML",
+			value:   "#cloud-config\npackages: [jq\n",
+			wantErr: "did not find expected",
+		},
+		{
+			name:    "non-string key",
+			value:   "#cloud-config\n1: one\n",
+			wantErr: "invalid key 1",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := checkCloudConfig(tt.value)
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Fatalf("error = %v, want %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestCheckNetworkConfig(t *testing.T) {
+	tests := []struct {
+		name    string
+		value   string
+		wantErr string
+	}{
+		{
+			name:  "version 2",
+			value: "version: 2\nethernets: {}\n",
+		},
+		{
+			name:  "nested under network",
+			value: "network:\n  version: 1\n  config: []\n",
+		},
+		{
+			name:    "missing version",
+			value:   "ethernets: {}\n",
+			wantErr: "missing version",
+		},
+		{
+			name:    "unsupported version",
+			value:   "version: 3\n",
+			wantErr: "unsupported version 3",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := checkNetworkConfig(tt.value)
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Fatalf("error = %v, want %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestClosestCloudConfigKey(t *testing.T) {
+	tests := []struct {
+		key  string
+		want string
+	}{
+		{key: "runcmd", want: "runcmd"},
+		{key: "runcmds", want: "runcmd"},
+		{key: "write_file", want: "write_files"},
+		{key: "ssh_authorised_keys", want: "ssh_authorized_keys"},
+		{key: "rancher", want: ""},
+		{key: "grub_dpkg", want: ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.key, func(t *testing.T) {
+			if got := closestCloudConfigKey(tt.key); got != tt.want {
+				t.Errorf("closestCloudConfigKey(%q) = %q, want %q", tt.key, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestEditDistance(t *testing.T) {
+	tests := []struct {
+		a, b string
+		want int
+	}{
+		{a: "", b: "", want: 0},
+		{a: "", b: "abc", want: 3},
+		{a: "runcmd", b: "runcmd", want: 0},
+		{a: "runcmd", b: "runcmds", want: 1},
+		{a: "kitten", b: "sitting", want: 3},
+	}
+
+	for _, tt := range tests {
+		if got := editDistance(tt.a, tt.b); got != tt.want {
+			t.Errorf("editDistance(%q, %q) = %d, want %d", tt.a, tt.b, got, tt.want)
+		}
+	}
+}
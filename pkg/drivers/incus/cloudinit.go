@@ -0,0 +1,387 @@
+package incus
+
+import (
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net/textproto"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strings"
+
+	"github.com/docker/machine/libmachine/log"
+	incus "github.com/lxc/incus/v6/client"
+	"github.com/lxc/incus/v6/shared/api"
+	"gopkg.in/yaml.v2"
+)
+
+const prepullLogFile = "/var/log/incus-prepull.log"
+
+var imageRefRegexp = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9._/:@-]*$`)
+
+// packages installed into every instance by the vendor-data
+var cloudInitPackages = []string{"openssh-server", "curl", "iptables", "open-iscsi"}
+
+// vendorScriptBoundary separates the parts of the vendor-data, fixed so the
+// rendered vendor-data only changes with its content
+const vendorScriptBoundary = "docker-machine-incus"
+
+// getVendorData renders the cloud-init vendor-data passed to the instance,
+// the cloud-config along with the script of the driver when it needs one
+func (d *Driver) getVendorData() (string, error) {
+	vendorData := fmt.Sprintf(cloudInitVendorData, d.sshPublicKey)
+
+	packages := slices.Clone(cloudInitPackages)
+	if d.instanceType() == api.InstanceTypeContainer {
+		// iscsid can not run inside a container
+		packages = slices.DeleteFunc(packages, func(name string) bool { return name == "open-iscsi" })
+	}
+	extra := map[string]interface{}{}
+
+	if d.Hostname != "" {
+		hostname, _, _ := strings.Cut(d.Hostname, ".")
+		extra["hostname"] = hostname
+		if hostname != d.Hostname {
+			extra["fqdn"] = d.Hostname
+		}
+		extra["manage_etc_hosts"] = true
+	}
+
+	writeFiles := []map[string]string{}
+	if d.SSHNoPasswordAuth {
+		extra["ssh_pwauth"] = false
+	}
+	if len(d.OpenPorts) > 0 {
+		packages = append(packages, "nftables")
+	}
+	if len(d.TrustedCAs) > 0 {
+		// written before the engine is installed, so dockerd starts with them
+		extra["ca_certs"] = map[string][]string{"trusted": d.getTrustedCAs()}
+		writeFiles = append(writeFiles, d.getRegistryCAFiles()...)
+	}
+	for _, file := range d.WriteFiles {
+		writeFiles = append(writeFiles, file.cloudConfig())
+	}
+
+	extra["packages"] = packages
+	if len(writeFiles) > 0 {
+		extra["write_files"] = writeFiles
+	}
+
+	out, err := yaml.Marshal(extra)
+	if err != nil {
+		return "", fmt.Errorf("failed to render cloud-init vendor-data: %w", err)
+	}
+	vendorData += string(out)
+
+	script := d.getVendorScript()
+	if script == "" {
+		return vendorData, nil
+	}
+
+	return multipartVendorData(vendorData, script)
+}
+
+// getVendorScript renders the shell script setting up the guest for the
+// driver once the packages are installed. cloud-init runs the scripts of
+// the vendor-data whatever the user-data sets, while a runcmd or
+// write_files of the user-data would replace the ones of the vendor-data
+func (d *Driver) getVendorScript() string {
+	var b strings.Builder
+	if d.hasSSHHardening() {
+		writeScriptFile(&b, sshdConfigPath, d.getSSHDConfig(), "0600")
+		b.WriteString("systemctl restart ssh 2>/dev/null || systemctl restart sshd\n")
+	}
+	if len(d.OpenPorts) > 0 {
+		writeScriptFile(&b, firewallRulesPath, d.getFirewallRules(), "0600")
+		writeScriptFile(&b, firewallUnitPath, firewallUnit, "0644")
+		b.WriteString("systemctl daemon-reload && systemctl enable --now " + firewallService + "\n")
+	}
+	if cmd := d.getEngineInstallCmd(); cmd != "" {
+		b.WriteString(cmd + "\n")
+	}
+	if cmd := d.getPrepullCmd(); cmd != "" {
+		b.WriteString(cmd + "\n")
+	}
+	if d.ReadySignal {
+		writeScriptFile(&b, readyUnitPath, readyUnit, "0644")
+		// --no-block as the unit orders itself after the running cloud-final
+		b.WriteString("systemctl daemon-reload && systemctl enable " + readyService + " && systemctl start --no-block " + readyService + "\n")
+	}
+
+	if b.Len() == 0 {
+		return ""
+	}
+
+	return "#!/bin/sh\n" + b.String()
+}
+
+// writeScriptFile adds the commands writing a file to the vendor script
+func writeScriptFile(b *strings.Builder, path, content, permissions string) {
+	if !strings.HasSuffix(content, "\n") {
+		content += "\n"
+	}
+
+	fmt.Fprintf(b, "mkdir -p %s\n", filepath.Dir(path))
+	fmt.Fprintf(b, "cat >%s <<'DOCKER_MACHINE_EOF'\n%sDOCKER_MACHINE_EOF\n", path, content)
+	fmt.Fprintf(b, "chmod %s %s\n", permissions, path)
+}
+
+// multipartVendorData joins the cloud-config and the script of the
+// vendor-data into a MIME multi-part document
+func multipartVendorData(cloudConfig, script string) (string, error) {
+	var b strings.Builder
+	b.WriteString("Content-Type: multipart/mixed; boundary=\"" + vendorScriptBoundary + "\"\nMIME-Version: 1.0\n\n")
+
+	writer := multipart.NewWriter(&b)
+	if err := writer.SetBoundary(vendorScriptBoundary); err != nil {
+		return "", err
+	}
+	for _, part := range []struct {
+		contentType string
+		content     string
+	}{
+		{"text/cloud-config", cloudConfig},
+		{"text/x-shellscript", script},
+	} {
+		w, err := writer.CreatePart(textproto.MIMEHeader{"Content-Type": {part.contentType + `; charset="utf-8"`}})
+		if err != nil {
+			return "", err
+		}
+		if _, err := io.WriteString(w, part.content); err != nil {
+			return "", err
+		}
+	}
+	if err := writer.Close(); err != nil {
+		return "", err
+	}
+
+	return b.String(), nil
+}
+
+// getMetaData renders the provisioning context Incus appends to the
+// cloud-init meta-data, readable in the guest from the datasource
+func (d *Driver) getMetaData() (string, error) {
+	metaData := map[string]string{}
+	for key, value := range d.MetaData {
+		metaData[key] = value
+	}
+	metaData["docker-machine-name"] = d.MachineName
+	if d.MachineCluster != "" {
+		metaData["docker-machine-cluster"] = d.MachineCluster
+	}
+
+	out, err := yaml.Marshal(metaData)
+	if err != nil {
+		return "", fmt.Errorf("failed to render cloud-init meta-data: %w", err)
+	}
+
+	return string(out), nil
+}
+
+// getNetworkConfig renders the cloud-init network-config matching the NIC
+// by its mac address, whatever name the guest gives the interface
+func (d *Driver) getNetworkConfig() (string, error) {
+	ethernet := map[string]interface{}{
+		"match":    map[string]string{"macaddress": d.NICHwaddr},
+		"set-name": d.nicName(),
+		"dhcp4":    !d.IPv6Only,
+	}
+	if d.IPv6Only {
+		ethernet["dhcp6"] = d.NetworkDHCPv6
+		ethernet["accept-ra"] = true
+	}
+	if d.NetworkMTU > 0 {
+		ethernet["mtu"] = d.NetworkMTU
+	}
+
+	if len(d.DNSServers) > 0 || len(d.DNSSearch) > 0 {
+		nameservers := map[string][]string{}
+		if len(d.DNSServers) > 0 {
+			nameservers["addresses"] = d.DNSServers
+			// the resolvers handed out by dnsmasq would be used as well
+			ethernet["dhcp4-overrides"] = map[string]bool{"use-dns": false}
+			if d.IPv6Only {
+				ethernet["dhcp6-overrides"] = map[string]bool{"use-dns": false}
+			}
+		}
+		if len(d.DNSSearch) > 0 {
+			nameservers["search"] = d.DNSSearch
+		}
+		ethernet["nameservers"] = nameservers
+	}
+
+	ethernets := map[string]interface{}{d.nicName(): ethernet}
+	if d.ManagementHwaddr != "" {
+		ethernets[managementNICName] = d.getManagementEthernet()
+	}
+
+	out, err := yaml.Marshal(map[string]interface{}{
+		"network": map[string]interface{}{
+			"version":   2,
+			"ethernets": ethernets,
+		},
+	})
+	if err != nil {
+		return "", fmt.Errorf("failed to render cloud-init network-config: %w", err)
+	}
+
+	return "#cloud-config\n" + string(out), nil
+}
+
+// hasNetworkConfig reports whether the guest needs a network-config instead
+// of the distribution default
+func (d *Driver) hasNetworkConfig() bool {
+	if d.NICHwaddr == "" {
+		return false
+	}
+
+	return d.NetworkMTU > 0 || d.IPv6Only || len(d.DNSServers) > 0 || len(d.DNSSearch) > 0 || d.nicName() != defaultNICName || d.ManagementHwaddr != ""
+}
+
+// getPrepullCmd returns a command of the vendor script which waits in the
+// background until docker is available and then pulls the requested images
+func (d *Driver) getPrepullCmd() string {
+	if len(d.PrepullImages) == 0 {
+		return ""
+	}
+
+	// an image without a variant for the instance architecture fails to pull
+	// instead of pulling one which can not run
+	pull := "docker pull "
+	if platform := d.dockerPlatform(); platform != "" {
+		pull += "--platform " + platform + " "
+	}
+
+	pulls := make([]string, 0, len(d.PrepullImages))
+	for _, image := range d.PrepullImages {
+		pulls = append(pulls, pull+image)
+	}
+
+	return fmt.Sprintf("setsid sh -c 'until docker info >/dev/null 2>&1; do sleep 10; done; %s' >%s 2>&1 &",
+		strings.Join(pulls, "; "), prepullLogFile)
+}
+
+// parseHostname validates a guest hostname or FQDN against DNS conventions
+func parseHostname(value string) (string, error) {
+	hostname := strings.TrimSuffix(strings.TrimSpace(value), ".")
+	if hostname == "" {
+		return "", nil
+	}
+
+	if len(hostname) > 253 || !dnsDomainRegexp.MatchString(hostname) {
+		return "", fmt.Errorf("invalid hostname %q", value)
+	}
+
+	for _, label := range strings.Split(hostname, ".") {
+		if len(label) > 63 {
+			return "", fmt.Errorf("invalid hostname %q, label %s is longer than 63 characters", value, label)
+		}
+	}
+
+	return hostname, nil
+}
+
+func parseImageList(value string) ([]string, error) {
+	images := []string{}
+	for _, image := range strings.Split(value, ",") {
+		image = strings.TrimSpace(image)
+		if image == "" {
+			continue
+		}
+		if !imageRefRegexp.MatchString(image) {
+			return nil, fmt.Errorf("invalid image reference %q", image)
+		}
+		images = append(images, image)
+	}
+
+	return images, nil
+}
+
+// applyCloudInitKeys moves the cloud-init.* keys to the legacy user.* keys
+// when the image templates only consume the latter
+func (d *Driver) applyCloudInitKeys(client incus.InstanceServer) error {
+	legacy, err := d.usesLegacyCloudInitKeys(client)
+	if err != nil {
+		log.Debugf("Unable to inspect image templates of %s: %s", d.MachineName, err)
+		return nil
+	}
+
+	if !legacy {
+		return nil
+	}
+
+	instance, etag, err := client.GetInstance(d.MachineName)
+	if err != nil {
+		return err
+	}
+
+	for _, key := range []string{"vendor-data", "user-data", "network-config"} {
+		value, ok := instance.Config["cloud-init."+key]
+		if !ok {
+			continue
+		}
+
+		delete(instance.Config, "cloud-init."+key)
+		instance.Config["user."+key] = value
+	}
+
+	log.Infof("Image of %s uses legacy user.* cloud-init keys", d.MachineName)
+	op, err := client.UpdateInstance(d.MachineName, instance.Writable(), etag)
+	if err != nil {
+		return fmt.Errorf("failed to switch to legacy cloud-init keys: %w", d.instancePermissionError(err, "can_edit"))
+	}
+
+	err = d.waitOperation(op)
+	if err != nil {
+		return fmt.Errorf("failed to switch to legacy cloud-init keys: %w", err)
+	}
+	d.recordInstance(instance.Writable())
+
+	d.LegacyCloudInitKeys = true
+	return nil
+}
+
+// cloudInitKey returns the instance config key of a cloud-init document,
+// the legacy user.* one when the image only consumes those
+func (d *Driver) cloudInitKey(key string) string {
+	if d.LegacyCloudInitKeys {
+		return "user." + key
+	}
+
+	return "cloud-init." + key
+}
+
+// usesLegacyCloudInitKeys reports whether the instance templates reference
+// user.* cloud-init keys without knowing about the cloud-init.* ones
+func (d *Driver) usesLegacyCloudInitKeys(client incus.InstanceServer) (bool, error) {
+	templates, err := client.GetInstanceTemplateFiles(d.MachineName)
+	if err != nil {
+		return false, err
+	}
+
+	legacy := false
+	for _, name := range templates {
+		content, err := client.GetInstanceTemplateFile(d.MachineName, name)
+		if err != nil {
+			return false, err
+		}
+
+		data, err := io.ReadAll(content)
+		content.Close()
+		if err != nil {
+			return false, err
+		}
+
+		if strings.Contains(string(data), "cloud-init.") {
+			return false, nil
+		}
+
+		if strings.Contains(string(data), "user.user-data") || strings.Contains(string(data), "user.vendor-data") {
+			legacy = true
+		}
+	}
+
+	return legacy, nil
+}
@@ -0,0 +1,116 @@
+package incus
+
+import (
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/mail"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/docker/machine/libmachine/drivers"
+	"gopkg.in/yaml.v2"
+)
+
+// vendorDataParts splits a vendor-data into its content by content type
+func vendorDataParts(t *testing.T, vendorData string) map[string]string {
+	t.Helper()
+
+	msg, err := mail.ReadMessage(strings.NewReader(vendorData))
+	if err != nil {
+		t.Fatalf("vendor-data is not a MIME document: %v", err)
+	}
+	_, params, err := mime.ParseMediaType(msg.Header.Get("Content-Type"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	parts := map[string]string{}
+	reader := multipart.NewReader(msg.Body, params["boundary"])
+	for {
+		part, err := reader.NextPart()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		content, err := io.ReadAll(part)
+		if err != nil {
+			t.Fatal(err)
+		}
+		mediaType, _, _ := mime.ParseMediaType(part.Header.Get("Content-Type"))
+		parts[mediaType] = string(content)
+	}
+
+	return parts
+}
+
+func TestVendorDataScript(t *testing.T) {
+	dir := t.TempDir()
+	userData := filepath.Join(dir, "user-data")
+	if err := os.WriteFile(userData, []byte("#cloud-config\nruncmd:\n  - echo user\nwrite_files:\n  - path: /etc/user\n    content: user\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	d := &Driver{
+		BaseDriver:        &drivers.BaseDriver{MachineName: "machine"},
+		CloudInitUserData: userData,
+		EnginePreinstall:  true,
+		EngineInstallURL:  defaultEngineInstallURL,
+		ReadySignal:       true,
+	}
+	vendorData, err := d.getVendorData()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	config, err := buildConfig(d.cloudInitConfig(vendorData))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if config["cloud-init.user-data"] == "" {
+		t.Fatal("user-data missing")
+	}
+
+	if err := checkCloudConfig(config["cloud-init.vendor-data"]); err != nil {
+		t.Fatalf("invalid vendor-data: %v", err)
+	}
+
+	// the runcmd and write_files of the user-data replace the vendor-data
+	// ones, the steps of the driver must not depend on them
+	parts := vendorDataParts(t, config["cloud-init.vendor-data"])
+	cloudConfig := map[string]interface{}{}
+	if err := yaml.Unmarshal([]byte(parts["text/cloud-config"]), &cloudConfig); err != nil {
+		t.Fatalf("invalid vendor cloud-config: %v", err)
+	}
+	for _, key := range []string{"runcmd", "write_files"} {
+		if _, ok := cloudConfig[key]; ok {
+			t.Errorf("vendor cloud-config sets %s", key)
+		}
+	}
+
+	script := parts["text/x-shellscript"]
+	if !strings.HasPrefix(script, "#!/bin/sh\n") {
+		t.Fatalf("vendor script missing: %q", script)
+	}
+	for _, want := range []string{defaultEngineInstallURL, "cat >" + readyUnitPath, "systemctl start --no-block " + readyService} {
+		if !strings.Contains(script, want) {
+			t.Errorf("vendor script does not contain %q", want)
+		}
+	}
+}
+
+func TestVendorDataWithoutScript(t *testing.T) {
+	d := &Driver{BaseDriver: &drivers.BaseDriver{MachineName: "machine"}}
+	vendorData, err := d.getVendorData()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if format, err := userDataFormat([]byte(vendorData)); err != nil || format != "cloud-config" {
+		t.Errorf("format = %q, %v, want cloud-config", format, err)
+	}
+}
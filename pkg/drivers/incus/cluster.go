@@ -0,0 +1,286 @@
+package incus
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/docker/machine/libmachine/log"
+	incus "github.com/lxc/incus/v6/client"
+	"github.com/lxc/incus/v6/shared/api"
+)
+
+// clusterGroupPrefix marks a target naming a cluster group, the scheduler
+// picking one of its members
+const clusterGroupPrefix = "@"
+
+// targetGroup returns the cluster group of an @group target
+func (d *Driver) targetGroup() string {
+	if group, found := strings.CutPrefix(d.Target, clusterGroupPrefix); found {
+		return group
+	}
+
+	return ""
+}
+
+// targetMember returns the cluster member the instance is created on, or
+// an empty string when the scheduler picks it
+func (d *Driver) targetMember() string {
+	if d.targetGroup() != "" {
+		return ""
+	}
+
+	return d.Target
+}
+
+// checkTarget verifies the cluster member or group of the target exists
+func (d *Driver) checkTarget() error {
+	if d.Target == "" {
+		return nil
+	}
+
+	client, err := d.getClient()
+	if err != nil {
+		return err
+	}
+
+	if !client.IsClustered() {
+		return fmt.Errorf("--incus-target %s needs a clustered Incus server", d.Target)
+	}
+
+	group := d.targetGroup()
+	if group == "" {
+		if _, _, err := client.GetClusterMember(d.Target); err != nil {
+			return fmt.Errorf("cluster member %s not found: %w", d.Target, err)
+		}
+		return nil
+	}
+
+	clusterGroup, _, err := client.GetClusterGroup(group)
+	if err != nil {
+		return fmt.Errorf("cluster group %s not found: %w", group, err)
+	}
+	if len(clusterGroup.Members) == 0 {
+		return fmt.Errorf("cluster group %s has no members", group)
+	}
+
+	return nil
+}
+
+// candidateMembers returns the online cluster members the instance can be
+// placed on, restricted to the members of the target group
+func (d *Driver) candidateMembers(client incus.InstanceServer) ([]api.ClusterMember, error) {
+	members, err := client.GetClusterMembers()
+	if err != nil {
+		return nil, fmt.Errorf("failed to list cluster members: %w", err)
+	}
+
+	group := d.targetGroup()
+	return slices.DeleteFunc(members, func(member api.ClusterMember) bool {
+		return member.Status != "Online" || (group != "" && !slices.Contains(member.Groups, group))
+	}), nil
+}
+
+// hasConstraints reports whether any resource constraint was requested
+func (d *Driver) hasConstraints() bool {
+	return d.RequireGPU || d.RequireStorageDriver != "" || d.Architecture != "" || d.hasGuardrails()
+}
+
+// selectTarget picks the first cluster member satisfying the requested
+// resource constraints and records it as the instance target
+func (d *Driver) selectTarget() error {
+	if !d.hasConstraints() {
+		return nil
+	}
+
+	client, err := d.getClient()
+	if err != nil {
+		return err
+	}
+
+	if !client.IsClustered() {
+		if err := d.checkConstraints(client, ""); err != nil {
+			return fmt.Errorf("server does not satisfy constraints: %w", err)
+		}
+		return nil
+	}
+
+	// an explicit member is only checked, not replaced
+	if member := d.targetMember(); member != "" {
+		if err := d.checkConstraints(client.UseTarget(member), member); err != nil {
+			return fmt.Errorf("cluster member %s does not satisfy constraints: %w", member, err)
+		}
+		return nil
+	}
+
+	// a target group narrows the members down to its own
+	members, err := d.candidateMembers(client)
+	if err != nil {
+		return err
+	}
+
+	for _, member := range members {
+		if err := d.checkConstraints(client.UseTarget(member.ServerName), member.ServerName); err != nil {
+			log.Debugf("Cluster member %s skipped: %s", member.ServerName, err)
+			continue
+		}
+
+		log.Infof("Cluster member %s selected as target", member.ServerName)
+		d.Target = member.ServerName
+		return nil
+	}
+
+	if group := d.targetGroup(); group != "" {
+		return fmt.Errorf("no member of cluster group %s satisfies the requested constraints", group)
+	}
+	return fmt.Errorf("no cluster member satisfies the requested constraints")
+}
+
+// checkConstraints checks the resources of a single server, or cluster
+// member when member is set, against the requested constraints
+func (d *Driver) checkConstraints(client incus.InstanceServer, member string) error {
+	if d.RequireGPU {
+		resources, err := client.GetServerResources()
+		if err != nil {
+			return fmt.Errorf("failed to get server resources: %w", err)
+		}
+
+		if resources.GPU.Total == 0 {
+			return fmt.Errorf("no GPU available")
+		}
+	}
+
+	if err := d.checkGuardrails(client, member); err != nil {
+		return err
+	}
+
+	// skip the members the storage pool is not created on
+	if member != "" && d.Storage != "" && !d.NoRootDevice {
+		if err := d.checkMemberPool(client, member); err != nil {
+			return err
+		}
+	}
+
+	if d.RequireStorageDriver == "" && d.Architecture == "" {
+		return nil
+	}
+
+	// the environment differs between the members of a cluster
+	server, _, err := client.GetServer()
+	if err != nil {
+		return fmt.Errorf("failed to get server info: %w", err)
+	}
+
+	if d.Architecture != "" && !slices.Contains(server.Environment.Architectures, d.Architecture) {
+		return fmt.Errorf("architecture %s not supported", d.Architecture)
+	}
+
+	if d.RequireStorageDriver != "" {
+		supported := slices.ContainsFunc(server.Environment.StorageSupportedDrivers, func(driver api.ServerStorageDriverInfo) bool {
+			return driver.Name == d.RequireStorageDriver
+		})
+		if !supported {
+			return fmt.Errorf("storage driver %s not supported", d.RequireStorageDriver)
+		}
+	}
+
+	return nil
+}
+
+// GetLocation returns the cluster member currently hosting the instance
+func (d *Driver) GetLocation() (string, error) {
+	client, err := d.getClient()
+	if err != nil {
+		return "", err
+	}
+
+	if err := d.refreshLocation(client); err != nil {
+		return "", err
+	}
+
+	return d.Location, nil
+}
+
+// refreshLocation records the cluster member hosting the instance, which
+// changes when the instance is migrated
+func (d *Driver) refreshLocation(client incus.InstanceServer) error {
+	if !client.IsClustered() {
+		return nil
+	}
+
+	instance, _, err := client.GetInstance(d.MachineName)
+	if err != nil {
+		return err
+	}
+
+	moved := d.Location != "" && d.Location != instance.Location
+	if moved {
+		log.Infof("Instance %s moved from %s to %s", d.MachineName, d.Location, instance.Location)
+	}
+	d.Location = instance.Location
+
+	if !moved {
+		return nil
+	}
+
+	// an evacuation restarts the instance elsewhere, which may hand out a
+	// new address from the network of the other member
+	return d.refreshAddress(client)
+}
+
+// refreshAddress records the current address of a running instance and
+// points the docker proxy at it when the address changed
+func (d *Driver) refreshAddress(client incus.InstanceServer) error {
+	state, _, err := client.GetInstanceState(d.MachineName)
+	if err != nil {
+		return err
+	}
+
+	d.resolveURLAddress(state)
+
+	address := d.instanceAddress(state)
+	if address == "" || address == d.IPAddress {
+		return nil
+	}
+
+	log.Infof("Instance %s address changed from %s to %s", d.MachineName, d.IPAddress, address)
+	d.IPAddress = address
+	if err := d.attachLoadBalancer(); err != nil {
+		return err
+	}
+
+	return d.updateDockerProxy(client)
+}
+
+// memberStatus returns the status of the cluster member hosting the
+// instance, or an empty string when the server is not clustered
+func (d *Driver) memberStatus(client incus.InstanceServer) (string, error) {
+	if !client.IsClustered() {
+		return "", nil
+	}
+
+	if d.Location == "" {
+		if err := d.refreshLocation(client); err != nil {
+			return "", err
+		}
+	}
+
+	member, _, err := client.GetClusterMember(d.Location)
+	if err != nil {
+		return "", fmt.Errorf("failed to get cluster member %s: %w", d.Location, err)
+	}
+
+	return member.Status, nil
+}
+
+// restoreMember brings an evacuated cluster member back so the instances
+// it hosted, including this one, are started again
+func (d *Driver) restoreMember(client incus.InstanceServer) error {
+	log.Infof("Restoring evacuated cluster member %s", d.Location)
+	op, err := client.UpdateClusterMemberState(d.Location, api.ClusterMemberStatePost{Action: "restore"})
+	if err != nil {
+		return fmt.Errorf("failed to restore cluster member %s: %w", d.Location, err)
+	}
+
+	return d.waitOperation(op)
+}
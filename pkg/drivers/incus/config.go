@@ -0,0 +1,171 @@
+package incus
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/docker/machine/libmachine/drivers"
+	"github.com/docker/machine/libmachine/log"
+	"github.com/docker/machine/libmachine/mcnflag"
+	"gopkg.in/yaml.v2"
+)
+
+const (
+	siteConfigDir  = "docker-machine-driver-incus"
+	siteConfigFile = "config.yaml"
+)
+
+// siteConfigPaths returns the config files providing flag defaults, the
+// later ones taking precedence
+func siteConfigPaths() []string {
+	paths := []string{filepath.Join("/etc", siteConfigDir, siteConfigFile)}
+	if dir, err := os.UserConfigDir(); err == nil {
+		paths = append(paths, filepath.Join(dir, siteConfigDir, siteConfigFile))
+	}
+
+	return paths
+}
+
+// loadSiteDefaults reads the flag defaults of the config files, keyed by
+// flag name with or without the incus- prefix
+func loadSiteDefaults() (map[string]interface{}, error) {
+	defaults := map[string]interface{}{}
+	for _, path := range siteConfigPaths() {
+		data, err := os.ReadFile(path)
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to read %s: %w", path, err)
+		}
+
+		values := map[string]interface{}{}
+		if err := yaml.Unmarshal(data, &values); err != nil {
+			return nil, fmt.Errorf("failed to parse %s: %w", path, err)
+		}
+
+		for key, value := range values {
+			if !strings.HasPrefix(key, "incus-") {
+				key = "incus-" + key
+			}
+			defaults[key] = value
+		}
+	}
+
+	return defaults, nil
+}
+
+// applySiteDefaults replaces the built-in flag defaults by the site ones,
+// adding a --incus-no-* flag to turn off each bool flag on by site default
+func applySiteDefaults(flags []mcnflag.Flag) []mcnflag.Flag {
+	defaults, err := loadSiteDefaults()
+	if err != nil {
+		log.Warnf("Ignoring site defaults: %s", err)
+		return flags
+	}
+
+	negated := []mcnflag.Flag{}
+
+	for i, flag := range flags {
+		value, ok := defaults[flag.String()]
+		if !ok {
+			continue
+		}
+
+		switch f := flag.(type) {
+		case mcnflag.StringFlag:
+			f.Value = siteDefaultString(value)
+			flags[i] = f
+		case mcnflag.IntFlag:
+			if n, ok := value.(int); ok {
+				f.Value = n
+				flags[i] = f
+			} else {
+				log.Warnf("Ignoring site default %s, expected a number", flag.String())
+			}
+		case mcnflag.StringSliceFlag:
+			f.Value = siteDefaultStrings(value)
+			flags[i] = f
+		case mcnflag.BoolFlag:
+			if b, ok := value.(bool); !ok {
+				log.Warnf("Ignoring site default %s, expected true or false", flag.String())
+			} else if b {
+				negated = append(negated, mcnflag.BoolFlag{
+					Name:  negatedFlag(f.Name),
+					Usage: fmt.Sprintf("Turn off --%s, on by site default", f.Name),
+				})
+			}
+		}
+	}
+
+	return append(flags, negated...)
+}
+
+// negatedFlag returns the flag turning off a bool flag on by site default,
+// ex: incus-insecure becomes incus-no-insecure
+func negatedFlag(name string) string {
+	return driverName + "-no-" + strings.TrimPrefix(name, driverName+"-")
+}
+
+// siteDefaultString renders a site default for a string flag, lists being
+// joined for the comma-separated flags
+func siteDefaultString(value interface{}) string {
+	if list, ok := value.([]interface{}); ok {
+		return strings.Join(siteDefaultStrings(list), ",")
+	}
+
+	return fmt.Sprint(value)
+}
+
+func siteDefaultStrings(value interface{}) []string {
+	list, ok := value.([]interface{})
+	if !ok {
+		return []string{fmt.Sprint(value)}
+	}
+
+	values := []string{}
+	for _, item := range list {
+		values = append(values, fmt.Sprint(item))
+	}
+
+	return values
+}
+
+// siteDefaultOptions turns bool flags on by site default, which mcnflag
+// can not express as bool flags have no default value, unless their
+// --incus-no-* flag is set
+type siteDefaultOptions struct {
+	drivers.DriverOptions
+	bools map[string]bool
+}
+
+func (o siteDefaultOptions) Bool(key string) bool {
+	if o.DriverOptions.Bool(key) {
+		return true
+	}
+	if o.bools[key] && o.DriverOptions.Bool(negatedFlag(key)) {
+		return false
+	}
+
+	return o.bools[key]
+}
+
+// withSiteDefaults applies the bool site defaults to the parsed flags, the
+// other defaults are already part of the flag definitions
+func withSiteDefaults(flags drivers.DriverOptions) drivers.DriverOptions {
+	defaults, err := loadSiteDefaults()
+	if err != nil || len(defaults) == 0 {
+		return flags
+	}
+
+	bools := map[string]bool{}
+	for key, value := range defaults {
+		if b, ok := value.(bool); ok {
+			bools[key] = b
+		}
+	}
+
+	return siteDefaultOptions{DriverOptions: flags, bools: bools}
+}
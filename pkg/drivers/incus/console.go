@@ -0,0 +1,65 @@
+package incus
+
+import (
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/docker/machine/libmachine/log"
+)
+
+// GetConsoleLog returns the serial console output of the instance
+func (d *Driver) GetConsoleLog() (string, error) {
+	client, err := d.getClient()
+	if err != nil {
+		return "", err
+	}
+
+	content, err := client.GetInstanceConsoleLog(d.MachineName, nil)
+	if err != nil {
+		return "", fmt.Errorf("failed to get console log: %w", d.instancePermissionError(err, "can_access_console"))
+	}
+	defer content.Close()
+
+	out, err := io.ReadAll(content)
+	if err != nil {
+		return "", fmt.Errorf("failed to read console log: %w", err)
+	}
+
+	return string(out), nil
+}
+
+const consoleLogFile = "console.log"
+
+// saveConsoleLog copies the console ring buffer of the instance to the
+// machine store, so it can be looked at without access to Incus
+func (d *Driver) saveConsoleLog() {
+	if !d.ConsoleLog {
+		return
+	}
+
+	console, err := d.GetConsoleLog()
+	if err != nil {
+		log.Warnf("Unable to save console of %s: %s", d.MachineName, err)
+		return
+	}
+
+	path := d.ResolveStorePath(consoleLogFile)
+	if err := os.WriteFile(path, []byte(console), 0600); err != nil {
+		log.Warnf("Unable to save console of %s: %s", d.MachineName, err)
+		return
+	}
+
+	log.Debugf("Console output of %s saved to %s", d.MachineName, path)
+}
+
+// dumpConsoleLog logs the console output to help debugging boot failures
+func (d *Driver) dumpConsoleLog() {
+	console, err := d.GetConsoleLog()
+	if err != nil {
+		log.Warnf("Unable to dump console of %s: %s", d.MachineName, err)
+		return
+	}
+
+	log.Infof("Console output of %s:\n%s", d.MachineName, console)
+}
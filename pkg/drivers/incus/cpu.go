@@ -0,0 +1,158 @@
+package incus
+
+import (
+	"fmt"
+	"regexp"
+	"slices"
+	"strings"
+
+	"github.com/lxc/incus/v6/shared/api"
+)
+
+// hostCPUModel passes the CPU of the server through to the guest
+const hostCPUModel = "host"
+
+var (
+	cpuModelRegexp = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9_.-]*$`)
+	cpuFlagRegexp  = regexp.MustCompile(`^[+-]?[a-z0-9][a-z0-9_.-]*$`)
+)
+
+func parseCPUModel(value string) (string, error) {
+	value = strings.TrimSpace(value)
+	if value != "" && !cpuModelRegexp.MatchString(value) {
+		return "", fmt.Errorf("invalid CPU model %q", value)
+	}
+
+	return value, nil
+}
+
+// parseCPUFlags parses a comma-separated list of CPU flags, a flag prefixed
+// with - is removed from the CPU model
+func parseCPUFlags(value string) ([]string, error) {
+	flags := []string{}
+	for _, flag := range strings.Split(value, ",") {
+		flag = strings.TrimSpace(flag)
+		if flag == "" {
+			continue
+		}
+		if !cpuFlagRegexp.MatchString(flag) {
+			return nil, fmt.Errorf("invalid CPU flag %q", flag)
+		}
+		flags = append(flags, flag)
+	}
+
+	return flags, nil
+}
+
+// cpuModel returns the QEMU CPU model, flags alone are applied on top of
+// the host CPU
+func (d *Driver) cpuModel() string {
+	if d.CPUModel == "" && len(d.CPUFlags) > 0 {
+		return hostCPUModel
+	}
+
+	return d.CPUModel
+}
+
+// cpuConfig overrides the CPU QEMU exposes to the guest; Incus has no key
+// for it, the -cpu argument appended last replaces the one it generates
+func (d *Driver) cpuConfig(config map[string]string) error {
+	model := d.cpuModel()
+	if model == "" {
+		return nil
+	}
+
+	if d.instanceType() != api.InstanceTypeVM {
+		return fmt.Errorf("CPU model and flags are only supported for virtual machine instances")
+	}
+
+	cpu := []string{model}
+	for _, flag := range d.CPUFlags {
+		if name, found := strings.CutPrefix(flag, "-"); found {
+			cpu = append(cpu, name+"=off")
+			continue
+		}
+		cpu = append(cpu, strings.TrimPrefix(flag, "+")+"=on")
+	}
+	config["raw.qemu"] = "-cpu " + strings.Join(cpu, ",")
+
+	return nil
+}
+
+// checkCPU verifies the project allows raw QEMU arguments, the profile does
+// not set its own and, with the host CPU, that the flags requested are part
+// of the CPU of the server
+func (d *Driver) checkCPU() error {
+	if d.cpuModel() == "" {
+		return nil
+	}
+
+	client, err := d.getClient()
+	if err != nil {
+		return err
+	}
+
+	project, _, err := client.GetProject(d.Project)
+	if err == nil && project.Config["restricted"] == "true" && project.Config["restricted.virtual-machines.lowlevel"] != "allow" {
+		return fmt.Errorf("project %s does not allow setting the CPU model, restricted.virtual-machines.lowlevel must be allow", d.Project)
+	}
+
+	// raw.qemu of the instance replaces the one of the profile instead of
+	// adding to it, which would silently drop the profile arguments
+	profile, _, err := client.GetProfile(d.Profile)
+	if err != nil {
+		return fmt.Errorf("failed to get profile %s: %w", d.Profile, err)
+	}
+	if profile.Config["raw.qemu"] != "" {
+		return fmt.Errorf("profile %s sets raw.qemu, which the CPU model and flags would replace; set the -cpu argument in the profile instead", d.Profile)
+	}
+
+	required := []string{}
+	for _, flag := range d.CPUFlags {
+		if !strings.HasPrefix(flag, "-") {
+			required = append(required, strings.TrimPrefix(flag, "+"))
+		}
+	}
+	if d.cpuModel() != hostCPUModel || len(required) == 0 {
+		return nil
+	}
+
+	supported, err := d.hasExtension("resources_cpu_flags")
+	if err != nil {
+		return err
+	}
+	if !supported {
+		return fmt.Errorf("the Incus server does not report its CPU flags, unable to check %s are available", strings.Join(required, ", "))
+	}
+
+	if member := d.targetMember(); member != "" && client.IsClustered() {
+		client = client.UseTarget(member)
+	}
+	resources, err := client.GetServerResources()
+	if err != nil {
+		return fmt.Errorf("failed to get server resources: %w", err)
+	}
+
+	// /proc/cpuinfo spells - and . of the QEMU names as _
+	normalize := strings.NewReplacer("-", "_", ".", "_")
+	hostFlags := []string{}
+	for _, socket := range resources.CPU.Sockets {
+		for _, core := range socket.Cores {
+			for _, flag := range core.Flags {
+				hostFlags = append(hostFlags, normalize.Replace(flag))
+			}
+		}
+	}
+
+	missing := []string{}
+	for _, flag := range required {
+		if !slices.Contains(hostFlags, normalize.Replace(flag)) {
+			missing = append(missing, flag)
+		}
+	}
+	if len(missing) > 0 {
+		return fmt.Errorf("the CPU of the server does not support %s", strings.Join(missing, ", "))
+	}
+
+	return nil
+}
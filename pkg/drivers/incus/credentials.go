@@ -0,0 +1,168 @@
+package incus
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"text/tabwriter"
+
+	localtls "github.com/lxc/incus/v6/shared/tls"
+)
+
+// machineStorePath returns the docker-machine store, honoring the same
+// environment variable as docker-machine
+func machineStorePath() (string, error) {
+	if path := os.Getenv("MACHINE_STORAGE_PATH"); path != "" {
+		return path, nil
+	}
+
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "", err
+	}
+
+	return filepath.Join(home, ".docker", "machine"), nil
+}
+
+// RotateCredentials switches the machines of the store to a new client
+// certificate and key, only updating the configs of the machines whose
+// server trusts the new certificate; an optional trust token enrolls the
+// certificate with the first server not trusting it yet that accepts the
+// token
+func RotateCredentials(w io.Writer, certPath, keyPath, token string) error {
+	cert, err := os.ReadFile(certPath)
+	if err != nil {
+		return fmt.Errorf("failed to read client certificate: %w", err)
+	}
+	key, err := os.ReadFile(keyPath)
+	if err != nil {
+		return fmt.Errorf("failed to read client key: %w", err)
+	}
+	if token != "" {
+		if _, err := localtls.CertificateTokenDecode(token); err != nil {
+			return fmt.Errorf("invalid trust token: %w", err)
+		}
+	}
+
+	store, err := machineStorePath()
+	if err != nil {
+		return err
+	}
+
+	configs, err := filepath.Glob(filepath.Join(store, "machines", "*", "config.json"))
+	if err != nil {
+		return err
+	}
+
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "NAME\tURL\tRESULT")
+	failed := 0
+	for _, path := range configs {
+		name := filepath.Base(filepath.Dir(path))
+		url, err := rotateMachineCredentials(path, string(cert), string(key), &token)
+		if url == "" {
+			continue
+		}
+
+		result := "rotated"
+		if err != nil {
+			result = err.Error()
+			failed++
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\n", name, url, result)
+	}
+	if err := tw.Flush(); err != nil {
+		return err
+	}
+
+	if failed > 0 {
+		return fmt.Errorf("%d machines still use the previous credentials", failed)
+	}
+
+	return nil
+}
+
+// rotateMachineCredentials updates one machine config once the new
+// credentials are verified, returning an empty URL for machines of other
+// drivers; the trust token is cleared once used as it is single use
+func rotateMachineCredentials(path, cert, key string, token *string) (string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return path, err
+	}
+
+	// only the driver credentials are replaced, the rest of the host config
+	// is written back untouched
+	host := map[string]json.RawMessage{}
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	if err := decoder.Decode(&host); err != nil {
+		return path, fmt.Errorf("invalid machine config: %w", err)
+	}
+
+	var driverType string
+	if err := json.Unmarshal(host["DriverName"], &driverType); err != nil || driverType != driverName {
+		return "", nil
+	}
+
+	d := NewDriver("", "").(*Driver)
+	if err := json.Unmarshal(host["Driver"], d); err != nil {
+		return path, fmt.Errorf("invalid driver config: %w", err)
+	}
+
+	d.TLSClientCert = cert
+	d.TLSClientKey = key
+	defer d.Close()
+	server, err := d.connect()
+	if err != nil {
+		return d.URL, err
+	}
+	info, _, err := server.GetServer()
+	if err != nil {
+		return d.URL, err
+	}
+	if info.Auth != "trusted" && *token != "" {
+		d.trustToken = *token
+		if info, err = d.enrollTrustToken(server); err != nil {
+			return d.URL, fmt.Errorf("new certificate is not trusted and %w", err)
+		}
+		*token = ""
+	}
+	if info.Auth != "trusted" {
+		return d.URL, fmt.Errorf("new certificate is not trusted")
+	}
+
+	driver := map[string]interface{}{}
+	decoder = json.NewDecoder(bytes.NewReader(host["Driver"]))
+	decoder.UseNumber()
+	if err := decoder.Decode(&driver); err != nil {
+		return d.URL, err
+	}
+	driver["TLSClientCert"] = cert
+	driver["TLSClientKey"] = key
+
+	if host["Driver"], err = json.Marshal(driver); err != nil {
+		return d.URL, err
+	}
+
+	return d.URL, writeMachineConfig(path, host)
+}
+
+// writeMachineConfig replaces a machine config, through a temporary file so
+// a failed write leaves the previous one in place
+func writeMachineConfig(path string, host map[string]json.RawMessage) error {
+	out, err := json.MarshalIndent(host, "", "    ")
+	if err != nil {
+		return err
+	}
+
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, out, 0600); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp, path)
+}
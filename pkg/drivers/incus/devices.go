@@ -0,0 +1,34 @@
+package incus
+
+import (
+	"slices"
+
+	incus "github.com/lxc/incus/v6/client"
+)
+
+// defaultDevicePrefix marks the devices the driver adds to the instance
+const defaultDevicePrefix = "dm-"
+
+// deviceName returns the name of a device owned by the driver; machines
+// created before the prefix existed keep their unprefixed names
+func (d *Driver) deviceName(name string) string {
+	return d.DevicePrefix + name
+}
+
+// recordDevice remembers a device the driver added to the instance
+func (d *Driver) recordDevice(name string) {
+	if !slices.Contains(d.Devices, name) {
+		d.Devices = append(d.Devices, name)
+	}
+}
+
+// getProfileDevices returns the devices the profile adds to the instance,
+// which the driver only replaces on purpose by reusing their name
+func (d *Driver) getProfileDevices(client incus.InstanceServer) (map[string]map[string]string, error) {
+	profile, _, err := client.GetProfile(d.Profile)
+	if err != nil {
+		return nil, err
+	}
+
+	return profile.Devices, nil
+}
@@ -0,0 +1,88 @@
+package incus
+
+import (
+	"slices"
+
+	"github.com/docker/machine/libmachine/log"
+	incus "github.com/lxc/incus/v6/client"
+)
+
+// discoverDefaults fills the storage and network left empty with the ones
+// the profile uses, or the only candidate of the server, as the names of a
+// default setup are not guaranteed to exist
+func (d *Driver) discoverDefaults(client incus.InstanceServer) {
+	if d.Storage != "" && (d.Network != "" || d.NoNIC || d.ProfileOnly) {
+		return
+	}
+
+	devices, err := d.getProfileDevices(client)
+	if err != nil {
+		log.Debugf("Unable to inspect profile %s: %s", d.Profile, err)
+	}
+
+	if d.Storage == "" {
+		d.Storage = d.discoverStorage(client, devices)
+		log.Infof("Using storage %s", d.Storage)
+	}
+
+	if d.Network == "" && !d.NoNIC && !d.ProfileOnly {
+		d.Network = d.discoverNetwork(devices)
+		log.Infof("Using network %s", d.Network)
+	}
+}
+
+// discoverStorage returns the pool of the profile root disk, or the only
+// pool of the server
+func (d *Driver) discoverStorage(client incus.InstanceServer, devices map[string]map[string]string) string {
+	if _, device := findRootDevice(devices); device["pool"] != "" {
+		return device["pool"]
+	}
+
+	pools, err := client.GetStoragePoolNames()
+	if err == nil && len(pools) == 1 {
+		return pools[0]
+	}
+
+	return defaultStorage
+}
+
+// discoverNetwork returns the managed network of the profile NIC, preferring
+// eth0, or the only bridge or OVN network of the project
+func (d *Driver) discoverNetwork(devices map[string]map[string]string) string {
+	if device := devices[defaultNICName]; device["type"] == "nic" && device["network"] != "" {
+		return device["network"]
+	}
+
+	// a single NIC of another name the profile provides
+	nics := []string{}
+	for _, device := range devices {
+		if device["type"] == "nic" && device["network"] != "" {
+			nics = append(nics, device["network"])
+		}
+	}
+	if len(nics) == 1 {
+		return nics[0]
+	}
+
+	client, err := d.getNetworkClient()
+	if err != nil {
+		return defaultNetwork
+	}
+
+	networks, err := client.GetNetworks()
+	if err != nil {
+		return defaultNetwork
+	}
+
+	candidates := []string{}
+	for _, network := range networks {
+		if network.Managed && slices.Contains([]string{"bridge", "ovn"}, network.Type) {
+			candidates = append(candidates, network.Name)
+		}
+	}
+	if len(candidates) == 1 {
+		return candidates[0]
+	}
+
+	return defaultNetwork
+}
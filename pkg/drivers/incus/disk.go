@@ -0,0 +1,94 @@
+package incus
+
+import (
+	"fmt"
+	"maps"
+
+	"github.com/docker/machine/libmachine/log"
+	"github.com/lxc/incus/v6/shared/api"
+)
+
+// growRootScript grows the partition and filesystem holding / in place
+const growRootScript = `set -e
+dev=$(findmnt -n -o SOURCE /)
+name=$(basename "$dev")
+if [ -e "/sys/class/block/$name/partition" ]; then
+  growpart "/dev/$(lsblk -n -o PKNAME "$dev")" "$(cat /sys/class/block/$name/partition)" || true
+fi
+case "$(findmnt -n -o FSTYPE /)" in
+  xfs) xfs_growfs / ;;
+  btrfs) btrfs filesystem resize max / ;;
+  *) resize2fs "$dev" ;;
+esac
+`
+
+// GrowDisk grows the root disk of a running machine to the given size (in
+// MiB) and expands the guest filesystem of virtual machines without
+// reprovisioning
+func (d *Driver) GrowDisk(size int) error {
+	if size <= d.DiskSize {
+		return fmt.Errorf("new disk size %dMiB must be larger than the current %dMiB", size, d.DiskSize)
+	}
+
+	client, err := d.getClient()
+	if err != nil {
+		return err
+	}
+
+	instance, etag, err := client.GetInstance(d.MachineName)
+	if err != nil {
+		return err
+	}
+
+	// prefer the root device the driver created, then any local one,
+	// otherwise override the profile one
+	name, device := d.RootDevice, instance.Devices[d.RootDevice]
+	if device == nil {
+		name, device = findRootDevice(instance.Devices)
+	}
+	if device == nil {
+		name, device = findRootDevice(instance.ExpandedDevices)
+	}
+	if device == nil {
+		return fmt.Errorf("instance %s has no root disk device", d.MachineName)
+	}
+
+	device = maps.Clone(device)
+	device["size"] = fmt.Sprintf("%dMiB", size)
+	instance.Devices[name] = device
+
+	log.Infof("Growing root disk of %s to %dMiB...", d.MachineName, size)
+	op, err := client.UpdateInstance(d.MachineName, instance.Writable(), etag)
+	if err != nil {
+		return fmt.Errorf("failed to resize root disk: %w", d.instancePermissionError(err, "can_edit"))
+	}
+
+	err = d.waitOperation(op)
+	if err != nil {
+		return fmt.Errorf("failed to resize root disk: %w", err)
+	}
+	d.recordInstance(instance.Writable())
+	d.DiskSize = size
+
+	// the root of a container is a dataset Incus already resized
+	if d.instanceType() == api.InstanceTypeContainer {
+		return nil
+	}
+
+	if _, err := d.exec(client, "sh", "-c", growRootScript); err != nil {
+		return fmt.Errorf("root disk resized but growing the filesystem failed: %w", err)
+	}
+
+	return nil
+}
+
+// findRootDevice returns the disk device mounted on / if any
+func findRootDevice(devices map[string]map[string]string) (string, map[string]string) {
+	for name, device := range devices {
+		if device["type"] == "disk" && device["path"] == "/" {
+			return name, device
+		}
+	}
+
+	return "", nil
+}
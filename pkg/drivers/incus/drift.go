@@ -0,0 +1,98 @@
+package incus
+
+import (
+	"fmt"
+	"maps"
+	"slices"
+	"strings"
+
+	"github.com/docker/machine/libmachine/log"
+	"github.com/lxc/incus/v6/shared/api"
+)
+
+// recordInstance keeps the local config and devices the driver last applied
+// to the instance, as the reference to detect manual changes against
+func (d *Driver) recordInstance(put api.InstancePut) {
+	d.InstanceProfiles = slices.Clone(put.Profiles)
+	d.InstanceConfig = map[string]string{}
+	for key, value := range put.Config {
+		// volatile keys are maintained by Incus itself
+		if !strings.HasPrefix(key, "volatile.") {
+			d.InstanceConfig[key] = value
+		}
+	}
+
+	d.InstanceDevices = map[string]map[string]string{}
+	for name, device := range put.Devices {
+		d.InstanceDevices[name] = maps.Clone(device)
+	}
+}
+
+// CheckDrift compares the live instance against the config the driver
+// applied and returns a description of every difference
+func (d *Driver) CheckDrift() ([]string, error) {
+	if d.InstanceConfig == nil {
+		return nil, fmt.Errorf("no recorded config for %s, it was created by an older driver", d.MachineName)
+	}
+
+	client, err := d.getClient()
+	if err != nil {
+		return nil, err
+	}
+
+	instance, _, err := client.GetInstance(d.MachineName)
+	if err != nil {
+		return nil, err
+	}
+
+	drift := []string{}
+	if !slices.Equal(instance.Profiles, d.InstanceProfiles) {
+		drift = append(drift, fmt.Sprintf("profiles changed from %s to %s",
+			strings.Join(d.InstanceProfiles, ","), strings.Join(instance.Profiles, ",")))
+	}
+
+	for key, value := range d.InstanceConfig {
+		live, ok := instance.Config[key]
+		if !ok {
+			drift = append(drift, fmt.Sprintf("config %s removed", key))
+		} else if live != value {
+			drift = append(drift, fmt.Sprintf("config %s changed", key))
+		}
+	}
+	for key := range instance.Config {
+		if _, ok := d.InstanceConfig[key]; !ok && !strings.HasPrefix(key, "volatile.") {
+			drift = append(drift, fmt.Sprintf("config %s added", key))
+		}
+	}
+
+	for name, device := range d.InstanceDevices {
+		live, ok := instance.Devices[name]
+		if !ok {
+			drift = append(drift, fmt.Sprintf("device %s removed", name))
+		} else if !maps.Equal(live, device) {
+			drift = append(drift, fmt.Sprintf("device %s changed", name))
+		}
+	}
+	for name := range instance.Devices {
+		if _, ok := d.InstanceDevices[name]; !ok {
+			drift = append(drift, fmt.Sprintf("device %s added", name))
+		}
+	}
+
+	slices.Sort(drift)
+	return drift, nil
+}
+
+// warnDrift logs manual changes of the instance before an operation that
+// carries them over or depends on the recorded config
+func (d *Driver) warnDrift() {
+	drift, err := d.CheckDrift()
+	if err != nil {
+		log.Debugf("Unable to check drift of %s: %s", d.MachineName, err)
+		return
+	}
+
+	for _, change := range drift {
+		log.Warnf("Instance %s drifted from the driver config: %s", d.MachineName, change)
+	}
+}
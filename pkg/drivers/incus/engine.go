@@ -0,0 +1,49 @@
+package incus
+
+import (
+	"fmt"
+	"net/url"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/docker/machine/libmachine/log"
+)
+
+const (
+	defaultEngineInstallURL = "https://get.docker.com"
+	engineInstallLogFile    = "/var/log/incus-engine-install.log"
+	// engineInstallTimeout bounds the wait for the pre-installed engine when
+	// no cloud-init timeout is configured
+	engineInstallTimeout = 15 * time.Minute
+	// engineDistroInstallCmd installs the engine packaged by the distribution
+	// on the architectures the Docker repositories do not cover
+	engineDistroInstallCmd = "apt-get install -y docker.io || dnf install -y moby-engine || apk add docker"
+)
+
+// parseEngineInstallURL validates the script URL the guest downloads the
+// engine installer from
+func parseEngineInstallURL(value string) (string, error) {
+	u, err := url.Parse(value)
+	if err != nil || (u.Scheme != "https" && u.Scheme != "http") || u.Host == "" || strings.ContainsAny(value, "'\\") {
+		return "", fmt.Errorf("invalid engine install URL %q", value)
+	}
+
+	return value, nil
+}
+
+// getEngineInstallCmd returns the command installing the engine from cloud-init
+// so the provisioner finds it already installed
+func (d *Driver) getEngineInstallCmd() string {
+	if !d.EnginePreinstall {
+		return ""
+	}
+
+	// a custom script is trusted to know the architectures it supports
+	if arch := d.guestArchitecture(); arch != "" && d.EngineInstallURL == defaultEngineInstallURL && !slices.Contains(engineScriptArchitectures, arch) {
+		log.Debugf("Docker repositories have no %s packages, installing the engine of the distribution", arch)
+		return fmt.Sprintf("command -v docker >/dev/null || { %s; } >%s 2>&1", engineDistroInstallCmd, engineInstallLogFile)
+	}
+
+	return fmt.Sprintf("command -v docker >/dev/null || curl -fsSL '%s' | sh >%s 2>&1", d.EngineInstallURL, engineInstallLogFile)
+}
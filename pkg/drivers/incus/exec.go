@@ -0,0 +1,120 @@
+package incus
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/docker/machine/libmachine/log"
+	incus "github.com/lxc/incus/v6/client"
+	"github.com/lxc/incus/v6/shared/api"
+)
+
+// exec runs a command in the instance through the Incus agent and returns
+// its combined output, failing on a non-zero exit code
+func (d *Driver) exec(client incus.InstanceServer, command ...string) (string, error) {
+	return d.execContext(context.Background(), client, command...)
+}
+
+// execContext is exec bounded by the given context
+func (d *Driver) execContext(ctx context.Context, client incus.InstanceServer, command ...string) (string, error) {
+	var output bytes.Buffer
+
+	req := api.InstanceExecPost{
+		Command:   command,
+		WaitForWS: true,
+	}
+
+	dataDone := make(chan bool)
+	args := &incus.InstanceExecArgs{
+		Stdout:   &output,
+		Stderr:   &output,
+		DataDone: dataDone,
+	}
+
+	log.Debugf("Running %q in instance %s", strings.Join(command, " "), d.MachineName)
+	op, err := client.ExecInstance(d.MachineName, req, args)
+	if err != nil {
+		return "", fmt.Errorf("failed to run %s: %w", command[0], d.instancePermissionError(err, "can_exec"))
+	}
+
+	err = operationError(op, op.WaitContext(ctx))
+	if err != nil {
+		return output.String(), fmt.Errorf("failed to run %s: %w", command[0], err)
+	}
+	<-dataDone
+
+	if code, ok := op.Get().Metadata["return"].(float64); ok && code != 0 {
+		return output.String(), fmt.Errorf("%s exited with status %d: %s", command[0], int(code), strings.TrimSpace(output.String()))
+	}
+
+	return output.String(), nil
+}
+
+// waitForAgent waits until the Incus agent of the guest accepts commands
+func (d *Driver) waitForAgent(client incus.InstanceServer) error {
+	err := retry(d.timeouts().Agent, func() (bool, error) {
+		_, err := d.exec(client, "true")
+		return err == nil, err
+	})
+	if err != nil {
+		return fmt.Errorf("waiting for the incus agent: %w", err)
+	}
+
+	return nil
+}
+
+// RunCommand runs a shell command in the guest like RunSSHCommandFromDriver,
+// going through the Incus agent while the SSH server is not reachable yet.
+// libmachine has no hook for it, its provisioner always runs its commands
+// over its own SSH client, so only the --run-command subcommand and the
+// programs embedding the driver use it. The SSH session verifies the host
+// key recorded at create, without one the command goes through the agent
+func (d *Driver) RunCommand(command string) (string, error) {
+	port, err := d.GetSSHPort()
+	if err != nil {
+		return "", err
+	}
+
+	if d.IPAddress != "" && d.hasHostKeys() {
+		conn, err := net.DialTimeout("tcp", net.JoinHostPort(d.IPAddress, fmt.Sprintf("%d", port)), 2*time.Second)
+		if err == nil {
+			conn.Close()
+			return d.runSSH(command)
+		}
+	}
+
+	client, err := d.getClient()
+	if err != nil {
+		return "", err
+	}
+
+	log.Debugf("SSH of %s not reachable, running command through the Incus agent", d.MachineName)
+	return d.exec(client, "sh", "-c", command)
+}
+
+// runSSH runs a shell command over SSH, checking the host key of the guest
+func (d *Driver) runSSH(command string) (string, error) {
+	conn, err := d.dialSSH(d.GetSSHKeyPath())
+	if err != nil {
+		return "", fmt.Errorf("failed to connect to %s: %w", d.MachineName, err)
+	}
+	defer conn.Close()
+
+	session, err := conn.NewSession()
+	if err != nil {
+		return "", err
+	}
+	defer session.Close()
+
+	log.Debugf("About to run SSH command:\n%s", command)
+	output, err := session.CombinedOutput(command)
+	if err != nil {
+		return "", fmt.Errorf("ssh command error:\ncommand : %s\nerr     : %v\noutput  : %s", command, err, output)
+	}
+
+	return string(output), nil
+}
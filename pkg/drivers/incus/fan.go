@@ -0,0 +1,77 @@
+package incus
+
+import (
+	"fmt"
+	"slices"
+	"strconv"
+
+	"github.com/docker/machine/libmachine/log"
+	incus "github.com/lxc/incus/v6/client"
+	"github.com/lxc/incus/v6/shared/api"
+)
+
+// mtus Incus uses for fan bridges, depending on the tunnel type
+const (
+	defaultFanVXLANMTU = 1450
+	defaultFanIPIPMTU  = 1480
+)
+
+// isFanBridge reports whether the bridge spans the cluster through a fan
+// overlay instead of being local to each member
+func isFanBridge(network *api.Network) bool {
+	return network.Type == "bridge" && network.Config["bridge.mode"] == "fan"
+}
+
+// checkFanBridge makes sure a fan bridge is usable from every online cluster
+// member, and warns about plain bridges which do not reach across members
+func checkFanBridge(client incus.InstanceServer, network *api.Network) error {
+	if !client.IsClustered() {
+		return nil
+	}
+
+	if !isFanBridge(network) {
+		log.Warnf("Network %s is a local bridge, machines on different cluster members will not reach each other", network.Name)
+		return nil
+	}
+
+	if network.Status != "" && network.Status != api.NetworkStatusCreated {
+		return fmt.Errorf("fan bridge %s is not ready (status %s)", network.Name, network.Status)
+	}
+
+	if fanType := network.Config["fan.type"]; fanType != "" && fanType != "vxlan" && fanType != "ipip" {
+		return fmt.Errorf("fan bridge %s has unsupported fan.type %s", network.Name, fanType)
+	}
+
+	members, err := client.GetClusterMembers()
+	if err != nil {
+		return fmt.Errorf("failed to list cluster members: %w", err)
+	}
+
+	for _, member := range members {
+		if member.Status != "Online" {
+			continue
+		}
+		if len(network.Locations) > 0 && !slices.Contains(network.Locations, member.ServerName) {
+			return fmt.Errorf("fan bridge %s is not defined on cluster member %s", network.Name, member.ServerName)
+		}
+	}
+
+	return nil
+}
+
+// getFanMTU returns the mtu the guest must use on a fan bridge
+func getFanMTU(network *api.Network) (int, error) {
+	if value := network.Config["bridge.mtu"]; value != "" {
+		mtu, err := strconv.Atoi(value)
+		if err != nil {
+			return 0, fmt.Errorf("invalid bridge.mtu %q on network %s: %w", value, network.Name, err)
+		}
+		return mtu, nil
+	}
+
+	if network.Config["fan.type"] == "ipip" {
+		return defaultFanIPIPMTU, nil
+	}
+
+	return defaultFanVXLANMTU, nil
+}
@@ -0,0 +1,117 @@
+package incus
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+const (
+	firewallService   = "docker-machine-firewall.service"
+	firewallRulesPath = "/etc/docker-machine/firewall.nft"
+	firewallUnitPath  = "/etc/systemd/system/" + firewallService
+	firewallUnit      = `[Unit]
+Description=docker-machine input firewall
+Wants=network-pre.target
+Before=network-pre.target
+
+[Service]
+Type=oneshot
+RemainAfterExit=yes
+ExecStart=/usr/sbin/nft -f ` + firewallRulesPath + `
+ExecStop=/usr/sbin/nft delete table inet docker-machine
+
+[Install]
+WantedBy=multi-user.target
+`
+)
+
+// getFirewallRules renders an nftables table only filtering the input hook,
+// so the forward rules Docker manages for published ports are left alone
+func (d *Driver) getFirewallRules() string {
+	ports := map[string][]string{
+		"tcp": {strconv.Itoa(d.SSHPort), strconv.Itoa(dockerPort)},
+		"udp": {},
+	}
+	for _, port := range d.OpenPorts {
+		number, protocol, _ := strings.Cut(port, "/")
+		ports[protocol] = append(ports[protocol], number)
+	}
+
+	rules := []string{
+		"ct state established,related accept",
+		"ct state invalid drop",
+		`iifname "lo" accept`,
+		"meta l4proto { icmp, ipv6-icmp } accept",
+		// DHCP replies are not tracked as related to the request
+		"udp dport { 68, 546 } accept",
+	}
+	for _, protocol := range []string{"tcp", "udp"} {
+		if len(ports[protocol]) > 0 {
+			rules = append(rules, fmt.Sprintf("%s dport { %s } accept", protocol, strings.Join(ports[protocol], ", ")))
+		}
+	}
+
+	return fmt.Sprintf(`table inet docker-machine
+delete table inet docker-machine
+table inet docker-machine {
+	chain input {
+		type filter hook input priority filter; policy drop;
+		%s
+	}
+}
+`, strings.Join(rules, "\n\t\t"))
+}
+
+// parseOpenPorts parses a comma-separated list of port[-end][/protocol]
+// entries, the protocol defaulting to tcp
+func parseOpenPorts(value string) ([]string, error) {
+	ports := []string{}
+	for _, entry := range strings.Split(value, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+
+		number, protocol, found := strings.Cut(entry, "/")
+		if !found {
+			protocol = "tcp"
+		}
+		if protocol != "tcp" && protocol != "udp" {
+			return nil, fmt.Errorf("invalid protocol in open port %q", entry)
+		}
+
+		start, end, isRange := strings.Cut(number, "-")
+		if !isRange {
+			end = start
+		}
+
+		first, err := parsePort(start)
+		if err != nil {
+			return nil, fmt.Errorf("invalid open port %q: %w", entry, err)
+		}
+		last, err := parsePort(end)
+		if err != nil {
+			return nil, fmt.Errorf("invalid open port %q: %w", entry, err)
+		}
+		if first > last {
+			return nil, fmt.Errorf("invalid open port range %q", entry)
+		}
+
+		ports = append(ports, number+"/"+protocol)
+	}
+
+	return ports, nil
+}
+
+func parsePort(value string) (int, error) {
+	port, err := strconv.Atoi(value)
+	if err != nil {
+		return 0, err
+	}
+	if port < 1 || port > 65535 {
+		return 0, fmt.Errorf("port %d out of range", port)
+	}
+
+	return port, nil
+}
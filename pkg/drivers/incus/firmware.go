@@ -0,0 +1,58 @@
+package incus
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+)
+
+// firmwares maps the --incus-firmware choices to the config selecting the
+// matching EDK2 build on the server
+var firmwares = map[string]map[string]string{
+	"secureboot": {"security.secureboot": "true", "security.csm": "false"},
+	"uefi":       {"security.secureboot": "false", "security.csm": "false"},
+	"csm":        {"security.secureboot": "false", "security.csm": "true"},
+}
+
+func parseFirmware(value string) (string, error) {
+	if value == "" {
+		return "", nil
+	}
+
+	if _, ok := firmwares[value]; !ok {
+		return "", fmt.Errorf("invalid firmware %q, expected one of: secureboot, uefi, csm", value)
+	}
+
+	return value, nil
+}
+
+// checkFirmware makes sure the server is able to boot the instance with the
+// selected firmware
+func (d *Driver) checkFirmware() error {
+	if d.Firmware != "csm" {
+		return nil
+	}
+
+	supported, err := d.hasExtension("security_csm")
+	if err != nil {
+		return err
+	}
+	if !supported {
+		return fmt.Errorf("the Incus server does not support CSM firmware")
+	}
+
+	// the servers only ship a CSM enabled build for x86
+	if d.ImageArchitecture != "" && d.ImageArchitecture != "x86_64" {
+		return fmt.Errorf("CSM firmware is not available for %s instances", d.ImageArchitecture)
+	}
+
+	server, err := d.getServer()
+	if err != nil {
+		return err
+	}
+	if !slices.Contains(server.Environment.Architectures, "x86_64") {
+		return fmt.Errorf("CSM firmware is not available on servers running %s", strings.Join(server.Environment.Architectures, ", "))
+	}
+
+	return nil
+}
@@ -0,0 +1,104 @@
+package incus
+
+import (
+	"cmp"
+	"fmt"
+	"io"
+	"os"
+	"slices"
+	"text/tabwriter"
+
+	"github.com/lxc/incus/v6/shared/api"
+)
+
+// instance config keys tagging the instances created by the driver
+const (
+	machineNameKey    = "user.docker-machine.name"
+	machineClusterKey = "user.docker-machine.cluster"
+)
+
+// machineKeys returns the user keys identifying the instance as a machine
+func (d *Driver) machineKeys() map[string]string {
+	keys := map[string]string{machineNameKey: d.MachineName}
+	if d.MachineCluster != "" {
+		keys[machineClusterKey] = d.MachineCluster
+	}
+
+	return keys
+}
+
+// ListMachines writes the instances created by the driver in the project of
+// the server INCUS_URL points to, grouped by their cluster
+func ListMachines(w io.Writer) error {
+	d := NewDriver("", "").(*Driver)
+	d.URL = os.Getenv("INCUS_URL")
+	d.Project = cmp.Or(os.Getenv("INCUS_PROJECT"), "default")
+	if err := d.tlsFromEnv(); err != nil {
+		return err
+	}
+	if d.URL == "" {
+		return fmt.Errorf("INCUS_URL is required to list machines")
+	}
+
+	client, err := d.getClient()
+	if err != nil {
+		return err
+	}
+
+	instances, err := client.GetInstancesFull(api.InstanceTypeAny)
+	if err != nil {
+		return d.projectPermissionError(err, "can_view")
+	}
+
+	machines := []api.InstanceFull{}
+	for _, instance := range instances {
+		if instance.Config[machineNameKey] != "" {
+			machines = append(machines, instance)
+		}
+	}
+	slices.SortFunc(machines, func(a, b api.InstanceFull) int {
+		return cmp.Or(
+			cmp.Compare(a.Config[machineClusterKey], b.Config[machineClusterKey]),
+			cmp.Compare(a.Name, b.Name),
+		)
+	})
+
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "CLUSTER\tNAME\tSTATE\tIP\tLOCATION\tIMAGE CREATED")
+	for _, machine := range machines {
+		address := ""
+		if machine.State != nil {
+			address = machineAddress(machine.State)
+		}
+
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\t%s\n",
+			cmp.Or(machine.Config[machineClusterKey], "-"), machine.Name, machine.Status,
+			cmp.Or(address, "-"), cmp.Or(machine.Location, "-"), cmp.Or(machine.Config[imageKeyPrefix+"created"], "-"))
+	}
+
+	return tw.Flush()
+}
+
+// machineAddress returns the first global address of the instance,
+// preferring IPv4
+func machineAddress(state *api.InstanceState) string {
+	names := []string{}
+	for name := range state.Network {
+		if name != "lo" {
+			names = append(names, name)
+		}
+	}
+	slices.Sort(names)
+
+	for _, family := range []string{"inet", "inet6"} {
+		for _, name := range names {
+			for _, addr := range state.Network[name].Addresses {
+				if addr.Family == family && addr.Scope == "global" {
+					return addr.Address
+				}
+			}
+		}
+	}
+
+	return ""
+}
@@ -0,0 +1,97 @@
+package incus
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	incus "github.com/lxc/incus/v6/client"
+	"github.com/lxc/incus/v6/shared/api"
+	"github.com/lxc/incus/v6/shared/units"
+)
+
+// defaultVMMemory is the memory Incus gives virtual machines without limit
+const defaultVMMemory = 1024 * 1024 * 1024
+
+// hasGuardrails reports whether the oversubscription checks are enabled
+func (d *Driver) hasGuardrails() bool {
+	return d.MaxMachinesPerMember > 0 || d.MaxMemoryCommitment > 0
+}
+
+// checkGuardrails refuses a server, or a cluster member when member is set,
+// already hosting too many machines or committing too much of its memory
+// with the new instance
+func (d *Driver) checkGuardrails(client incus.InstanceServer, member string) error {
+	if !d.hasGuardrails() {
+		return nil
+	}
+
+	// restricted certificates only see the instances of their projects
+	instances, err := client.GetInstancesAllProjects(api.InstanceTypeAny)
+	if api.StatusErrorCheck(err, http.StatusForbidden) {
+		instances, err = client.GetInstances(api.InstanceTypeAny)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to list instances: %w", err)
+	}
+
+	resources, err := client.GetServerResources()
+	if err != nil {
+		return fmt.Errorf("failed to get server resources: %w", err)
+	}
+	total := int64(resources.Memory.Total)
+
+	machines := 0
+	committed := int64(d.Memory) * 1024 * 1024
+	for _, instance := range instances {
+		if member != "" && instance.Location != member {
+			continue
+		}
+
+		if instance.Config[machineNameKey] != "" {
+			machines++
+		}
+		committed += memoryLimit(instance, total)
+	}
+
+	if d.MaxMachinesPerMember > 0 && machines >= d.MaxMachinesPerMember {
+		return fmt.Errorf("already hosting %d machines, the limit is %d", machines, d.MaxMachinesPerMember)
+	}
+
+	if d.MaxMemoryCommitment > 0 && total > 0 {
+		commitment := committed * 100 / total
+		if commitment > int64(d.MaxMemoryCommitment) {
+			return fmt.Errorf("memory commitment would reach %d%%, the limit is %d%%", commitment, d.MaxMemoryCommitment)
+		}
+	}
+
+	return nil
+}
+
+// memoryLimit returns the memory limit of the instance in bytes, containers
+// without limit are not counted
+func memoryLimit(instance api.Instance, total int64) int64 {
+	limit := instance.ExpandedConfig["limits.memory"]
+	if limit == "" {
+		if instance.Type == string(api.InstanceTypeVM) {
+			return defaultVMMemory
+		}
+		return 0
+	}
+
+	if percent, found := strings.CutSuffix(limit, "%"); found {
+		value, err := strconv.ParseInt(percent, 10, 64)
+		if err != nil {
+			return 0
+		}
+		return total * value / 100
+	}
+
+	value, err := units.ParseByteSizeString(limit)
+	if err != nil {
+		return 0
+	}
+
+	return value
+}
@@ -0,0 +1,44 @@
+package incus
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/docker/machine/libmachine/log"
+	incus "github.com/lxc/incus/v6/client"
+	"github.com/lxc/incus/v6/shared/api"
+)
+
+// runPreStopHook runs the user supplied pre-stop script (ex: cordon and
+// drain the node) in the instance before it gets stopped or removed
+func (d *Driver) runPreStopHook(client incus.InstanceServer) error {
+	if d.PreStopScript == "" {
+		return nil
+	}
+
+	instance, _, err := client.GetInstanceState(d.MachineName)
+	if err != nil {
+		return err
+	}
+
+	if instance.StatusCode != api.Running && instance.StatusCode != api.Ready {
+		log.Debugf("Instance %s is not running, skipping pre-stop hook", d.MachineName)
+		return nil
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(d.PreStopTimeout)*time.Second)
+	defer cancel()
+
+	log.Infof("Running pre-stop hook on %s...", d.MachineName)
+	output, err := d.execContext(ctx, client, "sh", "-c", d.PreStopScript)
+	if ctx.Err() != nil {
+		return fmt.Errorf("pre-stop hook timed out after %d seconds", d.PreStopTimeout)
+	}
+	if err != nil {
+		return fmt.Errorf("pre-stop hook failed: %w", err)
+	}
+
+	log.Debugf("Pre-stop hook output: %s", output)
+	return nil
+}
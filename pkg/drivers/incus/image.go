@@ -0,0 +1,384 @@
+package incus
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/docker/machine/libmachine/log"
+	incus "github.com/lxc/incus/v6/client"
+	"github.com/lxc/incus/v6/shared/api"
+	"github.com/lxc/incus/v6/shared/osarch"
+)
+
+const imageCacheExpiry = time.Hour
+
+// release codenames used interchangeably with versions in image aliases
+var imageReleases = map[string]string{
+	"ubuntu/20.04": "ubuntu/focal",
+	"ubuntu/22.04": "ubuntu/jammy",
+	"ubuntu/24.04": "ubuntu/noble",
+	"debian/11":    "debian/bullseye",
+	"debian/12":    "debian/bookworm",
+	"debian/13":    "debian/trixie",
+}
+
+// imageArchSuffixes maps the architecture suffixes users copy from the
+// image server listing to the Incus architecture names
+var imageArchSuffixes = map[string]string{
+	"amd64":   "x86_64",
+	"arm64":   "aarch64",
+	"armhf":   "armv7l",
+	"i386":    "i686",
+	"ppc64el": "ppc64le",
+	"riscv64": "riscv64",
+	"s390x":   "s390x",
+}
+
+// imageAliasArchitecture returns the architecture of the trailing suffix of
+// an image alias, empty when it has none
+func imageAliasArchitecture(alias string) string {
+	parts := strings.Split(strings.TrimSuffix(alias, "/"), "/")
+	if len(parts) <= 2 {
+		return ""
+	}
+
+	return imageArchSuffixes[parts[len(parts)-1]]
+}
+
+// checkImageArchitecture takes the architecture of the instance from the
+// suffix of the image alias, which the alias resolution strips, so the
+// image of the native architecture is not silently used in its place
+func (d *Driver) checkImageArchitecture() error {
+	arch := imageAliasArchitecture(d.Image)
+	if arch == "" {
+		return nil
+	}
+
+	if d.Architecture == "" {
+		d.Architecture = arch
+		return nil
+	}
+	if d.Architecture != arch {
+		return fmt.Errorf("image %s is for %s, not the %s architecture", d.Image, arch, d.Architecture)
+	}
+
+	return nil
+}
+
+// imageServers caches the image server connections and resolved aliases
+// for the lifetime of the process, which spans many machines when the
+// driver runs under a long-lived Rancher process
+var imageServers = struct {
+	sync.Mutex
+	clients map[string]incus.ImageServer
+	aliases map[string]map[string]*api.ImageAliasesEntry
+}{
+	clients: map[string]incus.ImageServer{},
+	aliases: map[string]map[string]*api.ImageAliasesEntry{},
+}
+
+// getImageServer returns a cached connection to a simplestreams server,
+// whose index is also cached on disk when a cache directory is available
+func getImageServer(url string) (incus.ImageServer, error) {
+	imageServers.Lock()
+	defer imageServers.Unlock()
+
+	if client, ok := imageServers.clients[url]; ok {
+		return client, nil
+	}
+
+	args := &incus.ConnectionArgs{}
+	if cacheDir, err := os.UserCacheDir(); err == nil {
+		cachePath := filepath.Join(cacheDir, "docker-machine-driver-incus")
+		if err := os.MkdirAll(cachePath, 0700); err == nil {
+			args.CachePath = cachePath
+			args.CacheExpiry = imageCacheExpiry
+		}
+	}
+
+	client, err := incus.ConnectSimpleStreams(url, args)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to image server: %w", err)
+	}
+
+	imageServers.clients[url] = client
+	return client, nil
+}
+
+// getImageAliasArchitectures resolves an alias on an image server, reusing
+// earlier lookups of the same alias
+func getImageAliasArchitectures(url string, imageType string, alias string) (map[string]*api.ImageAliasesEntry, error) {
+	key := fmt.Sprintf("%s|%s|%s", url, imageType, alias)
+
+	imageServers.Lock()
+	archs, ok := imageServers.aliases[key]
+	imageServers.Unlock()
+	if ok {
+		log.Debugf("Using cached resolution of image %s", alias)
+		return archs, nil
+	}
+
+	client, err := getImageServer(url)
+	if err != nil {
+		return nil, err
+	}
+
+	archs, err = client.GetImageAliasArchitectures(imageType, alias)
+	if err != nil || len(archs) == 0 {
+		return nil, fmt.Errorf("image %s not found in image server", alias)
+	}
+
+	imageServers.Lock()
+	imageServers.aliases[key] = archs
+	imageServers.Unlock()
+
+	return archs, nil
+}
+
+func (d *Driver) getImage() (*api.InstanceSource, error) {
+	if d.Image == "" {
+		return nil, fmt.Errorf("image is required")
+	}
+
+	client, err := d.getClient()
+	if err != nil {
+		return nil, err
+	}
+
+	imageClient := client
+	if d.ImageProject != "" {
+		imageClient = client.UseProject(d.ImageProject)
+	}
+
+	// check if image name is from local image
+	if alias, _, err := imageClient.GetImageAlias(d.Image); err == nil {
+		image, _, err := imageClient.GetImage(alias.Target)
+		if err != nil {
+			return nil, fmt.Errorf("image %s not found: %w", d.Image, err)
+		}
+		d.imageArchitectures = []string{image.Architecture}
+
+		if d.Architecture != "" && image.Architecture != d.Architecture {
+			return nil, fmt.Errorf("image %s is %s, not %s", d.Image, image.Architecture, d.Architecture)
+		}
+
+		// copied into the instance project on create, where the alias is
+		// not defined
+		if d.ImageProject != "" {
+			return &api.InstanceSource{
+				Type:        "image",
+				Fingerprint: image.Fingerprint,
+			}, nil
+		}
+
+		return &api.InstanceSource{
+			Type:  "image",
+			Alias: d.Image,
+		}, nil
+	}
+
+	alias, archs, err := d.resolveImageAlias()
+	if err != nil {
+		return nil, err
+	}
+	d.imageArchitectures = []string{}
+	for arch := range archs {
+		d.imageArchitectures = append(d.imageArchitectures, arch)
+	}
+	slices.Sort(d.imageArchitectures)
+
+	if d.Architecture != "" {
+		entry, ok := archs[d.Architecture]
+		if !ok {
+			return nil, fmt.Errorf("image %s not available for %s in image server", alias, d.Architecture)
+		}
+
+		// pin the image of the requested architecture
+		return &api.InstanceSource{
+			Type:        "image",
+			Fingerprint: entry.Target,
+			Server:      imageServer,
+			Protocol:    "simplestreams",
+		}, nil
+	}
+
+	// image is from remote image server
+	return &api.InstanceSource{
+		Type:     "image",
+		Alias:    alias,
+		Server:   imageServer,
+		Protocol: "simplestreams",
+	}, nil
+}
+
+// resolveImageAlias looks the image up in the image server, trying in
+// order: the alias as given, its cloud variant, the same alias with the
+// release version and codename swapped, and the alias without a trailing
+// architecture, which checkImageArchitecture made the one of the instance
+func (d *Driver) resolveImageAlias() (string, map[string]*api.ImageAliasesEntry, error) {
+	errs := []error{}
+	for _, alias := range imageAliasCandidates(d.Image) {
+		archs, err := getImageAliasArchitectures(imageServer, string(d.instanceType()), alias)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+
+		if alias != d.Image {
+			log.Infof("Image %s resolved as %s", d.Image, alias)
+		}
+		return alias, archs, nil
+	}
+
+	return "", nil, fmt.Errorf("failed to resolve image %s, tried %d aliases:\n%w", d.Image, len(errs), errors.Join(errs...))
+}
+
+// imageAliasCandidates returns the alias forms to try for an image name
+func imageAliasCandidates(alias string) []string {
+	bases := []string{strings.TrimSuffix(alias, "/")}
+
+	if imageAliasArchitecture(bases[0]) != "" {
+		bases = append(bases, bases[0][:strings.LastIndex(bases[0], "/")])
+	}
+
+	candidates := []string{}
+	add := func(candidate string) {
+		if !slices.Contains(candidates, candidate) {
+			candidates = append(candidates, candidate)
+		}
+	}
+
+	for _, base := range bases {
+		forms := []string{base}
+		parts := strings.Split(base, "/")
+		if len(parts) >= 2 {
+			release := parts[0] + "/" + parts[1]
+			for version, codename := range imageReleases {
+				rest := strings.Join(parts[2:], "/")
+				swap := ""
+				if release == version {
+					swap = codename
+				} else if release == codename {
+					swap = version
+				}
+				if swap == "" {
+					continue
+				}
+				if rest != "" {
+					swap += "/" + rest
+				}
+				forms = append(forms, swap)
+			}
+		}
+
+		for _, form := range forms {
+			add(form)
+			if !strings.Contains(form+"/", "/cloud/") {
+				add(form + "/cloud")
+			}
+		}
+	}
+
+	return candidates
+}
+
+// ListImages prints the cloud-variant aliases of the image server matching
+// filter for the architecture of the Incus server, which is taken from the
+// INCUS_URL and INCUS_TLS_CLIENT_* environment when set and is the local
+// architecture otherwise
+func ListImages(w io.Writer, filter string) error {
+	d := NewDriver("", "").(*Driver)
+	d.URL = os.Getenv("INCUS_URL")
+	if err := d.tlsFromEnv(); err != nil {
+		return err
+	}
+
+	arch, err := osarch.ArchitectureGetLocal()
+	if err != nil {
+		return fmt.Errorf("failed to get architecture: %w", err)
+	}
+
+	if d.URL != "" {
+		server, err := d.connect()
+		if err != nil {
+			return err
+		}
+
+		info, _, err := server.GetServer()
+		if err != nil {
+			return fmt.Errorf("failed to get server info: %w", err)
+		}
+		arch = info.Environment.KernelArchitecture
+	}
+
+	client, err := getImageServer(imageServer)
+	if err != nil {
+		return err
+	}
+
+	images, err := client.GetImages()
+	if err != nil {
+		return fmt.Errorf("failed to list images of %s: %w", imageServer, err)
+	}
+
+	names := []string{}
+	for _, image := range images {
+		if image.Type != string(d.instanceType()) || image.Architecture != arch {
+			continue
+		}
+
+		for _, alias := range image.Aliases {
+			if !strings.Contains(alias.Name+"/", "/cloud/") || !strings.Contains(alias.Name, filter) {
+				continue
+			}
+			if !slices.Contains(names, alias.Name) {
+				names = append(names, alias.Name)
+			}
+		}
+	}
+	slices.Sort(names)
+
+	for _, name := range names {
+		fmt.Fprintln(w, name)
+	}
+
+	return nil
+}
+
+// copyProjectImage copies the local image resolved in the image project
+// into the instance project, as instances can only be created from the
+// images of their own project
+func (d *Driver) copyProjectImage(client incus.InstanceServer) error {
+	if d.ImageProject == "" || d.ImageSource.Server != "" || d.ImageSource.Fingerprint == "" {
+		return nil
+	}
+
+	if _, _, err := client.GetImage(d.ImageSource.Fingerprint); err == nil {
+		return nil
+	}
+
+	source := client.UseProject(d.ImageProject)
+	image, _, err := source.GetImage(d.ImageSource.Fingerprint)
+	if err != nil {
+		return fmt.Errorf("image %s not found in project %s: %w", d.Image, d.ImageProject, err)
+	}
+
+	log.Infof("Copying image %s from project %s to %s...", d.Image, d.ImageProject, d.Project)
+	op, err := client.CopyImage(source, *image, nil)
+	if err != nil {
+		return fmt.Errorf("failed to copy image %s from project %s: %w", d.Image, d.ImageProject, d.projectPermissionError(err, "can_create_images"))
+	}
+
+	if err := d.waitRemoteOperationTimeout(op, d.timeouts().ImageDownload); err != nil {
+		return fmt.Errorf("failed to copy image %s from project %s: %w", d.Image, d.ImageProject, err)
+	}
+
+	return nil
+}
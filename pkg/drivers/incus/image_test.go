@@ -0,0 +1,87 @@
+package incus
+
+import (
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestImageAliasCandidates(t *testing.T) {
+	tests := []struct {
+		alias string
+		want  []string
+	}{
+		{
+			alias: "ubuntu/22.04",
+			want:  []string{"ubuntu/22.04", "ubuntu/22.04/cloud", "ubuntu/jammy", "ubuntu/jammy/cloud"},
+		},
+		{
+			alias: "ubuntu/jammy/cloud/",
+			want:  []string{"ubuntu/jammy/cloud", "ubuntu/22.04/cloud"},
+		},
+		{
+			alias: "debian/12/cloud/arm64",
+			want: []string{
+				"debian/12/cloud/arm64", "debian/bookworm/cloud/arm64",
+				"debian/12/cloud", "debian/bookworm/cloud",
+			},
+		},
+		{
+			alias: "alpine/edge",
+			want:  []string{"alpine/edge", "alpine/edge/cloud"},
+		},
+		{
+			alias: "custom",
+			want:  []string{"custom", "custom/cloud"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.alias, func(t *testing.T) {
+			if got := imageAliasCandidates(tt.alias); !slices.Equal(got, tt.want) {
+				t.Errorf("candidates = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCheckImageArchitecture(t *testing.T) {
+	tests := []struct {
+		name    string
+		image   string
+		arch    string
+		want    string
+		wantErr string
+	}{
+		{name: "no suffix", image: "ubuntu/22.04", want: ""},
+		{name: "no suffix with architecture", image: "ubuntu/22.04", arch: "aarch64", want: "aarch64"},
+		{name: "suffix sets the architecture", image: "ubuntu/22.04/cloud/arm64", want: "aarch64"},
+		{name: "suffix matching the architecture", image: "debian/12/amd64", arch: "x86_64", want: "x86_64"},
+		{name: "release named like a suffix", image: "custom/arm64", want: ""},
+		{
+			name:    "suffix conflicting with the architecture",
+			image:   "ubuntu/22.04/cloud/arm64",
+			arch:    "x86_64",
+			wantErr: "image ubuntu/22.04/cloud/arm64 is for aarch64, not the x86_64 architecture",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d := &Driver{Image: tt.image, Architecture: tt.arch}
+			err := d.checkImageArchitecture()
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("error = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if d.Architecture != tt.want {
+				t.Errorf("architecture = %q, want %q", d.Architecture, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,117 @@
+package incus
+
+import (
+	"fmt"
+	"maps"
+	"time"
+
+	"github.com/docker/machine/libmachine/log"
+	incus "github.com/lxc/incus/v6/client"
+	"github.com/lxc/incus/v6/shared/api"
+)
+
+const imageKeyPrefix = "user.image."
+
+// layouts of the serials of the images built by distrobuilder and of the
+// cloud images of the distributions
+var imageSerialLayouts = []string{"20060102_15:04", "20060102_1504", "20060102.1", "20060102"}
+
+// ImageInfo is the image the instance was built from, for audits of the
+// machines built from images older than a given date
+type ImageInfo struct {
+	Serial       string
+	CreatedAt    time.Time
+	OS           string
+	Release      string
+	Variant      string
+	Architecture string
+}
+
+// imageInfo reads the image the instance was created from out of the image
+// keys Incus copies into the instance config, the creation date coming from
+// the cached image and otherwise from its serial
+func imageInfo(client incus.InstanceServer, instance *api.Instance) ImageInfo {
+	info := ImageInfo{
+		Serial:       instance.Config["image.serial"],
+		OS:           instance.Config["image.os"],
+		Release:      instance.Config["image.release"],
+		Variant:      instance.Config["image.variant"],
+		Architecture: instance.Config["image.architecture"],
+	}
+
+	if fingerprint := instance.Config["volatile.base_image"]; fingerprint != "" {
+		image, _, err := client.GetImage(fingerprint)
+		if err == nil {
+			info.CreatedAt = image.CreatedAt
+			return info
+		}
+		log.Debugf("Unable to get image %s of %s: %s", fingerprint, instance.Name, err)
+	}
+
+	for _, layout := range imageSerialLayouts {
+		if created, err := time.Parse(layout, info.Serial); err == nil {
+			info.CreatedAt = created
+			break
+		}
+	}
+
+	return info
+}
+
+// imageKeys returns the user keys recording the image in the instance
+// config, readable by the fleet tooling without the machine store
+func (d *Driver) imageKeys() map[string]string {
+	keys := map[string]string{}
+	for key, value := range map[string]string{
+		"fingerprint": d.ImageFingerprint,
+		"serial":      d.ImageInfo.Serial,
+		"os":          d.ImageInfo.OS,
+		"release":     d.ImageInfo.Release,
+	} {
+		if value != "" {
+			keys[imageKeyPrefix+key] = value
+		}
+	}
+	if !d.ImageInfo.CreatedAt.IsZero() {
+		keys[imageKeyPrefix+"created"] = d.ImageInfo.CreatedAt.UTC().Format(time.RFC3339)
+	}
+
+	return keys
+}
+
+// recordImage records the image of the instance in the machine config and
+// in its user.image.* keys
+func (d *Driver) recordImage(client incus.InstanceServer, instance *api.Instance, etag string) error {
+	// installed from an ISO, there is no image to record
+	if d.ImageFingerprint == "" {
+		return nil
+	}
+
+	d.ImageInfo = imageInfo(client, instance)
+	log.Infof("Instance %s built from image %.12s serial %s (%s %s)", d.MachineName,
+		d.ImageFingerprint, d.ImageInfo.Serial, d.ImageInfo.OS, d.ImageInfo.Release)
+
+	put := instance.Writable()
+	keys := d.imageKeys()
+	changed := false
+	for key, value := range keys {
+		if put.Config[key] != value {
+			changed = true
+		}
+	}
+	if !changed {
+		return nil
+	}
+
+	maps.Copy(put.Config, keys)
+	op, err := client.UpdateInstance(d.MachineName, put, etag)
+	if err != nil {
+		return fmt.Errorf("failed to record image of %s: %w", d.MachineName, d.instancePermissionError(err, "can_edit"))
+	}
+	if err := d.waitOperation(op); err != nil {
+		return fmt.Errorf("failed to record image of %s: %w", d.MachineName, err)
+	}
+	instance.Config = put.Config
+
+	return nil
+}
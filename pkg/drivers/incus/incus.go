@@ -1,60 +1,183 @@
 package incus
 
 import (
+	"context"
 	"fmt"
 	"net"
+	"net/http"
 	"os"
 	"slices"
+	"strings"
 	"time"
 
 	"github.com/docker/machine/libmachine/drivers"
+	"github.com/docker/machine/libmachine/drivers/plugin/localbinary"
 	"github.com/docker/machine/libmachine/log"
 	"github.com/docker/machine/libmachine/mcnflag"
 	"github.com/docker/machine/libmachine/ssh"
 	"github.com/docker/machine/libmachine/state"
 	incus "github.com/lxc/incus/v6/client"
 	"github.com/lxc/incus/v6/shared/api"
+	"golang.org/x/sync/errgroup"
 )
 
 type Driver struct {
 	*drivers.BaseDriver
-	URL               string
-	TLSClientCert     string
-	TLSClientKey      string
-	CPU               int
-	Memory            int
-	DiskSize          int
-	Project           string
-	Profile           string
-	Network           string
-	Storage           string
-	Image             string
-	CloudInitUserData string
-	SSHPort           int
-	incus             incus.InstanceServer
-	state             state.State
-	sshPublicKey      string
-	imgConfig         *api.InstanceSource
-	netConfig         map[string]string
-	diskConfig        map[string]string
-	rsrcConfig        map[string]string
-	isOVN             bool
+	URL                  string
+	TLSClientCert        string
+	TLSClientKey         string
+	TLSServerCert        string
+	TLSCA                string
+	TLSVerify            bool
+	APISSHTunnel         string
+	APISSHKey            string
+	APISSHSocket         string
+	InstanceType         string
+	CPU                  int
+	Memory               int
+	MemoryEnforce        string
+	Stateful             bool
+	DiskSize             int
+	Project              string
+	ImageProject         string
+	ProjectScoped        bool
+	Profile              string
+	Network              string
+	NetworkProject       string
+	Storage              string
+	Image                string
+	CloudInitUserData    string
+	SSHPort              int
+	NoStart              bool
+	SetupPending         bool // created without starting, the first Start finishes the setup
+	EnginePreinstall     bool
+	EngineInstallURL     string
+	PrepullImages        []string
+	InstanceUUID         string
+	Location             string
+	ImageFingerprint     string
+	ImageInfo            ImageInfo
+	MaxMachinesPerMember int
+	MaxMemoryCommitment  int
+	RequireGPU           bool
+	RequireStorageDriver string
+	Target               string
+	StopGracePeriod      int
+	RemoveStopTimeout    int
+	SnapshotRetention    int
+	ProfileOnly          bool
+	NICHwaddr            string
+	DockerProxyPort      int
+	DockerProxyAddress   string
+	NoNIC                bool
+	NICName              string
+	NICTxQueueLength     int
+	ManagementNetwork    string
+	NoRootDevice         bool
+	RootSizeOverride     bool
+	StorageVolumeOptions map[string]string
+	ZFSBlockMode         bool
+	BtrfsNoQuota         bool
+	LVMStripes           int
+	DataDiskSize         int
+	Volumes              []Volume
+	ImageArchitecture    string
+	Architecture         string
+	CloneSource          string
+	DebugConsole         bool
+	ConsoleLog           bool
+	LegacyCloudInitKeys  bool
+	WriteFiles           []WriteFile
+	TrustedCAs           []TrustedCA
+	OperationTimeout     int // seconds
+	ISO                  string
+	BootPriorities       map[string]int
+	ISOInstallTimeout    int // seconds, superseded by Timeouts.ISOInstall
+	IdmapIsolated        bool
+	IdmapSize            int
+	Firmware             string
+	CPUModel             string
+	CPUFlags             []string
+	CPUPriority          int
+	DiskPriority         int
+	PreStopScript        string
+	PreStopTimeout       int
+	SSHCiphers           []string
+	SSHMACs              []string
+	SSHKexAlgorithms     []string
+	SSHNoPasswordAuth    bool
+	SSHSkipHostKeyCheck  bool
+	OpenPorts            []string
+	LoadBalancerAddress  string
+	URLAddressPolicy     string
+	URLAddress           string
+	LoadBalancerPorts    []string
+	DNSServers           []string
+	DNSSearch            []string
+	RestoreEvacuated     bool
+	ReadySignal          bool
+	DevicePrefix         string
+	Devices              []string
+	Hostname             string
+	Timeouts             Timeouts
+	MetaData             map[string]string
+	MachineCluster       string
+
+	// resolved during PreCreateCheck and kept in the machine config so a
+	// fresh plugin process does not need to derive them again
+	ImageSource    *api.InstanceSource
+	NICConfig      map[string]string
+	RootDiskConfig map[string]string
+	ResourceConfig map[string]string
+	NetworkType    string
+	IsOVN          bool
+	NetworkMTU     int
+	IPv6Only       bool
+	NetworkDHCPv6  bool
+	RootDevice     string
+	NICDevice      string
+
+	ManagementNICConfig map[string]string
+	ManagementHwaddr    string
+	ManagementGateway   string
+	ManagementSubnet    string
+
+	// what the driver last applied to the instance, to detect drift
+	InstanceProfiles []string
+	InstanceConfig   map[string]string
+	InstanceDevices  map[string]map[string]string
+	StorageDriver    string
+
+	incus              incus.InstanceServer
+	tunnel             *apiTunnel
+	server             *api.Server
+	state              state.State
+	sshPublicKey       string
+	imageArchitectures []string
+	trustToken         string
+	// bounds the requests of the connections opened while set
+	ctx context.Context
 }
 
 const (
-	driverName           = "incus"
-	defaultCpus          = 1
-	defaultMemory        = 1024
-	defaultDiskSize      = 10240
-	defaultProject       = "default"
-	defaultProfile       = "default"
-	defaultNetwork       = "incusbr0"
-	defaultStorage       = "local"
-	defaultActiveTimeout = 200
-	defaultSSHUser       = "root"
-	defaultSSHPort       = 22
-	imageServer          = "https://images.linuxcontainers.org"
-	cloudInitVendorData  = `#cloud-config
+	driverName            = "incus"
+	defaultCpus           = 1
+	defaultMemory         = 1024
+	defaultDiskSize       = 10240
+	defaultProject        = "default"
+	defaultProfile        = "default"
+	defaultNetwork        = "incusbr0"
+	defaultStorage        = "local"
+	defaultActiveTimeout  = 200
+	defaultSSHUser        = "root"
+	defaultSSHPort        = 22
+	defaultStopGrace      = 0
+	defaultPriority       = -1
+	maxPriority           = 10
+	defaultPreStopTimeout = 300
+	defaultOpTimeout      = 10 * time.Minute
+	imageServer           = "https://images.linuxcontainers.org"
+	cloudInitVendorData   = `#cloud-config
 allow_public_ssh_keys: true
 ssh_authorized_keys:
   - %s
@@ -63,26 +186,6 @@ ssh:
   emit_keys_to_console: false
 disable_root: false
 package_update: true
-packages:
-  - openssh-server
-  - curl
-  - iptables
-  - open-iscsi
-`
-	cloudInitNetworkConfigOVN = `#cloud-config
-network:
-  version: 1
-  config:
-  - type: physical
-    name: enp5s0
-    mtu: 1442
-    subnets:
-    - type: dhcp
-  - type: physical
-    name: eth0
-    mtu: 1442
-    subnets:
-    - type: dhcp
 `
 )
 
@@ -96,25 +199,72 @@ func NewDriver(hostName, storePath string) drivers.Driver {
 }
 
 func (d *Driver) GetCreateFlags() []mcnflag.Flag {
-	return []mcnflag.Flag{
+	flags := []mcnflag.Flag{
 		mcnflag.StringFlag{
 			EnvVar: "INCUS_URL",
 			Name:   "incus-url",
-			Usage:  "Incus Server URL (ex: https://incus.example.com:8443)",
+			Usage:  "Incus Server URL, or unix:// for the socket of a local Incus (ex: https://incus.example.com:8443, unix:///var/lib/incus/unix.socket)",
 			Value:  "",
 		},
 		mcnflag.StringFlag{
 			EnvVar: "INCUS_TLS_CLIENT_CERT",
 			Name:   "incus-tls-client-cert",
-			Usage:  "TLS client certificate",
+			Usage:  "TLS client certificate, PEM or file path",
 			Value:  "",
 		},
 		mcnflag.StringFlag{
 			EnvVar: "INCUS_TLS_CLIENT_KEY",
 			Name:   "incus-tls-client-key",
-			Usage:  "TLS client key",
+			Usage:  "TLS client key, PEM or file path",
+			Value:  "",
+		},
+		mcnflag.StringFlag{
+			EnvVar: "INCUS_TLS_SERVER_CERT",
+			Name:   "incus-tls-server-cert",
+			Usage:  "Certificate the Incus server must present, PEM or file path",
+			Value:  "",
+		},
+		mcnflag.StringFlag{
+			EnvVar: "INCUS_TLS_CA",
+			Name:   "incus-tls-ca",
+			Usage:  "CA the certificate of the Incus server must be signed by instead of the system roots, PEM or file path",
+			Value:  "",
+		},
+		mcnflag.StringFlag{
+			EnvVar: "INCUS_TRUST_TOKEN",
+			Name:   "incus-trust-token",
+			Usage:  "Trust token adding the client certificate, generated when not set, to the trust store of the Incus server on create",
+			Value:  "",
+		},
+		mcnflag.BoolFlag{
+			EnvVar: "INCUS_INSECURE",
+			Name:   "incus-insecure",
+			Usage:  "Skip the TLS verification of the Incus server certificate",
+		},
+		mcnflag.StringFlag{
+			EnvVar: "INCUS_API_SSH_TUNNEL",
+			Name:   "incus-api-ssh-tunnel",
+			Usage:  "user@host[:port] to reach the Incus API through an SSH tunnel, to the URL as seen from that host or to its unix socket without URL",
+			Value:  "",
+		},
+		mcnflag.StringFlag{
+			EnvVar: "INCUS_API_SSH_KEY",
+			Name:   "incus-api-ssh-key",
+			Usage:  "Private key of the API SSH tunnel, defaults to the SSH agent",
 			Value:  "",
 		},
+		mcnflag.StringFlag{
+			EnvVar: "INCUS_API_SSH_SOCKET",
+			Name:   "incus-api-ssh-socket",
+			Usage:  "Incus unix socket on the API SSH tunnel host",
+			Value:  defaultUnixSocket,
+		},
+		mcnflag.StringFlag{
+			EnvVar: "INCUS_INSTANCE_TYPE",
+			Name:   "incus-instance-type",
+			Usage:  "Type of instance to create: vm, or container for hosts without KVM",
+			Value:  "vm",
+		},
 		mcnflag.IntFlag{
 			EnvVar: "INCUS_CPU_COUNT",
 			Name:   "incus-cpu-count",
@@ -127,6 +277,17 @@ func (d *Driver) GetCreateFlags() []mcnflag.Flag {
 			Usage:  "Incus size of memory for VM (in MiB)",
 			Value:  defaultMemory,
 		},
+		mcnflag.StringFlag{
+			EnvVar: "INCUS_MEMORY_ENFORCE",
+			Name:   "incus-memory-enforce",
+			Usage:  "Enforcement of the memory limit of container instances: hard, or soft to only apply it under host memory pressure",
+			Value:  "",
+		},
+		mcnflag.BoolFlag{
+			EnvVar: "INCUS_STATEFUL",
+			Name:   "incus-stateful",
+			Usage:  "Keep the memory of the virtual machine across stops, reserving a state volume of the memory size on the root disk",
+		},
 		mcnflag.IntFlag{
 			EnvVar: "INCUS_DISK_SIZE",
 			Name:   "incus-disk-size",
@@ -139,6 +300,17 @@ func (d *Driver) GetCreateFlags() []mcnflag.Flag {
 			Usage:  "Incus project name",
 			Value:  defaultProject,
 		},
+		mcnflag.BoolFlag{
+			EnvVar: "INCUS_PROJECT_SCOPED",
+			Name:   "incus-project-scoped",
+			Usage:  "Refuse client certificates not restricted to the Incus project of the machine",
+		},
+		mcnflag.StringFlag{
+			EnvVar: "INCUS_IMAGE_PROJECT",
+			Name:   "incus-image-project",
+			Usage:  "Incus project the local images are looked up in, copied to the instance project on create",
+			Value:  "",
+		},
 		mcnflag.StringFlag{
 			EnvVar: "INCUS_PROFILE",
 			Name:   "incus-profile",
@@ -148,14 +320,14 @@ func (d *Driver) GetCreateFlags() []mcnflag.Flag {
 		mcnflag.StringFlag{
 			EnvVar: "INCUS_NETWORK_NAME",
 			Name:   "incus-network-name",
-			Usage:  "Incus network name",
-			Value:  defaultNetwork,
+			Usage:  "Incus network name, optionally prefixed by the project owning it (ex: infra/uplink), defaults to the network of the profile NIC",
+			Value:  "",
 		},
 		mcnflag.StringFlag{
 			EnvVar: "INCUS_STORAGE_NAME",
 			Name:   "incus-storage-name",
-			Usage:  "Incus storage name",
-			Value:  defaultStorage,
+			Usage:  "Incus storage name, defaults to the pool of the profile root disk",
+			Value:  "",
 		},
 		mcnflag.StringFlag{
 			EnvVar: "INCUS_IMAGE_NAME",
@@ -163,10 +335,28 @@ func (d *Driver) GetCreateFlags() []mcnflag.Flag {
 			Usage:  "Incus image name (alias)",
 			Value:  "",
 		},
+		mcnflag.StringFlag{
+			EnvVar: "INCUS_CLONE_SOURCE",
+			Name:   "incus-clone-source",
+			Usage:  "Template instance or instance/snapshot to clone instead of using an image",
+			Value:  "",
+		},
+		mcnflag.StringFlag{
+			EnvVar: "INCUS_ISO",
+			Name:   "incus-iso",
+			Usage:  "ISO file or ISO volume of the storage pool to boot and install from instead of using an image",
+			Value:  "",
+		},
+		mcnflag.StringFlag{
+			EnvVar: "INCUS_BOOT_PRIORITY",
+			Name:   "incus-boot-priority",
+			Usage:  "Comma-separated boot priorities of the root, iso and data disks of virtual machines, the highest boots first and 0 leaves the priority unset, booting the disk after the others as it can not be taken out of the boot order (ex: root=5,iso=10,data=0)",
+			Value:  "",
+		},
 		mcnflag.StringFlag{
 			EnvVar: "INCUS_CLOUDINIT_USERDATA",
 			Name:   "incus-cloudinit-userdata",
-			Usage:  "Incus cloud-init.user-data",
+			Usage:  "Incus cloud-init.user-data file, a cloud-config, script, MIME multi-part or jinja template",
 			Value:  "",
 		},
 		mcnflag.IntFlag{
@@ -181,12 +371,357 @@ func (d *Driver) GetCreateFlags() []mcnflag.Flag {
 			Usage:  "Specifies the user as which docker-machine should log in to the Incus instance to install Docker.",
 			Value:  defaultSSHUser,
 		},
+		mcnflag.StringFlag{
+			EnvVar: "INCUS_SSH_CIPHERS",
+			Name:   "incus-ssh-ciphers",
+			Usage:  "Comma-separated list of ciphers sshd of the instance accepts",
+			Value:  "",
+		},
+		mcnflag.StringFlag{
+			EnvVar: "INCUS_SSH_MACS",
+			Name:   "incus-ssh-macs",
+			Usage:  "Comma-separated list of MACs sshd of the instance accepts",
+			Value:  "",
+		},
+		mcnflag.StringFlag{
+			EnvVar: "INCUS_SSH_KEX_ALGORITHMS",
+			Name:   "incus-ssh-kex-algorithms",
+			Usage:  "Comma-separated list of key exchange algorithms sshd of the instance accepts",
+			Value:  "",
+		},
+		mcnflag.BoolFlag{
+			EnvVar: "INCUS_SSH_NO_PASSWORD_AUTH",
+			Name:   "incus-ssh-no-password-auth",
+			Usage:  "Explicitly disable password and keyboard-interactive authentication of sshd",
+		},
+		mcnflag.BoolFlag{
+			EnvVar: "INCUS_SSH_SKIP_HOST_KEY_CHECK",
+			Name:   "incus-ssh-skip-host-key-check",
+			Usage:  "Create the machine without recording and verifying the SSH host keys of the guest, which are read through the Incus agent when it runs; only the SSH sessions of the driver check them, the provisioning SSH of docker-machine never does",
+		},
+		mcnflag.BoolFlag{
+			EnvVar: "INCUS_NO_START",
+			Name:   "incus-no-start",
+			Usage:  "Create the Incus instance without starting it, its first start finishes the setup; only for programs embedding the driver, docker-machine create rejects it",
+		},
+		mcnflag.StringFlag{
+			EnvVar: "INCUS_HOSTNAME",
+			Name:   "incus-hostname",
+			Usage:  "Hostname or FQDN of the guest, defaults to the machine name",
+			Value:  "",
+		},
+		mcnflag.StringFlag{
+			EnvVar: "INCUS_META_DATA",
+			Name:   "incus-meta-data",
+			Usage:  "Comma-separated key=value pairs added to the cloud-init meta-data of the instance (ex: rack=r1), next to the machine and cluster names",
+			Value:  "",
+		},
+		mcnflag.StringFlag{
+			EnvVar: "INCUS_MACHINE_CLUSTER",
+			Name:   "incus-machine-cluster",
+			Usage:  "Cluster the machine belongs to, recorded as user.docker-machine.cluster to group instances in --list-machines and in the cloud-init meta-data",
+			Value:  "",
+		},
+		mcnflag.StringSliceFlag{
+			EnvVar: "INCUS_WRITE_FILE",
+			Name:   "incus-write-file",
+			Usage:  "File written into the guest by cloud-init as path=content-file[,mode,owner], repeatable (ex: /etc/app.conf=app.conf,0640,root:app)",
+			Value:  []string{},
+		},
+		mcnflag.StringSliceFlag{
+			EnvVar: "INCUS_TRUSTED_CA",
+			Name:   "incus-trusted-ca",
+			Usage:  "PEM CA bundle added to the guest trust store as [registry=]ca-file, also trusted by Docker for the given registry, repeatable (ex: registry.lan:5000=ca.pem)",
+			Value:  []string{},
+		},
+		mcnflag.BoolFlag{
+			EnvVar: "INCUS_ENGINE_PREINSTALL",
+			Name:   "incus-engine-preinstall",
+			Usage:  "Install Docker from cloud-init before provisioning, using the --engine-install-url script",
+		},
+		mcnflag.StringFlag{
+			EnvVar: "INCUS_PREPULL_IMAGES",
+			Name:   "incus-prepull-images",
+			Usage:  "Comma-separated list of Docker images to pull once Docker is installed",
+			Value:  "",
+		},
+		mcnflag.StringFlag{
+			EnvVar: "INCUS_OPEN_PORTS",
+			Name:   "incus-open-ports",
+			Usage:  "Comma-separated list of port[-end][/udp] to allow through a guest firewall besides SSH and Docker, no firewall if empty",
+			Value:  "",
+		},
+		mcnflag.StringFlag{
+			EnvVar: "INCUS_LB_ADDRESS",
+			Name:   "incus-lb-address",
+			Usage:  "Listen address of an OVN network load balancer to add the instance to as a backend, created if missing",
+			Value:  "",
+		},
+		mcnflag.StringFlag{
+			EnvVar: "INCUS_LB_PORTS",
+			Name:   "incus-lb-ports",
+			Usage:  "Comma-separated list of port[-end][/udp] the load balancer forwards to the instance",
+			Value:  defaultLoadBalancerPorts,
+		},
+		mcnflag.StringFlag{
+			EnvVar: "INCUS_URL_ADDRESS",
+			Name:   "incus-url-address",
+			Usage:  "Address the Docker URL advertises when the instance has several: ipv4, ipv6, management or a subnet (ex: 203.0.113.0/24), empty uses the SSH address; also returned as the machine IP so the Docker server certificate names it",
+			Value:  "",
+		},
+		mcnflag.StringFlag{
+			EnvVar: "INCUS_TARGET",
+			Name:   "incus-target",
+			Usage:  "Cluster member to create the instance on, or @group to let the scheduler pick a member of a cluster group",
+			Value:  "",
+		},
+		mcnflag.BoolFlag{
+			EnvVar: "INCUS_REQUIRE_GPU",
+			Name:   "incus-require-gpu",
+			Usage:  "Only place the instance on a cluster member with a GPU",
+		},
+		mcnflag.IntFlag{
+			EnvVar: "INCUS_MAX_MACHINES_PER_MEMBER",
+			Name:   "incus-max-machines-per-member",
+			Usage:  "Refuse to create the machine on a server or cluster member already hosting this many machines (0 disables the check)",
+			Value:  0,
+		},
+		mcnflag.IntFlag{
+			EnvVar: "INCUS_MAX_MEMORY_COMMITMENT",
+			Name:   "incus-max-memory-commitment",
+			Usage:  "Refuse to create the machine when the memory limits of the instances would exceed this percentage of the server or cluster member memory (0 disables the check)",
+			Value:  0,
+		},
+		mcnflag.StringFlag{
+			EnvVar: "INCUS_REQUIRE_STORAGE_DRIVER",
+			Name:   "incus-require-storage-driver",
+			Usage:  "Only place the instance on a cluster member supporting this storage driver (ex: zfs)",
+			Value:  "",
+		},
+		mcnflag.StringFlag{
+			EnvVar: "INCUS_ARCHITECTURE",
+			Name:   "incus-architecture",
+			Usage:  "Instance architecture, used to pick the cluster member and image, defaults to the architecture suffix of the image alias (ex: x86_64, aarch64)",
+			Value:  "",
+		},
+		mcnflag.BoolFlag{
+			EnvVar: "INCUS_READY_SIGNAL",
+			Name:   "incus-ready-signal",
+			Usage:  "Have the guest signal readiness to Incus once cloud-init is done and only report the machine running after it did",
+		},
+		mcnflag.BoolFlag{
+			EnvVar: "INCUS_RESTORE_EVACUATED",
+			Name:   "incus-restore-evacuated",
+			Usage:  "Restore the evacuated cluster member hosting the instance on start",
+		},
+		mcnflag.IntFlag{
+			EnvVar: "INCUS_STOP_GRACE_PERIOD",
+			Name:   "incus-stop-grace-period",
+			Usage:  "Seconds to wait for a graceful stop before forcing it on kill/remove (0 forces immediately)",
+			Value:  defaultStopGrace,
+		},
+		mcnflag.IntFlag{
+			EnvVar: "INCUS_SNAPSHOT_RETENTION",
+			Name:   "incus-snapshot-retention",
+			Usage:  "Number of snapshots taken before an OS upgrade to keep, the upgrade is rolled back to it when it fails (0 disables them)",
+			Value:  defaultSnapshotRetention,
+		},
+		mcnflag.IntFlag{
+			EnvVar: "INCUS_REMOVE_STOP_TIMEOUT",
+			Name:   "incus-remove-stop-timeout",
+			Usage:  "Seconds to wait for a graceful stop before forcing it on remove (0 uses the stop grace period, -1 forces immediately)",
+			Value:  0,
+		},
+		mcnflag.BoolFlag{
+			EnvVar: "INCUS_PROFILE_ONLY",
+			Name:   "incus-profile-only",
+			Usage:  "Create the instance without local devices, resource limits or user-data, relying on the profile (the SSH key is still injected)",
+		},
+		mcnflag.IntFlag{
+			EnvVar: "INCUS_DOCKER_PROXY_PORT",
+			Name:   "incus-docker-proxy-port",
+			Usage:  "Incus host port proxied to the Docker API of the instance, which pins the instance address on its managed network (0 disables the proxy)",
+			Value:  0,
+		},
+		mcnflag.StringFlag{
+			EnvVar: "INCUS_DOCKER_PROXY_ADDRESS",
+			Name:   "incus-docker-proxy-address",
+			Usage:  "Incus host address the Docker API proxy listens on (defaults to the Incus URL host), returned as the machine IP so the Docker server certificate names it",
+			Value:  "",
+		},
+		mcnflag.StringFlag{
+			EnvVar: "INCUS_DNS_SERVERS",
+			Name:   "incus-dns-servers",
+			Usage:  "Comma-separated list of DNS servers the instance uses instead of the ones from DHCP",
+			Value:  "",
+		},
+		mcnflag.StringFlag{
+			EnvVar: "INCUS_DNS_SEARCH",
+			Name:   "incus-dns-search",
+			Usage:  "Comma-separated list of DNS search domains of the instance",
+			Value:  "",
+		},
+		mcnflag.BoolFlag{
+			EnvVar: "INCUS_NO_NIC",
+			Name:   "incus-no-nic",
+			Usage:  "Do not attach a NIC device, relying on the profile networking",
+		},
+		mcnflag.StringFlag{
+			EnvVar: "INCUS_NIC_NAME",
+			Name:   "incus-nic-name",
+			Usage:  "Interface name of the NIC inside the instance",
+			Value:  defaultNICName,
+		},
+		mcnflag.StringFlag{
+			EnvVar: "INCUS_MANAGEMENT_NETWORK",
+			Name:   "incus-management-network",
+			Usage:  "Network of a second NIC only carrying management traffic, replies to its address are policy routed through it",
+			Value:  "",
+		},
+		mcnflag.IntFlag{
+			EnvVar: "INCUS_NIC_TX_QUEUE_LENGTH",
+			Name:   "incus-nic-tx-queue-length",
+			Usage:  "Transmit queue length of the NIC on bridge networks, 0 for the default; virtual machines get one virtio queue per vCPU",
+			Value:  0,
+		},
+		mcnflag.StringFlag{
+			EnvVar: "INCUS_DEVICE_PREFIX",
+			Name:   "incus-device-prefix",
+			Usage:  "Prefix of the names of the devices the driver adds to the instance",
+			Value:  defaultDevicePrefix,
+		},
+		mcnflag.BoolFlag{
+			EnvVar: "INCUS_NO_ROOT_DEVICE",
+			Name:   "incus-no-root-device",
+			Usage:  "Do not attach a root disk device, relying on the profile root disk",
+		},
+		mcnflag.BoolFlag{
+			EnvVar: "INCUS_ROOT_SIZE_OVERRIDE",
+			Name:   "incus-root-size-override",
+			Usage:  "With --incus-no-root-device, override the profile root disk size with --incus-disk-size",
+		},
+		mcnflag.StringFlag{
+			EnvVar: "INCUS_STORAGE_VOLUME_OPTIONS",
+			Name:   "incus-storage-volume-options",
+			Usage:  "Comma-separated root volume options for ceph, cephfs and lvmcluster storage (ex: block.filesystem=xfs,ceph.rbd.features=layering)",
+			Value:  "",
+		},
+		mcnflag.BoolFlag{
+			EnvVar: "INCUS_ZFS_BLOCK_MODE",
+			Name:   "incus-zfs-block-mode",
+			Usage:  "Create the container root volume on zfs storage as a zvol formatted with a filesystem, which suits the overlay storage driver of Docker",
+		},
+		mcnflag.BoolFlag{
+			EnvVar: "INCUS_BTRFS_NO_QUOTA",
+			Name:   "incus-btrfs-no-quota",
+			Usage:  "Leave the container root volume on btrfs storage without size limit, avoiding the qgroup overhead on Docker layer churn",
+		},
+		mcnflag.IntFlag{
+			EnvVar: "INCUS_LVM_STRIPES",
+			Name:   "incus-lvm-stripes",
+			Usage:  "Number of stripes of the root volume on lvm and lvmcluster storage (0 keeps the pool default)",
+			Value:  0,
+		},
+		mcnflag.IntFlag{
+			EnvVar: "INCUS_DATA_DISK_SIZE",
+			Name:   "incus-data-disk-size",
+			Usage:  "Size of an additional data disk volume attached to the instance, mounted on /var/lib/docker in containers (in MiB, 0 disables it)",
+			Value:  0,
+		},
+		mcnflag.IntFlag{
+			EnvVar: "INCUS_CPU_PRIORITY",
+			Name:   "incus-cpu-priority",
+			Usage:  "Incus CPU scheduling priority for VM, from 0 to 10 (-1 keeps the default)",
+			Value:  defaultPriority,
+		},
+		mcnflag.IntFlag{
+			EnvVar: "INCUS_DISK_PRIORITY",
+			Name:   "incus-disk-priority",
+			Usage:  "Incus disk I/O priority for VM, from 0 to 10 (-1 keeps the default)",
+			Value:  defaultPriority,
+		},
+		mcnflag.BoolFlag{
+			EnvVar: "INCUS_IDMAP_ISOLATED",
+			Name:   "incus-idmap-isolated",
+			Usage:  "Use an isolated idmap for container instances",
+		},
+		mcnflag.IntFlag{
+			EnvVar: "INCUS_IDMAP_SIZE",
+			Name:   "incus-idmap-size",
+			Usage:  "Size of the isolated idmap for container instances (0 uses the server default)",
+			Value:  0,
+		},
+		mcnflag.StringFlag{
+			EnvVar: "INCUS_CPU_MODEL",
+			Name:   "incus-cpu-model",
+			Usage:  "QEMU CPU model of virtual machine instances, host to pass the server CPU through (ex: host, EPYC-v2)",
+			Value:  "",
+		},
+		mcnflag.StringFlag{
+			EnvVar: "INCUS_CPU_FLAGS",
+			Name:   "incus-cpu-flags",
+			Usage:  "Comma-separated CPU flags added to the CPU model, or removed when prefixed with - (ex: avx2,-vmx)",
+			Value:  "",
+		},
+		mcnflag.StringFlag{
+			EnvVar: "INCUS_FIRMWARE",
+			Name:   "incus-firmware",
+			Usage:  "Firmware of virtual machine instances: secureboot, uefi (no secure boot) or csm (legacy BIOS boot), empty uses the profile or server default",
+			Value:  "",
+		},
+		mcnflag.StringFlag{
+			EnvVar: "INCUS_PRE_STOP_SCRIPT",
+			Name:   "incus-pre-stop-script",
+			Usage:  "Path to a script run in the instance before stop/remove (ex: to drain the node)",
+			Value:  "",
+		},
+		mcnflag.IntFlag{
+			EnvVar: "INCUS_PRE_STOP_TIMEOUT",
+			Name:   "incus-pre-stop-timeout",
+			Usage:  "Seconds to wait for the pre-stop script before failing",
+			Value:  defaultPreStopTimeout,
+		},
+		mcnflag.BoolFlag{
+			EnvVar: "INCUS_DEBUG_CONSOLE",
+			Name:   "incus-debug-console",
+			Usage:  "Dump the instance console output when create fails",
+		},
+		mcnflag.BoolFlag{
+			EnvVar: "INCUS_CONSOLE_LOG",
+			Name:   "incus-console-log",
+			Usage:  "Save the instance console output to console.log in the machine store after create and on start or upgrade failures",
+		},
+		mcnflag.StringFlag{
+			EnvVar: "INCUS_OPERATION_TIMEOUT",
+			Name:   "incus-operation-timeout",
+			Usage:  "Time to wait for an Incus operation before cancelling it, 0 waits forever (ex: 90s, 10m)",
+			Value:  defaultOpTimeout.String(),
+		},
 	}
+
+	return applySiteDefaults(append(flags, timeoutFlags()...))
 }
 
 func (d *Driver) Create() error {
+	err := d.create()
+	if err != nil && d.DebugConsole {
+		d.dumpConsoleLog()
+	}
+	d.saveConsoleLog()
+
+	return err
+}
+
+func (d *Driver) create() error {
 	log.Infof("Creating Incus instance...")
 
+	// resolved configuration is missing when create runs in a new process
+	if d.ImageSource == nil {
+		if err := d.PreCreateCheck(); err != nil {
+			return err
+		}
+	}
+
 	pubKey, err := d.getSSHKey()
 	if err != nil {
 		return err
@@ -198,79 +733,44 @@ func (d *Driver) Create() error {
 		return err
 	}
 
-	cloudInitVendorData := fmt.Sprintf(cloudInitVendorData, d.sshPublicKey)
-	config := d.rsrcConfig
-	config["cloud-init.vendor-data"] = cloudInitVendorData
-	if d.CloudInitUserData != "" {
-		if cloudConfig, err := os.ReadFile(d.CloudInitUserData); err == nil {
-			config["cloud-init.user-data"] = string(cloudConfig)
-		}
+	// a previous create interrupted while the server was still creating
+	// the instance is picked up instead of failing on the existing name
+	resumed, err := d.resumeOperations(client)
+	if err != nil {
+		return err
 	}
 
-	if d.isOVN {
-		// this handle mtu for ovn network needs to be 1442 in guest VM
-		config["cloud-init.network-config"] = cloudInitNetworkConfigOVN
+	if _, _, err := client.GetInstance(d.MachineName); resumed && err == nil {
+		log.Infof("Resuming create of existing instance %s", d.MachineName)
+	} else if err := d.createInstance(client); err != nil {
+		return err
 	}
 
-	devices := map[string]map[string]string{
-		"root": d.diskConfig,
-		"eth0": d.netConfig,
+	if err := d.refreshInstanceInfo(client); err != nil {
+		return err
 	}
 
-	instance := api.InstancePut{
-		Profiles:    []string{d.Profile},
-		Description: "Created by Rancher Machine",
-		Config:      config,
-		Devices:     devices,
+	// the image templates are only known once the instance exists, so the
+	// instance is started after the cloud-init keys are settled
+	if err := d.applyCloudInitKeys(client); err != nil {
+		return err
 	}
 
-	req := api.InstancesPost{
-		Name:        d.MachineName,
-		Type:        api.InstanceTypeVM,
-		Start:       true,
-		Source:      *d.imgConfig,
-		InstancePut: instance,
+	if d.NoStart {
+		log.Infof("Instance %s created without starting it", d.MachineName)
+		d.SetupPending = true
+		return nil
 	}
 
-	op, err := client.CreateInstance(req)
-	if err != nil {
+	if err := d.startInstance(client); err != nil {
 		return err
 	}
 
-	err = op.Wait()
-	if err != nil {
+	if err := d.waitForInstall(client); err != nil {
 		return err
 	}
 
-	const maxRetries = 100
-	retry := 0
-	for {
-		state, _, err := client.GetInstanceState(d.MachineName)
-		if err != nil {
-			return err
-		}
-
-		if slices.Contains([]api.StatusCode{api.Aborting, api.Freezing, api.Frozen, api.Thawed, api.Error, api.Failure, api.Cancelled}, state.StatusCode) {
-			return fmt.Errorf("instance state is %s", state.StatusCode)
-		}
-
-		for _, net := range state.Network {
-			// only take the first IPv4 address
-			for _, addr := range net.Addresses {
-				if addr.Family == "inet" && addr.Scope != "local" {
-					d.IPAddress = addr.Address
-					log.Infof("Instance IP address: %s", d.IPAddress)
-					return nil
-				}
-			}
-		}
-
-		time.Sleep(5 * time.Second)
-		retry += 1
-		if retry > maxRetries {
-			return fmt.Errorf("timeout waiting for instance to get IP address")
-		}
-	}
+	return d.waitForReady(client)
 }
 
 // DriverName returns the name of the driver
@@ -278,8 +778,24 @@ func (d *Driver) DriverName() string {
 	return driverName
 }
 
+// GetSSHHostname returns the address of the instance itself, which GetIP
+// does not when the Docker API is reached through the proxy
 func (d *Driver) GetSSHHostname() (string, error) {
-	return d.GetIP()
+	return d.BaseDriver.GetIP()
+}
+
+// GetIP returns the address the Docker URL advertises, the one libmachine
+// puts in the server certificate of the Docker daemon: the docker proxy
+// address, or the one chosen by the URL address policy when it resolved one
+func (d *Driver) GetIP() (string, error) {
+	if d.DockerProxyPort != 0 && d.DockerProxyAddress != "" {
+		return d.DockerProxyAddress, nil
+	}
+	if d.URLAddress != "" {
+		return d.URLAddress, nil
+	}
+
+	return d.BaseDriver.GetIP()
 }
 
 func (d *Driver) GetSSHPort() (int, error) {
@@ -303,12 +819,22 @@ func (d *Driver) GetURL() (string, error) {
 		return "", err
 	}
 
+	return d.dockerURL()
+}
+
+// dockerURL returns the Docker API URL on the address of GetIP
+func (d *Driver) dockerURL() (string, error) {
 	ip, err := d.GetIP()
 	if err != nil {
 		return "", err
 	}
 
-	return fmt.Sprintf("tcp://%s", net.JoinHostPort(ip, "2376")), nil
+	port := dockerPort
+	if d.DockerProxyPort != 0 {
+		port = d.DockerProxyPort
+	}
+
+	return fmt.Sprintf("tcp://%s", net.JoinHostPort(ip, fmt.Sprintf("%d", port))), nil
 }
 
 func (d *Driver) GetState() (state.State, error) {
@@ -317,14 +843,41 @@ func (d *Driver) GetState() (state.State, error) {
 		return state.Error, err
 	}
 
+	// the instance is still being set up by an operation of a previous
+	// plugin process
+	if ops, err := d.pendingOperations(client); err == nil {
+		for _, op := range ops {
+			if !op.StatusCode.IsFinal() {
+				return state.Starting, nil
+			}
+		}
+	}
+
 	instance, _, err := client.GetInstanceState(d.MachineName)
 	if err != nil {
+		// an unreachable member does not mean the machine is broken, it
+		// must not be reported as an error which gets it deleted
+		if status, serr := d.memberStatus(client); serr == nil && status == "Offline" {
+			log.Warnf("Cluster member %s hosting %s is offline: %s", d.Location, d.MachineName, err)
+			return state.Timeout, nil
+		}
 		return state.Error, err
 	}
+
+	if err := d.refreshLocation(client); err != nil {
+		log.Debugf("Failed to refresh location of %s: %s", d.MachineName, err)
+	}
+
 	switch instance.StatusCode.String() {
 	case "Starting":
 		return state.Starting, nil
 	case "Running":
+		// the guest has not signalled it finished booting yet
+		if d.ReadySignal {
+			return state.Starting, nil
+		}
+		return state.Running, nil
+	case "Ready":
 		return state.Running, nil
 	case "Stopping":
 		return state.Starting, nil
@@ -338,11 +891,44 @@ func (d *Driver) GetState() (state.State, error) {
 }
 
 func (d *Driver) Kill() error {
+	return d.forceStop(d.StopGracePeriod)
+}
+
+// forceStop stops the instance, forcing it once the grace period in seconds
+// expired
+func (d *Driver) forceStop(gracePeriod int) error {
 	client, err := d.getClient()
 	if err != nil {
 		return err
 	}
 
+	instance, _, err := client.GetInstanceState(d.MachineName)
+	if err != nil {
+		return err
+	}
+
+	if instance.StatusCode == api.Stopped {
+		log.Debugf("Instance %s is already stopped", d.MachineName)
+		return nil
+	}
+
+	if gracePeriod > 0 {
+		state := api.InstanceStatePut{
+			Action:  "stop",
+			Timeout: gracePeriod,
+		}
+
+		op, err := client.UpdateInstanceState(d.MachineName, state, "")
+		if err == nil {
+			err = d.waitOperation(op)
+		}
+		if err == nil {
+			return nil
+		}
+
+		log.Warnf("Instance %s did not stop within %d seconds, forcing stop: %s", d.MachineName, gracePeriod, err)
+	}
+
 	state := api.InstanceStatePut{
 		Action: "stop",
 		Force:  true,
@@ -350,10 +936,10 @@ func (d *Driver) Kill() error {
 
 	op, err := client.UpdateInstanceState(d.MachineName, state, "")
 	if err != nil {
-		return err
+		return d.instancePermissionError(err, "can_update_state")
 	}
 
-	err = op.Wait()
+	err = d.waitOperation(op)
 	if err != nil {
 		return err
 	}
@@ -363,57 +949,180 @@ func (d *Driver) Kill() error {
 func (d *Driver) PreCreateCheck() error {
 	log.Infof("Running pre-create checks...")
 
-	client, err := d.getClient()
-	if err != nil {
+	timeout := d.timeouts().Preflight
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	// the checks run on a copy of the driver, which is dropped when they
+	// time out as the requests of a shared client or of the image server do
+	// not get the deadline and may still be writing its fields
+	check := *d
+	check.ctx = ctx
+	done := make(chan error, 1)
+	go func() {
+		done <- check.preCreateCheck(ctx)
+	}()
+
+	select {
+	case err := <-done:
+		// a client set by the caller is shared and stays as it is, the one
+		// the checks connected is bound to their deadline and dropped so
+		// Create opens its own
+		check.ctx = nil
+		if d.incus == nil {
+			check.incus = nil
+		}
+		*d = check
 		return err
+	case <-ctx.Done():
+		return fmt.Errorf("pre-create checks timed out after %s", timeout)
 	}
+}
 
-	if _, _, err := client.GetProfile(d.Profile); err != nil {
-		return fmt.Errorf("profile %s not found: %w", d.Profile, err)
-	}
-
-	d.imgConfig, err = d.getImage()
-	if err != nil {
+func (d *Driver) preCreateCheck(ctx context.Context) error {
+	if err := d.checkConnection(); err != nil {
 		return err
 	}
 
-	d.netConfig, err = d.getNetwork()
+	client, err := d.getClient()
 	if err != nil {
 		return err
 	}
 
-	d.diskConfig, err = d.getStorage()
-	if err != nil {
-		return err
-	}
+	d.discoverDefaults(client)
 
-	d.rsrcConfig, err = d.getResource()
-	if err != nil {
+	if err := d.checkTarget(); err != nil {
 		return err
 	}
 
-	return nil
-}
+	// all checks share the connected client and write distinct fields
+	var g errgroup.Group
 
-func (d *Driver) Remove() error {
-	d.Kill()
+	g.Go(func() error {
+		if _, _, err := client.GetProfile(d.Profile); err != nil {
+			return fmt.Errorf("profile %s not found: %w", d.Profile, permissionError(err, "can_view", "profile", d.Profile))
+		}
+		return nil
+	})
+
+	g.Go(func() (err error) {
+		// the installer writes the root disk, there is no image to resolve
+		if d.ISO != "" {
+			d.ImageSource = &api.InstanceSource{Type: "none"}
+			return nil
+		}
 
-	client, err := d.getClient()
-	if err != nil {
+		if d.CloneSource != "" {
+			d.ImageSource, err = d.getCloneSource()
+			return err
+		}
+
+		d.ImageSource, err = d.getImage()
 		return err
+	})
+
+	if !d.ProfileOnly && !d.NoNIC {
+		g.Go(func() (err error) {
+			d.NICConfig, err = d.getNetwork()
+			return err
+		})
 	}
 
-	op, err := client.DeleteInstance(d.MachineName)
+	if !d.ProfileOnly && d.ManagementNetwork != "" {
+		g.Go(func() (err error) {
+			d.ManagementNICConfig, err = d.getManagementNetwork()
+			return err
+		})
+	}
+
+	if !d.ProfileOnly {
+		g.Go(func() (err error) {
+			d.RootDiskConfig, err = d.getStorage()
+			return err
+		})
+
+		g.Go(func() (err error) {
+			d.ResourceConfig, err = d.getResource()
+			return err
+		})
+	}
+
+	if err := g.Wait(); err != nil {
+		return err
+	}
+
+	checks := []func() error{
+		d.selectTarget,
+		d.checkStorageTarget,
+		d.checkArchitecture,
+		d.checkFirmware,
+		d.checkCPU,
+		func() error { return d.checkCloudInit(client) },
+		d.checkLoadBalancer,
+	}
+	for _, check := range checks {
+		// stop between the checks once the deadline passed
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if err := check(); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// removeStopTimeout returns the grace period of the stop before removing
+// the instance, machines created by older drivers use the stop grace period
+func (d *Driver) removeStopTimeout() int {
+	if d.RemoveStopTimeout == 0 {
+		return d.StopGracePeriod
+	}
+
+	// forceStop forces negative grace periods right away
+	return d.RemoveStopTimeout
+}
+
+func (d *Driver) Remove() error {
+	client, err := d.getClient()
 	if err != nil {
 		return err
 	}
 
-	err = op.Wait()
+	if _, _, err := client.GetInstance(d.MachineName); api.StatusErrorCheck(err, http.StatusNotFound) {
+		log.Infof("Instance %s not found, assuming it is already removed", d.MachineName)
+		return d.removeVolumes(client)
+	}
+
+	if err := d.runPreStopHook(client); err != nil {
+		return err
+	}
+
+	// a broken load balancer must not keep the instance from being deleted
+	if err := d.detachLoadBalancer(); err != nil {
+		log.Warnf("Failed to detach %s from load balancer: %s", d.MachineName, err)
+	}
+
+	d.releaseLease(client)
+
+	// Incus has no forced delete of running instances, stop them first
+	if err := d.forceStop(d.removeStopTimeout()); err != nil {
+		return fmt.Errorf("failed to stop instance %s: %w", d.MachineName, err)
+	}
+
+	op, err := client.DeleteInstance(d.MachineName)
+	if err != nil {
+		return d.instancePermissionError(err, "can_delete")
+	}
+
+	err = d.waitOperation(op)
 	if err != nil {
 		return err
 	}
+	d.checkStaleLeases()
 
-	return nil
+	return d.removeVolumes(client)
 }
 
 func (d *Driver) Restart() error {
@@ -428,10 +1137,10 @@ func (d *Driver) Restart() error {
 
 	op, err := client.UpdateInstanceState(d.MachineName, state, "")
 	if err != nil {
-		return err
+		return d.instancePermissionError(err, "can_update_state")
 	}
 
-	err = op.Wait()
+	err = d.waitOperation(op)
 	if err != nil {
 		return err
 	}
@@ -439,20 +1148,231 @@ func (d *Driver) Restart() error {
 }
 
 func (d *Driver) SetConfigFromFlags(flags drivers.DriverOptions) error {
-	d.URL = flags.String("incus-url")
-	d.TLSClientCert = flags.String("incus-tls-client-cert")
-	d.TLSClientKey = flags.String("incus-tls-client-key")
+	flags = withSiteDefaults(flags)
+	var err error
+	if d.URL, err = parseIncusURL(flags.String("incus-url")); err != nil {
+		return err
+	}
+	if err := d.parseClientCertificate(flags.String("incus-tls-client-cert"), flags.String("incus-tls-client-key")); err != nil {
+		return err
+	}
+	if err := d.parseTLSVerify(flags.String("incus-tls-server-cert"), flags.String("incus-tls-ca"), flags.Bool("incus-insecure")); err != nil {
+		return err
+	}
+	d.APISSHKey = flags.String("incus-api-ssh-key")
+	d.APISSHSocket = flags.String("incus-api-ssh-socket")
 	d.CPU = flags.Int("incus-cpu-count")
 	d.Memory = flags.Int("incus-memory-size")
+	d.MemoryEnforce = flags.String("incus-memory-enforce")
+	if d.MemoryEnforce != "" && d.MemoryEnforce != "hard" && d.MemoryEnforce != "soft" {
+		return fmt.Errorf("invalid memory enforcement %q, expected hard or soft", d.MemoryEnforce)
+	}
+	d.Stateful = flags.Bool("incus-stateful")
 	d.DiskSize = flags.Int("incus-disk-size")
 	d.Project = flags.String("incus-project")
+	d.ProjectScoped = flags.Bool("incus-project-scoped")
+	d.ImageProject = flags.String("incus-image-project")
 	d.Profile = flags.String("incus-profile")
-	d.Network = flags.String("incus-network-name")
 	d.Storage = flags.String("incus-storage-name")
 	d.Image = flags.String("incus-image-name")
+	d.CloneSource = flags.String("incus-clone-source")
+	d.ISO = flags.String("incus-iso")
 	d.SSHPort = flags.Int("incus-ssh-port")
 	d.SSHUser = flags.String("incus-ssh-user")
 	d.CloudInitUserData = flags.String("incus-cloudinit-userdata")
+	d.NoStart = flags.Bool("incus-no-start")
+	// docker-machine waits for the machine to run right after create
+	if d.NoStart && os.Getenv(localbinary.PluginEnvKey) == localbinary.PluginEnvVal {
+		return fmt.Errorf("--incus-no-start is only supported by programs embedding the driver like pkg/bulk, docker-machine create waits for the machine to run and provisions it")
+	}
+	d.Target = flags.String("incus-target")
+	if d.Target == clusterGroupPrefix {
+		return fmt.Errorf("invalid target %q, expected a cluster member or @group", d.Target)
+	}
+	d.RequireGPU = flags.Bool("incus-require-gpu")
+	d.MaxMachinesPerMember = flags.Int("incus-max-machines-per-member")
+	d.MaxMemoryCommitment = flags.Int("incus-max-memory-commitment")
+	if d.MaxMachinesPerMember < 0 || d.MaxMemoryCommitment < 0 {
+		return fmt.Errorf("machine and memory commitment limits can not be negative")
+	}
+	d.RequireStorageDriver = flags.String("incus-require-storage-driver")
+	d.Architecture = flags.String("incus-architecture")
+	if err := d.checkImageArchitecture(); err != nil {
+		return err
+	}
+	d.StopGracePeriod = flags.Int("incus-stop-grace-period")
+	d.RemoveStopTimeout = flags.Int("incus-remove-stop-timeout")
+	d.SnapshotRetention = flags.Int("incus-snapshot-retention")
+	d.RestoreEvacuated = flags.Bool("incus-restore-evacuated")
+	d.MachineCluster = flags.String("incus-machine-cluster")
+	d.ReadySignal = flags.Bool("incus-ready-signal")
+	d.ProfileOnly = flags.Bool("incus-profile-only")
+	d.DockerProxyPort = flags.Int("incus-docker-proxy-port")
+	d.DockerProxyAddress = flags.String("incus-docker-proxy-address")
+	d.NoNIC = flags.Bool("incus-no-nic")
+	d.NICTxQueueLength = flags.Int("incus-nic-tx-queue-length")
+	d.ManagementNetwork = flags.String("incus-management-network")
+	if d.NICTxQueueLength < 0 {
+		return fmt.Errorf("invalid NIC transmit queue length %d", d.NICTxQueueLength)
+	}
+	d.NoRootDevice = flags.Bool("incus-no-root-device")
+	d.DevicePrefix = flags.String("incus-device-prefix")
+	d.RootSizeOverride = flags.Bool("incus-root-size-override")
+
+	volumeOptions, err := parseKeyValues(flags.String("incus-storage-volume-options"))
+	if err != nil {
+		return fmt.Errorf("invalid storage volume options: %w", err)
+	}
+	d.StorageVolumeOptions = volumeOptions
+	d.ZFSBlockMode = flags.Bool("incus-zfs-block-mode")
+	d.BtrfsNoQuota = flags.Bool("incus-btrfs-no-quota")
+	d.LVMStripes = flags.Int("incus-lvm-stripes")
+	if d.LVMStripes < 0 {
+		return fmt.Errorf("invalid lvm stripes %d", d.LVMStripes)
+	}
+	metaData, err := parseKeyValues(flags.String("incus-meta-data"))
+	if err != nil {
+		return fmt.Errorf("invalid meta-data: %w", err)
+	}
+	d.MetaData = metaData
+	d.DataDiskSize = flags.Int("incus-data-disk-size")
+	d.CPUPriority = flags.Int("incus-cpu-priority")
+	d.DiskPriority = flags.Int("incus-disk-priority")
+	d.IdmapIsolated = flags.Bool("incus-idmap-isolated")
+	d.IdmapSize = flags.Int("incus-idmap-size")
+	if d.InstanceType, err = parseInstanceType(flags.String("incus-instance-type")); err != nil {
+		return err
+	}
+	if err := d.checkInstanceType(); err != nil {
+		return err
+	}
+
+	if d.BootPriorities, err = parseBootPriorities(flags.String("incus-boot-priority")); err != nil {
+		return err
+	}
+
+	if d.Firmware, err = parseFirmware(flags.String("incus-firmware")); err != nil {
+		return err
+	}
+
+	if d.CPUModel, err = parseCPUModel(flags.String("incus-cpu-model")); err != nil {
+		return err
+	}
+
+	if d.CPUFlags, err = parseCPUFlags(flags.String("incus-cpu-flags")); err != nil {
+		return err
+	}
+	d.PreStopTimeout = flags.Int("incus-pre-stop-timeout")
+	d.DebugConsole = flags.Bool("incus-debug-console")
+	d.ConsoleLog = flags.Bool("incus-console-log")
+	if d.OperationTimeout, err = parseOperationTimeout(flags.String("incus-operation-timeout")); err != nil {
+		return err
+	}
+
+	// kept in the machine config so the hook still runs from another host
+	if path := flags.String("incus-pre-stop-script"); path != "" {
+		script, err := os.ReadFile(path)
+		if err != nil {
+			return fmt.Errorf("failed to read pre-stop script: %w", err)
+		}
+		d.PreStopScript = string(script)
+	}
+
+	if d.WriteFiles, err = parseWriteFiles(flags.StringSlice("incus-write-file")); err != nil {
+		return err
+	}
+
+	if d.CloudInitUserData != "" {
+		_, format, err := readUserData(d.CloudInitUserData)
+		if err != nil {
+			return err
+		}
+		log.Debugf("Using %s user-data %s", format, d.CloudInitUserData)
+	}
+
+	if d.TrustedCAs, err = parseTrustedCAs(flags.StringSlice("incus-trusted-ca")); err != nil {
+		return err
+	}
+
+	d.SSHNoPasswordAuth = flags.Bool("incus-ssh-no-password-auth")
+	d.SSHSkipHostKeyCheck = flags.Bool("incus-ssh-skip-host-key-check")
+	if d.SSHCiphers, err = parseSSHAlgorithms("cipher", flags.String("incus-ssh-ciphers"), clientCiphers); err != nil {
+		return err
+	}
+	if d.SSHMACs, err = parseSSHAlgorithms("MAC", flags.String("incus-ssh-macs"), clientMACs); err != nil {
+		return err
+	}
+	if d.SSHKexAlgorithms, err = parseSSHAlgorithms("key exchange algorithm", flags.String("incus-ssh-kex-algorithms"), clientKexAlgorithms); err != nil {
+		return err
+	}
+
+	if d.Timeouts, err = parseTimeouts(flags); err != nil {
+		return err
+	}
+
+	if d.Hostname, err = parseHostname(flags.String("incus-hostname")); err != nil {
+		return err
+	}
+
+	// honor the engine options of docker-machine like the provisioner does,
+	// which default to the same script
+	d.EnginePreinstall = flags.Bool("incus-engine-preinstall")
+	engineInstallURL := flags.String("engine-install-url")
+	if engineInstallURL == "" {
+		engineInstallURL = defaultEngineInstallURL
+	}
+	if d.EnginePreinstall {
+		if d.EngineInstallURL, err = parseEngineInstallURL(engineInstallURL); err != nil {
+			return err
+		}
+	}
+
+	prepullImages, err := parseImageList(flags.String("incus-prepull-images"))
+	if err != nil {
+		return err
+	}
+	d.PrepullImages = prepullImages
+
+	openPorts, err := parseOpenPorts(flags.String("incus-open-ports"))
+	if err != nil {
+		return err
+	}
+	d.OpenPorts = openPorts
+
+	d.LoadBalancerAddress = flags.String("incus-lb-address")
+	if d.LoadBalancerPorts, err = parseOpenPorts(flags.String("incus-lb-ports")); err != nil {
+		return err
+	}
+
+	if d.URLAddressPolicy, err = parseURLAddressPolicy(flags.String("incus-url-address")); err != nil {
+		return err
+	}
+	if d.URLAddressPolicy == "management" && d.ManagementNetwork == "" {
+		return fmt.Errorf("--incus-url-address management requires --incus-management-network")
+	}
+
+	if d.APISSHTunnel, err = parseSSHTunnel(flags.String("incus-api-ssh-tunnel")); err != nil {
+		return err
+	}
+	if d.APISSHTunnel != "" && d.unixSocket() != "" {
+		return fmt.Errorf("--incus-url can not be a unix socket with --incus-api-ssh-tunnel, set --incus-api-ssh-socket instead")
+	}
+	// the token only names the server address when no tunnel reaches it
+	if err := d.parseTrustToken(flags.String("incus-trust-token")); err != nil {
+		return err
+	}
+	if d.NetworkProject, d.Network, err = parseNetworkName(flags.String("incus-network-name")); err != nil {
+		return err
+	}
+	if d.DNSServers, err = parseDNSServers(flags.String("incus-dns-servers")); err != nil {
+		return err
+	}
+	if d.DNSSearch, err = parseDNSSearch(flags.String("incus-dns-search")); err != nil {
+		return err
+	}
+	if d.NICName, err = parseNICName(flags.String("incus-nic-name")); err != nil {
+		return err
+	}
 
 	d.SetSwarmConfigFromFlags(flags)
 
@@ -460,21 +1380,79 @@ func (d *Driver) SetConfigFromFlags(flags drivers.DriverOptions) error {
 }
 
 func (d *Driver) Start() error {
+	err := d.start()
+	if err != nil {
+		d.saveConsoleLog()
+	}
+
+	return err
+}
+
+func (d *Driver) start() error {
 	client, err := d.getClient()
 	if err != nil {
 		return err
 	}
 
+	status, err := d.memberStatus(client)
+	if err != nil {
+		return err
+	}
+	switch status {
+	case "Offline":
+		return fmt.Errorf("cluster member %s hosting %s is offline", d.Location, d.MachineName)
+	case "Evacuated":
+		if !d.RestoreEvacuated {
+			return fmt.Errorf("cluster member %s hosting %s is evacuated, restore it or set --incus-restore-evacuated", d.Location, d.MachineName)
+		}
+		if err := d.restoreMember(client); err != nil {
+			return err
+		}
+	}
+
+	if err := d.startInstance(client); err != nil {
+		return err
+	}
+
+	// the steps create skipped for an instance it did not start
+	if d.SetupPending {
+		if err := d.waitForInstall(client); err != nil {
+			return err
+		}
+		if err := d.waitForReady(client); err != nil {
+			return err
+		}
+		d.SetupPending = false
+		return nil
+	}
+
+	if err := d.waitForIP(client); err != nil {
+		return err
+	}
+
+	return d.updateDockerProxy(client)
+}
+
+func (d *Driver) startInstance(client incus.InstanceServer) error {
 	state := api.InstanceStatePut{
 		Action: "start",
 	}
 
+	// resume from the state saved by a stateful stop
+	if d.Stateful {
+		instance, _, err := client.GetInstance(d.MachineName)
+		if err != nil {
+			return err
+		}
+		state.Stateful = instance.Stateful
+	}
+
 	op, err := client.UpdateInstanceState(d.MachineName, state, "")
 	if err != nil {
-		return err
+		return d.instancePermissionError(err, "can_update_state")
 	}
 
-	err = op.Wait()
+	err = d.waitOperation(op)
 	if err != nil {
 		return err
 	}
@@ -487,17 +1465,22 @@ func (d *Driver) Stop() error {
 		return err
 	}
 
+	if err := d.runPreStopHook(client); err != nil {
+		return err
+	}
+
 	state := api.InstanceStatePut{
-		Action: "stop",
-		Force:  false,
+		Action:   "stop",
+		Force:    false,
+		Stateful: d.Stateful,
 	}
 
 	op, err := client.UpdateInstanceState(d.MachineName, state, "")
 	if err != nil {
-		return err
+		return d.instancePermissionError(err, "can_update_state")
 	}
 
-	err = op.Wait()
+	err = d.waitOperation(op)
 	if err != nil {
 		return err
 	}
@@ -505,7 +1488,7 @@ func (d *Driver) Stop() error {
 }
 
 func (d *Driver) Upgrade() error {
-	return fmt.Errorf("upgrade is not supported for incus driver at this moment")
+	return d.UpgradeOS()
 }
 
 func (d *Driver) getClient() (incus.InstanceServer, error) {
@@ -513,15 +1496,9 @@ func (d *Driver) getClient() (incus.InstanceServer, error) {
 		return d.incus, nil
 	}
 
-	args := &incus.ConnectionArgs{
-		TLSClientCert:      d.TLSClientCert,
-		TLSClientKey:       d.TLSClientKey,
-		InsecureSkipVerify: true,
-	}
-
-	is, err := incus.ConnectIncus(d.URL, args)
+	is, err := d.connect()
 	if err != nil {
-		return nil, fmt.Errorf("failed to connect to incus: " + err.Error())
+		return nil, err
 	}
 
 	if _, _, err := is.GetProject(d.Project); err != nil {
@@ -532,6 +1509,67 @@ func (d *Driver) getClient() (incus.InstanceServer, error) {
 	return d.incus, nil
 }
 
+// SetClient makes the driver use an already connected client, scoped to
+// the project of the machine, instead of opening its own connection; a
+// client already scoped to it is used as is so its event listener, which
+// operations are waited on with, is shared with the other drivers
+func (d *Driver) SetClient(client incus.InstanceServer) {
+	if info, err := client.GetConnectionInfo(); err == nil && info.Project == d.Project {
+		d.incus = client
+		return
+	}
+
+	d.incus = client.UseProject(d.Project)
+}
+
+// context returns the context the requests of new connections are bound to
+func (d *Driver) context() context.Context {
+	if d.ctx != nil {
+		return d.ctx
+	}
+
+	return context.Background()
+}
+
+// connect opens a connection to the server without selecting the project
+func (d *Driver) connect() (incus.InstanceServer, error) {
+	args := &incus.ConnectionArgs{
+		TLSClientCert: d.TLSClientCert,
+		TLSClientKey:  d.TLSClientKey,
+		TLSServerCert: d.TLSServerCert,
+		TLSCA:         d.TLSCA,
+		// machines created by older drivers never verified the server
+		InsecureSkipVerify: !d.TLSVerify,
+	}
+
+	// the client keeps the context of the connection for all its requests,
+	// so the timeout only cancels it while the connection is pending
+	timeout := d.timeouts().Connect
+	ctx, cancel := context.WithCancel(d.context())
+	timer := time.AfterFunc(timeout, cancel)
+
+	var server incus.InstanceServer
+	var err error
+	switch {
+	case d.APISSHTunnel != "":
+		server, err = d.connectTunnel(ctx, args)
+	case d.unixSocket() != "":
+		// the socket permissions authenticate the client, not the certificate
+		server, err = incus.ConnectIncusUnixWithContext(ctx, d.unixSocket(), args)
+	default:
+		server, err = incus.ConnectIncusWithContext(ctx, d.URL, args)
+	}
+
+	if !timer.Stop() {
+		return nil, fmt.Errorf("failed to connect to incus: timed out after %s", timeout)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to incus: " + err.Error())
+	}
+
+	return server, nil
+}
+
 func (d *Driver) publicSSHKeyPath() string {
 	return d.GetSSHKeyPath() + ".pub"
 }
@@ -549,106 +1587,227 @@ func (d *Driver) getSSHKey() (string, error) {
 	return string(pubKey), nil
 }
 
-func (d *Driver) getImage() (*api.InstanceSource, error) {
-	if d.Image == "" {
-		return nil, fmt.Errorf("image is required")
+// getResource resolves the config keys validated before the instance is
+// created
+func (d *Driver) getResource() (map[string]string, error) {
+	return buildConfig(d.resourceConfig, d.securityConfig, d.bootConfig, d.cpuConfig, d.statefulConfig, d.containerConfig)
+}
+
+// refreshInstanceInfo records the instance identity so external tooling can
+// correlate the machine with the Incus inventory
+func (d *Driver) refreshInstanceInfo(client incus.InstanceServer) error {
+	instance, etag, err := client.GetInstance(d.MachineName)
+	if err != nil {
+		return err
 	}
 
-	client, err := d.getClient()
+	d.InstanceUUID = instance.Config["volatile.uuid"]
+	d.ImageFingerprint = instance.Config["volatile.base_image"]
+	d.Location = instance.Location
+	if err := d.recordImage(client, instance, etag); err != nil {
+		return err
+	}
+	d.recordInstance(instance.Writable())
+	return nil
+}
+
+// createInstance creates the stopped instance from the resolved config
+func (d *Driver) createInstance(client incus.InstanceServer) (err error) {
+	vendorData, err := d.getVendorData()
 	if err != nil {
-		return nil, err
+		return err
 	}
 
-	// check if image name is from local image
-	if _, _, err := client.GetImageAlias(d.Image); err == nil {
-		return &api.InstanceSource{
-			Type:  "image",
-			Alias: d.Image,
-		}, nil
+	sections := []configSection{}
+	if !d.ProfileOnly {
+		sections = append(sections, copyConfig(d.ResourceConfig))
 	}
+	sections = append(sections, d.cloudInitConfig(vendorData), d.userConfig)
 
-	imgSrv, err := incus.ConnectSimpleStreams(imageServer, nil)
+	config, err := buildConfig(sections...)
 	if err != nil {
-		return nil, fmt.Errorf("failed to connect to image server: %w", err)
+		return err
+	}
+	logConfig(d.MachineName, config)
+
+	devices := map[string]map[string]string{}
+	if !d.ProfileOnly {
+		if d.RootDiskConfig != nil {
+			devices[d.RootDevice] = d.RootDiskConfig
+		}
+		if !d.NoNIC {
+			devices[d.NICDevice] = d.NICConfig
+		}
+		if d.ManagementNICConfig != nil {
+			devices[d.deviceName("mgmt")] = d.ManagementNICConfig
+		}
+
+		if d.DataDiskSize > 0 {
+			devices[d.deviceName("data")], err = d.getDataDisk(client)
+			if err != nil {
+				return err
+			}
+
+			// nothing uses the volume when the create fails, delete it
+			// right away instead of leaving it until the machine is removed
+			defer func() {
+				if err == nil {
+					return
+				}
+				if removeErr := d.removeVolumes(client); removeErr != nil {
+					log.Warnf("Failed to delete the data volume of %s: %s", d.MachineName, removeErr)
+				}
+			}()
+		}
 	}
 
-	if _, _, err := imgSrv.GetImageAlias(d.Image); err != nil {
-		return nil, fmt.Errorf("image %s not found in image server", d.Image)
+	if d.ISO != "" {
+		devices[d.deviceName("iso")], err = d.getISODevice(client)
+		if err != nil {
+			return err
+		}
 	}
 
-	// image is from remote image server
-	return &api.InstanceSource{
-		Type:     "image",
-		Alias:    d.Image,
-		Server:   imageServer,
-		Protocol: "simplestreams",
-	}, nil
-}
+	for name := range devices {
+		d.recordDevice(name)
+	}
 
-func (d *Driver) getNetwork() (map[string]string, error) {
-	if d.Network == "" {
-		return nil, fmt.Errorf("network is required")
+	instance := api.InstancePut{
+		Profiles:    []string{d.Profile},
+		Description: "Created by Rancher Machine",
+		Config:      config,
+		Devices:     devices,
 	}
 
-	client, err := d.getClient()
-	if err != nil {
-		return nil, err
+	req := api.InstancesPost{
+		Name:        d.MachineName,
+		Type:        d.instanceType(),
+		Start:       false,
+		Source:      *d.ImageSource,
+		InstancePut: instance,
 	}
 
-	network, _, err := client.GetNetwork(d.Network)
-	if err != nil {
-		return nil, fmt.Errorf("network %s not found: %w", d.Network, err)
+	if err := d.copyProjectImage(client); err != nil {
+		return err
 	}
 
-	if !slices.Contains([]string{"bridge", "ovn"}, network.Type) {
-		return nil, fmt.Errorf("network type %s not supported", network.Type)
+	createClient := client
+	if d.Target != "" {
+		createClient = client.UseTarget(d.Target)
 	}
 
-	// bridge
-	if network.Type == "bridge" {
-		return map[string]string{
-			"name":    d.Network,
-			"type":    "nic",
-			"nictype": "bridged",
-			"parent":  d.Network,
-		}, nil
+	op, err := createClient.CreateInstance(req)
+	if err != nil {
+		return d.projectPermissionError(err, "can_create_instances")
 	}
 
-	// ovn network
-	d.isOVN = true
-	return map[string]string{
-		"name":    "eth0",
-		"type":    "nic",
-		"network": d.Network,
-	}, nil
+	// creating the instance includes downloading the image, which takes
+	// longer than other operations
+	return d.waitOperationTimeout(op, d.timeouts().ImageDownload)
 }
 
-func (d *Driver) getStorage() (map[string]string, error) {
-	if d.Storage == "" {
-		return nil, fmt.Errorf("storage is required")
+// waitForReady waits for a newly started instance and finishes preparing
+// it for provisioning
+func (d *Driver) waitForReady(client incus.InstanceServer) error {
+	if err := d.waitForIP(client); err != nil {
+		return err
 	}
 
-	client, err := d.getClient()
-	if err != nil {
-		return nil, err
+	if err := d.finalizeClone(client); err != nil {
+		return err
+	}
+
+	if err := d.waitForCloudInit(client); err != nil {
+		return err
+	}
+
+	if err := d.waitForReadySignal(client); err != nil {
+		return err
 	}
 
-	_, _, err = client.GetStoragePool(d.Storage)
+	if err := d.waitForSSH(); err != nil {
+		return err
+	}
+
+	if err := d.recordHostKeys(client); err != nil {
+		return err
+	}
+
+	if err := d.attachLoadBalancer(); err != nil {
+		return err
+	}
+
+	return d.updateDockerProxy(client)
+}
+
+func (d *Driver) waitForIP(client incus.InstanceServer) error {
+	err := retry(d.timeouts().Boot, func() (bool, error) {
+		state, _, err := client.GetInstanceState(d.MachineName)
+		if err != nil {
+			return true, err
+		}
+
+		if slices.Contains([]api.StatusCode{api.Aborting, api.Freezing, api.Frozen, api.Thawed, api.Error, api.Failure, api.Cancelled}, state.StatusCode) {
+			return true, fmt.Errorf("instance state is %s", state.StatusCode)
+		}
+
+		address := d.instanceAddress(state)
+		if address == "" {
+			return false, nil
+		}
+
+		d.IPAddress = address
+		log.Infof("Instance IP address: %s", d.IPAddress)
+		d.resolveURLAddress(state)
+		return true, nil
+	})
 	if err != nil {
-		return nil, fmt.Errorf("storage %s not found: %w", d.Storage, err)
+		return fmt.Errorf("waiting for instance to get IP address: %w", err)
 	}
 
-	return map[string]string{
-		"type": "disk",
-		"path": "/",
-		"pool": d.Storage,
-		"size": fmt.Sprintf("%dMiB", d.DiskSize),
-	}, nil
+	return nil
 }
 
-func (d *Driver) getResource() (map[string]string, error) {
-	return map[string]string{
-		"limits.cpu":    fmt.Sprintf("%d", d.CPU),
-		"limits.memory": fmt.Sprintf("%dMiB", d.Memory),
-	}, nil
+// instanceAddress returns the first IPv4 address of the driver NIC, or the
+// first global IPv6 one on IPv6-only networks, or an empty string while the
+// instance has none
+func (d *Driver) instanceAddress(state *api.InstanceState) string {
+	family := "inet"
+	if d.IPv6Only {
+		family = "inet6"
+	}
+
+	for _, net := range state.Network {
+		// only trust the NIC the driver created when it is known
+		if d.NICHwaddr != "" && !strings.EqualFold(net.Hwaddr, d.NICHwaddr) {
+			continue
+		}
+
+		for _, addr := range net.Addresses {
+			if addr.Family == family && addr.Scope == "global" {
+				return addr.Address
+			}
+		}
+	}
+
+	return ""
+}
+
+// parseKeyValues parses a comma-separated list of key=value pairs
+func parseKeyValues(value string) (map[string]string, error) {
+	result := map[string]string{}
+	for _, entry := range strings.Split(value, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+
+		key, val, found := strings.Cut(entry, "=")
+		if !found || key == "" {
+			return nil, fmt.Errorf("expected key=value, got %q", entry)
+		}
+		result[strings.TrimSpace(key)] = strings.TrimSpace(val)
+	}
+
+	return result, nil
 }
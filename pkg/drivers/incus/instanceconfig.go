@@ -0,0 +1,159 @@
+package incus
+
+import (
+	"fmt"
+	"maps"
+	"slices"
+	"strings"
+
+	"github.com/docker/machine/libmachine/log"
+	"github.com/lxc/incus/v6/shared/api"
+)
+
+// configSection adds the keys of one concern to the instance config
+type configSection func(config map[string]string) error
+
+// buildConfig assembles the instance config from its sections, in order
+func buildConfig(sections ...configSection) (map[string]string, error) {
+	config := map[string]string{}
+	for _, section := range sections {
+		if err := section(config); err != nil {
+			return nil, err
+		}
+	}
+
+	return config, nil
+}
+
+// resourceConfig sets the limits and priorities of the instance
+func (d *Driver) resourceConfig(config map[string]string) error {
+	config["limits.cpu"] = fmt.Sprintf("%d", d.CPU)
+
+	// the memory of a virtual machine is its RAM size, it is only a cgroup
+	// limit for containers, which can be relaxed to a soft one
+	config["limits.memory"] = fmt.Sprintf("%dMiB", d.Memory)
+	if d.MemoryEnforce != "" {
+		if d.instanceType() != api.InstanceTypeContainer {
+			return fmt.Errorf("memory enforcement is only supported for container instances")
+		}
+		config["limits.memory.enforce"] = d.MemoryEnforce
+	}
+
+	priorities := map[string]int{
+		"limits.cpu.priority":  d.CPUPriority,
+		"limits.disk.priority": d.DiskPriority,
+	}
+	for key, priority := range priorities {
+		// negative keeps the profile or server default
+		if priority < 0 {
+			continue
+		}
+		if priority > maxPriority {
+			return fmt.Errorf("%s must be between 0 and %d", key, maxPriority)
+		}
+		config[key] = fmt.Sprintf("%d", priority)
+	}
+
+	return nil
+}
+
+// securityConfig sets the isolation options of the instance
+func (d *Driver) securityConfig(config map[string]string) error {
+	// isolated idmaps keep uid ranges of containers on one host apart
+	if d.IdmapIsolated || d.IdmapSize > 0 {
+		if d.instanceType() != api.InstanceTypeContainer {
+			return fmt.Errorf("idmap options are only supported for container instances")
+		}
+
+		config["security.idmap.isolated"] = "true"
+		if d.IdmapSize > 0 {
+			config["security.idmap.size"] = fmt.Sprintf("%d", d.IdmapSize)
+		}
+	}
+
+	return nil
+}
+
+// bootConfig sets the firmware the instance boots with
+func (d *Driver) bootConfig(config map[string]string) error {
+	if d.Firmware != "" {
+		if d.instanceType() != api.InstanceTypeVM {
+			return fmt.Errorf("firmware options are only supported for virtual machine instances")
+		}
+
+		maps.Copy(config, firmwares[d.Firmware])
+	}
+
+	return nil
+}
+
+// cloudInitConfig returns the section passing the cloud-init data to the
+// guest; the user-data and network-config are left to the profiles in
+// profile-only mode
+func (d *Driver) cloudInitConfig(vendorData string) configSection {
+	return func(config map[string]string) error {
+		if !d.ProfileOnly {
+			if d.CloudInitUserData != "" {
+				userData, _, err := readUserData(d.CloudInitUserData)
+				if err != nil {
+					return err
+				}
+				config["cloud-init.user-data"] = userData
+			}
+
+			// ovn and fan networks need the guest mtu to match the overlay mtu and
+			// custom resolvers have to replace the dhcp ones
+			if d.hasNetworkConfig() {
+				networkConfig, err := d.getNetworkConfig()
+				if err != nil {
+					return err
+				}
+				config["cloud-init.network-config"] = networkConfig
+			}
+		}
+
+		// the machine SSH key can only be injected per instance, even when the
+		// instance shape is left to the profiles
+		config["cloud-init.vendor-data"] = vendorData
+
+		metaData, err := d.getMetaData()
+		if err != nil {
+			return err
+		}
+		config["user.meta-data"] = metaData
+
+		return nil
+	}
+}
+
+// userConfig sets the user keys tagging the instance as a machine
+func (d *Driver) userConfig(config map[string]string) error {
+	maps.Copy(config, d.machineKeys())
+	return nil
+}
+
+// copyConfig returns the section adding a config resolved beforehand
+func copyConfig(values map[string]string) configSection {
+	return func(config map[string]string) error {
+		maps.Copy(config, values)
+		return nil
+	}
+}
+
+// logConfig writes the final instance config to the debug log, the
+// cloud-init documents only by size
+func logConfig(name string, config map[string]string) {
+	keys := []string{}
+	for key := range config {
+		keys = append(keys, key)
+	}
+	slices.Sort(keys)
+
+	for _, key := range keys {
+		value := config[key]
+		if strings.Contains(value, "\n") {
+			value = fmt.Sprintf("<%d bytes>", len(value))
+		}
+		log.Debugf("Instance %s config: %s=%s", name, key, value)
+	}
+}
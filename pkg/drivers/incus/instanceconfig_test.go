@@ -0,0 +1,434 @@
+package incus
+
+import (
+	"errors"
+	"maps"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/docker/machine/libmachine/drivers"
+)
+
+func set(key, value string) configSection {
+	return func(config map[string]string) error {
+		config[key] = value
+		return nil
+	}
+}
+
+func fail(err error) configSection {
+	return func(config map[string]string) error {
+		return err
+	}
+}
+
+// checkConfig compares the result of a config build with the expected config
+// or the expected error message
+func checkConfig(t *testing.T, config map[string]string, err error, want map[string]string, wantErr string) {
+	t.Helper()
+
+	if wantErr != "" {
+		if err == nil || !strings.Contains(err.Error(), wantErr) {
+			t.Fatalf("error = %v, want %q", err, wantErr)
+		}
+		return
+	}
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !maps.Equal(config, want) {
+		t.Errorf("config = %v, want %v", config, want)
+	}
+}
+
+func TestBuildConfig(t *testing.T) {
+	sectionErr := errors.New("section failed")
+	d := &Driver{BaseDriver: &drivers.BaseDriver{MachineName: "machine"}}
+
+	tests := []struct {
+		name     string
+		sections []configSection
+		want     map[string]string
+		wantErr  error
+	}{
+		{
+			name: "no sections",
+			want: map[string]string{},
+		},
+		{
+			name:     "sections merged",
+			sections: []configSection{set("a", "1"), set("b", "2")},
+			want:     map[string]string{"a": "1", "b": "2"},
+		},
+		{
+			name:     "later section wins a conflicting key",
+			sections: []configSection{set(machineNameKey, "other"), d.userConfig},
+			want:     map[string]string{machineNameKey: "machine"},
+		},
+		{
+			name:     "copied config overridden by later sections",
+			sections: []configSection{copyConfig(map[string]string{"limits.cpu": "8", "raw.qemu": "-x"}), set("limits.cpu", "2")},
+			want:     map[string]string{"limits.cpu": "2", "raw.qemu": "-x"},
+		},
+		{
+			name:     "error stops the build",
+			sections: []configSection{set("a", "1"), fail(sectionErr), set("b", "2")},
+			wantErr:  sectionErr,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config, err := buildConfig(tt.sections...)
+			if tt.wantErr != nil {
+				if !errors.Is(err, tt.wantErr) {
+					t.Fatalf("error = %v, want %v", err, tt.wantErr)
+				}
+				if config != nil {
+					t.Errorf("config = %v, want nil on error", config)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if !maps.Equal(config, tt.want) {
+				t.Errorf("config = %v, want %v", config, tt.want)
+			}
+		})
+	}
+}
+
+func TestResourceConfig(t *testing.T) {
+	tests := []struct {
+		name    string
+		driver  *Driver
+		want    map[string]string
+		wantErr string
+	}{
+		{
+			name:   "limits",
+			driver: &Driver{CPU: 2, Memory: 2048, CPUPriority: -1, DiskPriority: -1},
+			want:   map[string]string{"limits.cpu": "2", "limits.memory": "2048MiB"},
+		},
+		{
+			name:   "priorities",
+			driver: &Driver{CPU: 2, Memory: 2048, CPUPriority: 0, DiskPriority: maxPriority},
+			want: map[string]string{
+				"limits.cpu": "2", "limits.memory": "2048MiB",
+				"limits.cpu.priority": "0", "limits.disk.priority": "10",
+			},
+		},
+		{
+			name:    "priority out of range",
+			driver:  &Driver{CPU: 2, Memory: 2048, CPUPriority: -1, DiskPriority: maxPriority + 1},
+			wantErr: "limits.disk.priority must be between 0 and 10",
+		},
+		{
+			name:   "soft memory of a container",
+			driver: &Driver{InstanceType: "container", CPU: 2, Memory: 2048, CPUPriority: -1, DiskPriority: -1, MemoryEnforce: "soft"},
+			want:   map[string]string{"limits.cpu": "2", "limits.memory": "2048MiB", "limits.memory.enforce": "soft"},
+		},
+		{
+			name:    "memory enforcement of a virtual machine",
+			driver:  &Driver{CPU: 2, Memory: 2048, CPUPriority: -1, DiskPriority: -1, MemoryEnforce: "soft"},
+			wantErr: "memory enforcement is only supported for container instances",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config, err := buildConfig(tt.driver.resourceConfig)
+			checkConfig(t, config, err, tt.want, tt.wantErr)
+		})
+	}
+}
+
+func TestSecurityConfig(t *testing.T) {
+	tests := []struct {
+		name    string
+		driver  *Driver
+		want    map[string]string
+		wantErr string
+	}{
+		{
+			name:   "defaults",
+			driver: &Driver{},
+			want:   map[string]string{},
+		},
+		{
+			name:   "isolated idmap",
+			driver: &Driver{InstanceType: "container", IdmapIsolated: true},
+			want:   map[string]string{"security.idmap.isolated": "true"},
+		},
+		{
+			name:   "idmap size implies isolation",
+			driver: &Driver{InstanceType: "container", IdmapSize: 65536},
+			want:   map[string]string{"security.idmap.isolated": "true", "security.idmap.size": "65536"},
+		},
+		{
+			name:    "idmap of a virtual machine",
+			driver:  &Driver{IdmapIsolated: true},
+			wantErr: "idmap options are only supported for container instances",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config, err := buildConfig(tt.driver.securityConfig)
+			checkConfig(t, config, err, tt.want, tt.wantErr)
+		})
+	}
+}
+
+func TestBootConfig(t *testing.T) {
+	tests := []struct {
+		name    string
+		driver  *Driver
+		want    map[string]string
+		wantErr string
+	}{
+		{
+			name:   "default firmware",
+			driver: &Driver{},
+			want:   map[string]string{},
+		},
+		{
+			name:   "secureboot",
+			driver: &Driver{Firmware: "secureboot"},
+			want:   map[string]string{"security.secureboot": "true", "security.csm": "false"},
+		},
+		{
+			name:   "csm",
+			driver: &Driver{InstanceType: "virtual-machine", Firmware: "csm"},
+			want:   map[string]string{"security.secureboot": "false", "security.csm": "true"},
+		},
+		{
+			name:    "firmware of a container",
+			driver:  &Driver{InstanceType: "container", Firmware: "uefi"},
+			wantErr: "firmware options are only supported for virtual machine instances",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config, err := buildConfig(tt.driver.bootConfig)
+			checkConfig(t, config, err, tt.want, tt.wantErr)
+		})
+	}
+}
+
+func TestCPUConfig(t *testing.T) {
+	tests := []struct {
+		name    string
+		driver  *Driver
+		want    map[string]string
+		wantErr string
+	}{
+		{
+			name:   "default CPU",
+			driver: &Driver{},
+			want:   map[string]string{},
+		},
+		{
+			name:   "model",
+			driver: &Driver{CPUModel: "EPYC"},
+			want:   map[string]string{"raw.qemu": "-cpu EPYC"},
+		},
+		{
+			name:   "flags on the host CPU",
+			driver: &Driver{CPUFlags: []string{"avx2", "+aes", "-svm"}},
+			want:   map[string]string{"raw.qemu": "-cpu host,avx2=on,aes=on,svm=off"},
+		},
+		{
+			name:    "model of a container",
+			driver:  &Driver{InstanceType: "container", CPUModel: "EPYC"},
+			wantErr: "CPU model and flags are only supported for virtual machine instances",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config, err := buildConfig(tt.driver.cpuConfig)
+			checkConfig(t, config, err, tt.want, tt.wantErr)
+		})
+	}
+}
+
+func TestStatefulConfig(t *testing.T) {
+	tests := []struct {
+		name    string
+		driver  *Driver
+		want    map[string]string
+		wantErr string
+	}{
+		{
+			name:   "stateless",
+			driver: &Driver{},
+			want:   map[string]string{},
+		},
+		{
+			name:   "stateful virtual machine",
+			driver: &Driver{Stateful: true},
+			want:   map[string]string{"migration.stateful": "true"},
+		},
+		{
+			name:    "stateful container",
+			driver:  &Driver{InstanceType: "container", Stateful: true},
+			wantErr: "stateful operations are only supported for virtual machine instances",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config, err := buildConfig(tt.driver.statefulConfig)
+			checkConfig(t, config, err, tt.want, tt.wantErr)
+		})
+	}
+}
+
+func TestContainerConfig(t *testing.T) {
+	tests := []struct {
+		name   string
+		driver *Driver
+		want   map[string]string
+	}{
+		{
+			name:   "virtual machine",
+			driver: &Driver{},
+			want:   map[string]string{},
+		},
+		{
+			name:   "container",
+			driver: &Driver{InstanceType: "container"},
+			want: map[string]string{
+				"security.nesting":                     "true",
+				"security.syscalls.intercept.mknod":    "true",
+				"security.syscalls.intercept.setxattr": "true",
+				"linux.kernel_modules":                 containerKernelModules,
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config, err := buildConfig(tt.driver.containerConfig)
+			checkConfig(t, config, err, tt.want, "")
+		})
+	}
+}
+
+func TestUserConfig(t *testing.T) {
+	tests := []struct {
+		name   string
+		driver *Driver
+		want   map[string]string
+	}{
+		{
+			name:   "machine name",
+			driver: &Driver{BaseDriver: &drivers.BaseDriver{MachineName: "machine"}},
+			want:   map[string]string{machineNameKey: "machine"},
+		},
+		{
+			name:   "machine cluster",
+			driver: &Driver{BaseDriver: &drivers.BaseDriver{MachineName: "machine"}, MachineCluster: "prod"},
+			want:   map[string]string{machineNameKey: "machine", machineClusterKey: "prod"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config, err := buildConfig(tt.driver.userConfig)
+			checkConfig(t, config, err, tt.want, "")
+		})
+	}
+}
+
+func TestCloudInitConfig(t *testing.T) {
+	dir := t.TempDir()
+	writeFile := func(name, content string) string {
+		path := filepath.Join(dir, name)
+		if err := os.WriteFile(path, []byte(content), 0600); err != nil {
+			t.Fatal(err)
+		}
+		return path
+	}
+
+	userData := writeFile("user-data", "#cloud-config\npackages: [jq]\n")
+	script := writeFile("script", "#!/bin/sh\necho ready\n")
+	unknown := writeFile("unknown", "packages: [jq]\n")
+
+	base := &drivers.BaseDriver{MachineName: "machine"}
+	metaData := "docker-machine-name: machine\n"
+
+	tests := []struct {
+		name    string
+		driver  *Driver
+		want    map[string]string
+		wantErr string
+	}{
+		{
+			name:   "vendor-data and meta-data",
+			driver: &Driver{BaseDriver: base},
+			want:   map[string]string{"cloud-init.vendor-data": "#cloud-config\n", "user.meta-data": metaData},
+		},
+		{
+			name:   "user-data",
+			driver: &Driver{BaseDriver: base, CloudInitUserData: userData},
+			want: map[string]string{
+				"cloud-init.vendor-data": "#cloud-config\n",
+				"cloud-init.user-data":   "#cloud-config\npackages: [jq]\n",
+				"user.meta-data":         metaData,
+			},
+		},
+		{
+			name:   "script user-data passed unmodified",
+			driver: &Driver{BaseDriver: base, CloudInitUserData: script},
+			want: map[string]string{
+				"cloud-init.vendor-data": "#cloud-config\n",
+				"cloud-init.user-data":   "#!/bin/sh\necho ready\n",
+				"user.meta-data":         metaData,
+			},
+		},
+		{
+			name:   "user-data left to the profile",
+			driver: &Driver{BaseDriver: base, ProfileOnly: true, CloudInitUserData: userData},
+			want:   map[string]string{"cloud-init.vendor-data": "#cloud-config\n", "user.meta-data": metaData},
+		},
+		{
+			name:   "meta-data",
+			driver: &Driver{BaseDriver: base, MetaData: map[string]string{"rack": "r1"}},
+			want: map[string]string{
+				"cloud-init.vendor-data": "#cloud-config\n",
+				"user.meta-data":         "docker-machine-name: machine\nrack: r1\n",
+			},
+		},
+		{
+			name:   "meta-data with cluster name",
+			driver: &Driver{BaseDriver: base, MachineCluster: "prod"},
+			want: map[string]string{
+				"cloud-init.vendor-data": "#cloud-config\n",
+				"user.meta-data":         "docker-machine-cluster: prod\ndocker-machine-name: machine\n",
+			},
+		},
+		{
+			name:    "unknown user-data format",
+			driver:  &Driver{BaseDriver: base, CloudInitUserData: unknown},
+			wantErr: "unknown format",
+		},
+		{
+			name:    "missing user-data",
+			driver:  &Driver{BaseDriver: base, CloudInitUserData: filepath.Join(dir, "missing")},
+			wantErr: "failed to read user-data",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config, err := buildConfig(tt.driver.cloudInitConfig("#cloud-config\n"))
+			checkConfig(t, config, err, tt.want, tt.wantErr)
+		})
+	}
+}
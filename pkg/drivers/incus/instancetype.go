@@ -0,0 +1,63 @@
+package incus
+
+import (
+	"fmt"
+
+	"github.com/lxc/incus/v6/shared/api"
+)
+
+// containerDataPath is where the data volume of a container is mounted, as
+// containers can not be given a block volume to format
+const containerDataPath = "/var/lib/docker"
+
+// containerKernelModules are loaded on the host before the container starts,
+// Docker can not load them from inside it
+const containerKernelModules = "ip_tables,ip6_tables,iptable_nat,nf_nat,netlink_diag,overlay,br_netfilter"
+
+func parseInstanceType(value string) (string, error) {
+	switch value {
+	case "", "vm", string(api.InstanceTypeVM):
+		return string(api.InstanceTypeVM), nil
+	case string(api.InstanceTypeContainer):
+		return value, nil
+	}
+
+	return "", fmt.Errorf("invalid instance type %q, expected container or vm", value)
+}
+
+// instanceType returns the type of instance the driver creates, machines
+// created by older drivers are virtual machines
+func (d *Driver) instanceType() api.InstanceType {
+	if d.InstanceType == "" {
+		return api.InstanceTypeVM
+	}
+
+	return api.InstanceType(d.InstanceType)
+}
+
+// containerConfig lets Docker run inside system containers
+func (d *Driver) containerConfig(config map[string]string) error {
+	if d.instanceType() != api.InstanceTypeContainer {
+		return nil
+	}
+
+	config["security.nesting"] = "true"
+	config["security.syscalls.intercept.mknod"] = "true"
+	config["security.syscalls.intercept.setxattr"] = "true"
+	config["linux.kernel_modules"] = containerKernelModules
+
+	return nil
+}
+
+// checkInstanceType rejects the options only virtual machines support
+func (d *Driver) checkInstanceType() error {
+	if d.instanceType() != api.InstanceTypeContainer {
+		return nil
+	}
+
+	if d.ISO != "" {
+		return fmt.Errorf("ISO installs are only supported for virtual machine instances")
+	}
+
+	return nil
+}
@@ -0,0 +1,98 @@
+package incus
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/docker/machine/libmachine/log"
+	incus "github.com/lxc/incus/v6/client"
+	"github.com/lxc/incus/v6/shared/api"
+)
+
+// getISODevice returns the device booting the instance from the ISO, which
+// is either an existing iso volume of the storage pool or a local file
+// imported as a volume dedicated to the machine
+func (d *Driver) getISODevice(client incus.InstanceServer) (map[string]string, error) {
+	name := d.ISO
+	if _, err := os.Stat(d.ISO); err == nil {
+		file, err := os.Open(d.ISO)
+		if err != nil {
+			return nil, fmt.Errorf("failed to open ISO %s: %w", d.ISO, err)
+		}
+		defer file.Close()
+
+		name = d.volumeName("iso")
+		log.Infof("Importing ISO %s as volume %s...", d.ISO, name)
+		op, err := client.CreateStoragePoolVolumeFromISO(d.Storage, incus.StorageVolumeBackupArgs{
+			BackupFile: file,
+			Name:       name,
+		})
+		if err != nil {
+			return nil, fmt.Errorf("failed to import ISO %s: %w", d.ISO, err)
+		}
+
+		err = d.waitOperation(op)
+		if err != nil {
+			return nil, fmt.Errorf("failed to import ISO %s: %w", d.ISO, err)
+		}
+		d.Volumes = append(d.Volumes, Volume{Pool: d.Storage, Name: name})
+	} else {
+		volume, _, err := client.GetStoragePoolVolume(d.Storage, "custom", name)
+		if err != nil {
+			return nil, fmt.Errorf("ISO volume %s not found on storage %s: %w", name, d.Storage, err)
+		}
+
+		if volume.ContentType != "iso" {
+			return nil, fmt.Errorf("volume %s is not an ISO volume", name)
+		}
+	}
+
+	device := map[string]string{
+		"type":   "disk",
+		"pool":   d.Storage,
+		"source": name,
+	}
+	d.setBootPriority(device, "iso")
+
+	return device, nil
+}
+
+// waitForInstall waits for the installer booted from the ISO to power the
+// instance off, then detaches the ISO so the installed system boots
+func (d *Driver) waitForInstall(client incus.InstanceServer) error {
+	if d.ISO == "" {
+		return nil
+	}
+
+	log.Infof("Waiting for the installation of %s to complete...", d.MachineName)
+	err := retry(d.timeouts().ISOInstall, func() (bool, error) {
+		state, _, err := client.GetInstanceState(d.MachineName)
+		if err != nil {
+			return true, err
+		}
+
+		return state.StatusCode == api.Stopped, nil
+	})
+	if err != nil {
+		return fmt.Errorf("waiting for the installation to power off the instance: %w", err)
+	}
+
+	instance, etag, err := client.GetInstance(d.MachineName)
+	if err != nil {
+		return err
+	}
+	delete(instance.Devices, d.deviceName("iso"))
+
+	op, err := client.UpdateInstance(d.MachineName, instance.Writable(), etag)
+	if err != nil {
+		return fmt.Errorf("failed to detach ISO: %w", d.instancePermissionError(err, "can_edit"))
+	}
+
+	err = d.waitOperation(op)
+	if err != nil {
+		return fmt.Errorf("failed to detach ISO: %w", err)
+	}
+	d.recordInstance(instance.Writable())
+
+	return d.startInstance(client)
+}
@@ -0,0 +1,207 @@
+package incus
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/docker/machine/libmachine/log"
+	incus "github.com/lxc/incus/v6/client"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+)
+
+const (
+	knownHostsFile = "known_hosts"
+	hostKeysCmd    = "cat /etc/ssh/ssh_host_*_key.pub"
+)
+
+// GetSSHKnownHostsPath returns the known_hosts file holding the host keys
+// of the machine
+func (d *Driver) GetSSHKnownHostsPath() string {
+	return d.ResolveStorePath(knownHostsFile)
+}
+
+// recordHostKeys reads the SSH host keys of the guest through the Incus
+// agent, which is trusted, writes them to the machine known_hosts file and
+// checks the SSH server presents one of them; the provisioning SSH client
+// of libmachine ignores host keys, only the connections the driver opens
+// itself are checked against the file
+func (d *Driver) recordHostKeys(client incus.InstanceServer) error {
+	if d.SSHSkipHostKeyCheck {
+		log.Warnf("Skipping the SSH host key check of %s", d.MachineName)
+		return nil
+	}
+
+	// images without the guest agent still provision, the provisioning SSH
+	// does not use the recorded keys anyway
+	keys, err := d.readHostKeys(client)
+	if err != nil {
+		log.Warnf("Failed to read SSH host keys of %s through the Incus agent, its SSH host key is not checked: %s", d.MachineName, err)
+		return nil
+	}
+
+	port, err := d.GetSSHPort()
+	if err != nil {
+		return err
+	}
+	address := net.JoinHostPort(d.IPAddress, fmt.Sprintf("%d", port))
+
+	var b strings.Builder
+	for _, key := range keys {
+		b.WriteString(knownhosts.Line([]string{knownhosts.Normalize(address)}, key))
+		b.WriteString("\n")
+	}
+
+	if err := os.WriteFile(d.GetSSHKnownHostsPath(), []byte(b.String()), 0600); err != nil {
+		return fmt.Errorf("failed to write known_hosts: %w", err)
+	}
+
+	return d.verifyHostKey(address)
+}
+
+// readHostKeys waits for sshd to generate its host keys and returns them
+func (d *Driver) readHostKeys(client incus.InstanceServer) ([]ssh.PublicKey, error) {
+	var keys []ssh.PublicKey
+	err := retry(d.timeouts().SSH, func() (bool, error) {
+		output, err := d.exec(client, "sh", "-c", hostKeysCmd)
+		if err != nil {
+			return false, err
+		}
+
+		keys, err = parseHostKeys(output)
+		return true, err
+	})
+
+	return keys, err
+}
+
+// verifyHostKey does an SSH handshake with the guest and fails if the host
+// key does not match the recorded ones or can not be checked
+func (d *Driver) verifyHostKey(address string) error {
+	config, err := d.sshConfig()
+	if err != nil {
+		return err
+	}
+
+	// no auth method is offered, the handshake fails the authentication
+	// once the host key was checked, which only the callback tells apart
+	// from the other failures
+	verified := false
+	callback := config.HostKeyCallback
+	config.HostKeyCallback = func(hostname string, remote net.Addr, key ssh.PublicKey) error {
+		err := callback(hostname, remote, key)
+		verified = err == nil
+		return err
+	}
+
+	mismatch := fmt.Errorf("SSH host key of %s does not match the key reported by the Incus agent", address)
+	err = retry(d.timeouts().SSH, func() (bool, error) {
+		verified = false
+		conn, err := ssh.Dial("tcp", address, config)
+		if conn != nil {
+			conn.Close()
+		}
+
+		var keyErr *knownhosts.KeyError
+		if errors.As(err, &keyErr) {
+			return true, mismatch
+		}
+
+		if verified {
+			log.Infof("SSH host key of %s verified", address)
+			return true, nil
+		}
+
+		return false, err
+	})
+
+	if err == nil || errors.Is(err, mismatch) {
+		return err
+	}
+
+	return fmt.Errorf("failed to verify the SSH host key of %s, --incus-ssh-skip-host-key-check creates the machine without: %w", address, err)
+}
+
+// hasHostKeys reports whether the host keys of the guest were recorded,
+// which machines created by older drivers or whose agent could not read
+// them have not
+func (d *Driver) hasHostKeys() bool {
+	_, err := os.Stat(d.GetSSHKnownHostsPath())
+	return err == nil
+}
+
+// sshConfig returns the config of the SSH connections the driver opens
+// itself, verifying the host key against the recorded ones; machines
+// without recorded host keys are not checked, like by the provisioning
+func (d *Driver) sshConfig(auth ...ssh.AuthMethod) (*ssh.ClientConfig, error) {
+	callback := ssh.InsecureIgnoreHostKey()
+	if d.hasHostKeys() {
+		var err error
+		if callback, err = knownhosts.New(d.GetSSHKnownHostsPath()); err != nil {
+			return nil, fmt.Errorf("failed to load known_hosts: %w", err)
+		}
+	} else {
+		log.Warnf("No SSH host key recorded for %s, not checking it", d.MachineName)
+	}
+
+	return &ssh.ClientConfig{
+		Config: ssh.Config{
+			Ciphers:      clientAlgorithms(d.SSHCiphers, clientCiphers),
+			MACs:         clientAlgorithms(d.SSHMACs, clientMACs),
+			KeyExchanges: clientAlgorithms(d.SSHKexAlgorithms, clientKexAlgorithms),
+		},
+		User:            d.GetSSHUsername(),
+		Auth:            auth,
+		HostKeyCallback: callback,
+		Timeout:         10 * time.Second,
+	}, nil
+}
+
+// dialSSH logs in to the guest with the private key, verifying its host key
+func (d *Driver) dialSSH(keyPath string) (*ssh.Client, error) {
+	key, err := os.ReadFile(keyPath)
+	if err != nil {
+		return nil, err
+	}
+	signer, err := ssh.ParsePrivateKey(key)
+	if err != nil {
+		return nil, err
+	}
+
+	config, err := d.sshConfig(ssh.PublicKeys(signer))
+	if err != nil {
+		return nil, err
+	}
+
+	port, err := d.GetSSHPort()
+	if err != nil {
+		return nil, err
+	}
+
+	return ssh.Dial("tcp", net.JoinHostPort(d.IPAddress, fmt.Sprintf("%d", port)), config)
+}
+
+func parseHostKeys(output string) ([]ssh.PublicKey, error) {
+	keys := []ssh.PublicKey{}
+	for _, line := range strings.Split(output, "\n") {
+		if strings.TrimSpace(line) == "" {
+			continue
+		}
+
+		key, _, _, _, err := ssh.ParseAuthorizedKey([]byte(line))
+		if err != nil {
+			return nil, fmt.Errorf("invalid host key %q: %w", line, err)
+		}
+		keys = append(keys, key)
+	}
+
+	if len(keys) == 0 {
+		return nil, fmt.Errorf("no host key found")
+	}
+
+	return keys, nil
+}
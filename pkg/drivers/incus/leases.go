@@ -0,0 +1,74 @@
+package incus
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	"github.com/docker/machine/libmachine/log"
+	incus "github.com/lxc/incus/v6/client"
+	"github.com/lxc/incus/v6/shared/api"
+)
+
+// leaseReleaseTimeout bounds the DHCP release run in the guest on Remove
+const leaseReleaseTimeout = 15 * time.Second
+
+// leaseReleaseScript takes the NIC down so the DHCP client sends a release,
+// networkd does on link down, dhclient needs to be asked
+const leaseReleaseScript = `if command -v dhclient >/dev/null 2>&1 && dhclient -r "$1" 2>/dev/null; then exit 0; fi
+networkctl down "$1" 2>/dev/null || ip link set "$1" down`
+
+// releaseLease asks the guest to release its DHCP lease on managed bridges,
+// where Incus only clears the leases of the member hosting the instance
+func (d *Driver) releaseLease(client incus.InstanceServer) {
+	if d.NetworkType != "bridge" {
+		return
+	}
+
+	instance, _, err := client.GetInstanceState(d.MachineName)
+	if err != nil || (instance.StatusCode != api.Running && instance.StatusCode != api.Ready) {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), leaseReleaseTimeout)
+	defer cancel()
+
+	if _, err := d.execContext(ctx, client, "sh", "-c", leaseReleaseScript, "sh", d.nicName()); err != nil {
+		log.Debugf("Unable to release DHCP lease of %s: %s", d.MachineName, err)
+	}
+}
+
+// checkStaleLeases reports the leases of the machine left on the network
+// once the instance is deleted; the static reservation pinned by Recreate
+// lives on the instance NIC and goes away with it
+func (d *Driver) checkStaleLeases() {
+	if d.NetworkType != "bridge" || d.Network == "" {
+		return
+	}
+
+	client, err := d.getNetworkClient()
+	if err != nil {
+		return
+	}
+
+	leases, err := client.GetNetworkLeases(d.Network)
+	if err != nil {
+		log.Debugf("Unable to list leases of network %s: %s", d.Network, err)
+		return
+	}
+
+	stale := []string{}
+	for _, lease := range leases {
+		if lease.Type == "gateway" || lease.Type == "uplink" {
+			continue
+		}
+
+		if (d.NICHwaddr != "" && strings.EqualFold(lease.Hwaddr, d.NICHwaddr)) || lease.Hostname == d.MachineName {
+			stale = append(stale, lease.Address)
+		}
+	}
+
+	if len(stale) > 0 {
+		log.Warnf("Network %s still holds leases of %s until they expire: %s", d.Network, d.MachineName, strings.Join(stale, ", "))
+	}
+}
@@ -0,0 +1,179 @@
+package incus
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"slices"
+	"strings"
+
+	"github.com/docker/machine/libmachine/log"
+	incus "github.com/lxc/incus/v6/client"
+	"github.com/lxc/incus/v6/shared/api"
+)
+
+const (
+	// defaultLoadBalancerPorts are the Kubernetes API and ingress ports of a
+	// control-plane node
+	defaultLoadBalancerPorts = "6443,80,443"
+
+	// loadBalancerCreatedKey marks the load balancers the driver created,
+	// the only ones it deletes along with their last backend
+	loadBalancerCreatedKey = "user.docker-machine.created"
+
+	// loadBalancerUpdateAttempts bounds the updates lost to other machines
+	// changing the load balancer at the same time
+	loadBalancerUpdateAttempts = 5
+)
+
+// checkLoadBalancer verifies the load balancer options fit the network
+func (d *Driver) checkLoadBalancer() error {
+	if d.LoadBalancerAddress == "" {
+		return nil
+	}
+
+	if !d.IsOVN {
+		return fmt.Errorf("load balancers are only supported on OVN networks")
+	}
+
+	if net.ParseIP(d.LoadBalancerAddress) == nil {
+		return fmt.Errorf("invalid load balancer address %q", d.LoadBalancerAddress)
+	}
+
+	return nil
+}
+
+// attachLoadBalancer adds the instance as a backend of the load balancer
+// listening on the VIP, creating the load balancer when it does not exist
+func (d *Driver) attachLoadBalancer() error {
+	if d.LoadBalancerAddress == "" || d.IPAddress == "" {
+		return nil
+	}
+
+	client, err := d.getNetworkClient()
+	if err != nil {
+		return err
+	}
+
+	_, _, err = client.GetNetworkLoadBalancer(d.Network, d.LoadBalancerAddress)
+	if api.StatusErrorCheck(err, http.StatusNotFound) {
+		log.Infof("Creating load balancer %s on network %s", d.LoadBalancerAddress, d.Network)
+		req := api.NetworkLoadBalancersPost{
+			ListenAddress: d.LoadBalancerAddress,
+			NetworkLoadBalancerPut: api.NetworkLoadBalancerPut{
+				Description: "Created by Rancher Machine",
+				Config:      map[string]string{loadBalancerCreatedKey: "true"},
+			},
+		}
+		err = client.CreateNetworkLoadBalancer(d.Network, req)
+		// another machine of the cluster created it in between
+		if api.StatusErrorCheck(err, http.StatusConflict) {
+			err = nil
+		}
+		if err != nil {
+			return fmt.Errorf("failed to create load balancer %s: %w", d.LoadBalancerAddress, err)
+		}
+	} else if err != nil {
+		return fmt.Errorf("failed to get load balancer %s: %w", d.LoadBalancerAddress, err)
+	}
+
+	err = d.updateLoadBalancer(client, func(put *api.NetworkLoadBalancerPut) bool {
+		put.Backends = slices.DeleteFunc(put.Backends, func(backend api.NetworkLoadBalancerBackend) bool {
+			return backend.Name == d.MachineName
+		})
+		put.Backends = append(put.Backends, api.NetworkLoadBalancerBackend{
+			Name:          d.MachineName,
+			Description:   fmt.Sprintf("Rancher Machine %s", d.MachineName),
+			TargetAddress: d.IPAddress,
+		})
+
+		for _, entry := range d.LoadBalancerPorts {
+			port, protocol, _ := strings.Cut(entry, "/")
+			i := slices.IndexFunc(put.Ports, func(p api.NetworkLoadBalancerPort) bool {
+				return p.ListenPort == port && p.Protocol == protocol
+			})
+			if i < 0 {
+				put.Ports = append(put.Ports, api.NetworkLoadBalancerPort{Protocol: protocol, ListenPort: port})
+				i = len(put.Ports) - 1
+			}
+			if !slices.Contains(put.Ports[i].TargetBackend, d.MachineName) {
+				put.Ports[i].TargetBackend = append(put.Ports[i].TargetBackend, d.MachineName)
+			}
+		}
+
+		return true
+	})
+	if err != nil {
+		return fmt.Errorf("failed to add %s to load balancer %s: %w", d.MachineName, d.LoadBalancerAddress, err)
+	}
+
+	log.Infof("Instance %s attached to load balancer %s", d.MachineName, d.LoadBalancerAddress)
+	return nil
+}
+
+// detachLoadBalancer removes the instance from the load balancer, deleting
+// the load balancer once it has no backend left if the driver created it
+func (d *Driver) detachLoadBalancer() error {
+	if d.LoadBalancerAddress == "" {
+		return nil
+	}
+
+	client, err := d.getNetworkClient()
+	if err != nil {
+		return err
+	}
+
+	err = d.updateLoadBalancer(client, func(put *api.NetworkLoadBalancerPut) bool {
+		put.Backends = slices.DeleteFunc(put.Backends, func(backend api.NetworkLoadBalancerBackend) bool {
+			return backend.Name == d.MachineName
+		})
+		if len(put.Backends) == 0 && put.Config[loadBalancerCreatedKey] == "true" {
+			return false
+		}
+
+		ports := []api.NetworkLoadBalancerPort{}
+		for _, port := range put.Ports {
+			port.TargetBackend = slices.DeleteFunc(port.TargetBackend, func(name string) bool {
+				return name == d.MachineName
+			})
+			if len(port.TargetBackend) > 0 {
+				ports = append(ports, port)
+			}
+		}
+		put.Ports = ports
+
+		return true
+	})
+	if api.StatusErrorCheck(err, http.StatusNotFound) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to remove %s from load balancer %s: %w", d.MachineName, d.LoadBalancerAddress, err)
+	}
+
+	return nil
+}
+
+// updateLoadBalancer applies update to the load balancer, starting over
+// from its current config when another machine changed it in between;
+// update returns false to delete the load balancer instead
+func (d *Driver) updateLoadBalancer(client incus.InstanceServer, update func(put *api.NetworkLoadBalancerPut) bool) error {
+	for attempt := 1; ; attempt++ {
+		lb, etag, err := client.GetNetworkLoadBalancer(d.Network, d.LoadBalancerAddress)
+		if err != nil {
+			return err
+		}
+
+		put := lb.Writable()
+		if !update(&put) {
+			log.Infof("Deleting load balancer %s without backends", d.LoadBalancerAddress)
+			return client.DeleteNetworkLoadBalancer(d.Network, d.LoadBalancerAddress)
+		}
+
+		err = client.UpdateNetworkLoadBalancer(d.Network, d.LoadBalancerAddress, put, etag)
+		if !api.StatusErrorCheck(err, http.StatusPreconditionFailed) || attempt == loadBalancerUpdateAttempts {
+			return err
+		}
+		log.Debugf("Load balancer %s changed during the update, retrying", d.LoadBalancerAddress)
+	}
+}
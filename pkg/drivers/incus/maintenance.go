@@ -0,0 +1,152 @@
+package incus
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// machineCommand is a maintenance operation run on one machine of the store
+type machineCommand struct {
+	args  []string
+	usage string
+	// whether the operation updates the driver config, written back to the
+	// store once it succeeded
+	save bool
+	run  func(w io.Writer, d *Driver, args []string) error
+}
+
+var machineCommands = map[string]machineCommand{
+	"--upgrade-os": {
+		usage: "upgrade the guest operating system and reboot the machine",
+		save:  true,
+		run: func(_ io.Writer, d *Driver, _ []string) error {
+			return d.UpgradeOS()
+		},
+	},
+	"--grow-disk": {
+		args:  []string{"SIZE"},
+		usage: "grow the root disk of the machine to SIZE MiB",
+		save:  true,
+		run: func(_ io.Writer, d *Driver, args []string) error {
+			size, err := strconv.Atoi(args[0])
+			if err != nil {
+				return fmt.Errorf("invalid disk size %q", args[0])
+			}
+			return d.GrowDisk(size)
+		},
+	},
+	"--recreate": {
+		usage: "replace the instance with a fresh one built from its image",
+		save:  true,
+		run: func(_ io.Writer, d *Driver, _ []string) error {
+			return d.Recreate()
+		},
+	},
+	"--rotate-ssh-key": {
+		usage: "replace the SSH key of the machine",
+		save:  true,
+		run: func(_ io.Writer, d *Driver, _ []string) error {
+			return d.RotateSSHKey()
+		},
+	},
+	"--check-drift": {
+		usage: "report the changes made to the instance outside of the driver",
+		run: func(w io.Writer, d *Driver, _ []string) error {
+			drift, err := d.CheckDrift()
+			if err != nil {
+				return err
+			}
+			for _, change := range drift {
+				fmt.Fprintln(w, change)
+			}
+			if len(drift) > 0 {
+				return fmt.Errorf("%s drifted from its recorded config", d.MachineName)
+			}
+			return nil
+		},
+	},
+	"--run-command": {
+		args:  []string{"COMMAND"},
+		usage: "run a shell command in the machine, through the Incus agent while SSH is not reachable",
+		run: func(w io.Writer, d *Driver, args []string) error {
+			output, err := d.RunCommand(args[0])
+			fmt.Fprint(w, output)
+			return err
+		},
+	},
+}
+
+// RunMachineCommand runs the maintenance operation named by the flag on a
+// machine of the store, ex: --grow-disk NAME SIZE, and reports whether the
+// flag is one; docker-machine must not be using the machine meanwhile
+func RunMachineCommand(w io.Writer, flag string, args []string) (bool, error) {
+	command, ok := machineCommands[flag]
+	if !ok {
+		return false, nil
+	}
+
+	if len(args) != len(command.args)+1 {
+		usage := append([]string{flag, "NAME"}, command.args...)
+		return true, fmt.Errorf("usage: %s, %s", strings.Join(usage, " "), command.usage)
+	}
+
+	path, host, d, err := loadMachine(args[0])
+	if err != nil {
+		return true, err
+	}
+	defer d.Close()
+
+	if err := command.run(w, d, args[1:]); err != nil {
+		return true, err
+	}
+
+	if !command.save {
+		return true, nil
+	}
+
+	if host["Driver"], err = json.Marshal(d); err != nil {
+		return true, err
+	}
+
+	return true, writeMachineConfig(path, host)
+}
+
+// loadMachine reads the config of a machine of the store created by this
+// driver
+func loadMachine(name string) (string, map[string]json.RawMessage, *Driver, error) {
+	store, err := machineStorePath()
+	if err != nil {
+		return "", nil, nil, err
+	}
+
+	path := filepath.Join(store, "machines", name, "config.json")
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return "", nil, nil, fmt.Errorf("machine %s not found: %w", name, err)
+	}
+
+	host := map[string]json.RawMessage{}
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	if err := decoder.Decode(&host); err != nil {
+		return "", nil, nil, fmt.Errorf("invalid machine config: %w", err)
+	}
+
+	var driverType string
+	if err := json.Unmarshal(host["DriverName"], &driverType); err != nil || driverType != driverName {
+		return "", nil, nil, fmt.Errorf("machine %s is not an %s machine", name, driverName)
+	}
+
+	d := NewDriver("", "").(*Driver)
+	if err := json.Unmarshal(host["Driver"], d); err != nil {
+		return "", nil, nil, fmt.Errorf("invalid driver config: %w", err)
+	}
+
+	return path, host, d, nil
+}
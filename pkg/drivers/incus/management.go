@@ -0,0 +1,75 @@
+package incus
+
+import (
+	"fmt"
+	"net"
+	"slices"
+)
+
+const (
+	managementNICName = "mgmt0"
+	// managementRouteTable holds the routes of the traffic sourced from the
+	// management address, so replies leave through the management NIC
+	managementRouteTable = 100
+)
+
+// getManagementNetwork resolves the NIC of the management network and its
+// subnet for the policy routing of the guest
+func (d *Driver) getManagementNetwork() (map[string]string, error) {
+	if d.NoNIC {
+		return nil, fmt.Errorf("a management network needs the primary NIC of the driver")
+	}
+	if d.ManagementNetwork == d.Network {
+		return nil, fmt.Errorf("the management network must differ from network %s", d.Network)
+	}
+
+	client, err := d.getNetworkClient()
+	if err != nil {
+		return nil, err
+	}
+
+	network, _, err := client.GetNetwork(d.ManagementNetwork)
+	if err != nil {
+		return nil, fmt.Errorf("management network %s not found: %w", d.ManagementNetwork, err)
+	}
+	if !slices.Contains([]string{"bridge", "ovn"}, network.Type) {
+		return nil, fmt.Errorf("management network type %s not supported", network.Type)
+	}
+
+	gateway, subnet, err := net.ParseCIDR(network.Config["ipv4.address"])
+	if err != nil {
+		return nil, fmt.Errorf("management network %s has no IPv4 subnet", d.ManagementNetwork)
+	}
+	d.ManagementGateway = gateway.String()
+	d.ManagementSubnet = subnet.String()
+
+	d.ManagementHwaddr, err = generateHwaddr()
+	if err != nil {
+		return nil, err
+	}
+
+	return map[string]string{
+		"name":    managementNICName,
+		"type":    "nic",
+		"network": d.ManagementNetwork,
+		"hwaddr":  d.ManagementHwaddr,
+	}, nil
+}
+
+// getManagementEthernet renders the network-config of the management NIC,
+// which never carries the default route of the guest
+func (d *Driver) getManagementEthernet() map[string]interface{} {
+	return map[string]interface{}{
+		"match":           map[string]string{"macaddress": d.ManagementHwaddr},
+		"set-name":        managementNICName,
+		"dhcp4":           true,
+		"dhcp4-overrides": map[string]bool{"use-routes": false, "use-dns": false},
+		"routes": []map[string]interface{}{
+			{"to": d.ManagementSubnet, "scope": "link", "table": managementRouteTable},
+			{"to": "0.0.0.0/0", "via": d.ManagementGateway, "table": managementRouteTable},
+		},
+		"routing-policy": []map[string]interface{}{
+			{"from": d.ManagementSubnet, "table": managementRouteTable},
+		},
+	}
+}
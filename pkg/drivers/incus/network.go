@@ -0,0 +1,263 @@
+package incus
+
+import (
+	"crypto/rand"
+	"fmt"
+	"net"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/docker/machine/libmachine/log"
+	incus "github.com/lxc/incus/v6/client"
+	"github.com/lxc/incus/v6/shared/api"
+)
+
+// defaultNICName is the interface name the generated network-config and
+// the profiles of most setups expect
+const defaultNICName = "eth0"
+
+// nicNameRegexp matches the interface names Linux accepts
+var nicNameRegexp = regexp.MustCompile(`^[A-Za-z0-9_.-]{1,15}$`)
+
+var dnsDomainRegexp = regexp.MustCompile(`^([A-Za-z0-9]([A-Za-z0-9-]*[A-Za-z0-9])?\.)*[A-Za-z0-9]([A-Za-z0-9-]*[A-Za-z0-9])?\.?$`)
+
+// getNetworkClient returns a client scoped to the project owning the
+// networks, which is the default project unless features.networks is set,
+// or the project given explicitly as project/network
+func (d *Driver) getNetworkClient() (incus.InstanceServer, error) {
+	client, err := d.getClient()
+	if err != nil {
+		return nil, err
+	}
+
+	if d.NetworkProject != "" {
+		if _, _, err := client.GetProject(d.NetworkProject); err != nil {
+			return nil, fmt.Errorf("network project %s not found: %w", d.NetworkProject, err)
+		}
+		return client.UseProject(d.NetworkProject), nil
+	}
+
+	name, err := d.instanceNetworkProject(client)
+	if err != nil {
+		return nil, err
+	}
+
+	return client.UseProject(name), nil
+}
+
+// instanceNetworkProject returns the project the NICs of the instance
+// resolve managed networks in
+func (d *Driver) instanceNetworkProject(client incus.InstanceServer) (string, error) {
+	project, _, err := client.GetProject(d.Project)
+	if err != nil {
+		return "", fmt.Errorf("project %s not found: %w", d.Project, err)
+	}
+
+	if project.Name == api.ProjectDefaultName || project.Config["features.networks"] == "true" {
+		return project.Name, nil
+	}
+
+	return api.ProjectDefaultName, nil
+}
+
+// bridgeNIC returns the NIC attached to the managed bridge by its host
+// interface, which works from any project
+func (d *Driver) bridgeNIC() map[string]string {
+	nic := map[string]string{
+		"name":    d.nicName(),
+		"type":    "nic",
+		"nictype": "bridged",
+		"parent":  d.Network,
+		"hwaddr":  d.NICHwaddr,
+	}
+	if d.NICTxQueueLength > 0 {
+		nic["queue.tx.length"] = strconv.Itoa(d.NICTxQueueLength)
+	}
+
+	return nic
+}
+
+func (d *Driver) getNetwork() (map[string]string, error) {
+	if d.Network == "" {
+		return nil, fmt.Errorf("network is required")
+	}
+
+	client, err := d.getNetworkClient()
+	if err != nil {
+		return nil, err
+	}
+
+	network, _, err := client.GetNetwork(d.Network)
+	if err != nil {
+		return nil, fmt.Errorf("network %s not found: %w", d.Network, err)
+	}
+
+	if !slices.Contains([]string{"bridge", "ovn"}, network.Type) {
+		return nil, fmt.Errorf("network type %s not supported", network.Type)
+	}
+	d.NetworkType = network.Type
+
+	d.IPv6Only = isIPv6Only(network)
+	if d.IPv6Only {
+		// stateless dhcpv6 still hands out resolvers on slaac networks
+		d.NetworkDHCPv6 = network.Config["ipv6.dhcp"] != "false"
+		log.Infof("Network %s is IPv6-only, IPv4-only registries need NAT64/DNS64 on it to be reachable", d.Network)
+	}
+
+	// replace the primary NIC of the profile instead of adding a second one
+	instanceClient, err := d.getClient()
+	if err != nil {
+		return nil, err
+	}
+
+	profileDevices, err := d.getProfileDevices(instanceClient)
+	if err != nil {
+		return nil, fmt.Errorf("profile %s not found: %w", d.Profile, err)
+	}
+	d.NICDevice = d.deviceName("eth0")
+	if profileDevices["eth0"]["type"] == "nic" {
+		d.NICDevice = "eth0"
+	}
+
+	// fix the mac address so the guest network-config can match on it
+	d.NICHwaddr, err = generateHwaddr()
+	if err != nil {
+		return nil, err
+	}
+
+	// bridge
+	if network.Type == "bridge" {
+		if err := checkFanBridge(client, network); err != nil {
+			return nil, err
+		}
+
+		if isFanBridge(network) {
+			d.NetworkMTU, err = getFanMTU(network)
+			if err != nil {
+				return nil, err
+			}
+		}
+
+		return d.bridgeNIC(), nil
+	}
+
+	if d.NICTxQueueLength > 0 {
+		return nil, fmt.Errorf("the NIC transmit queue length can not be set on OVN networks")
+	}
+
+	// ovn network, only a bridge can be attached by its host interface
+	// from another project
+	if d.NetworkProject != "" {
+		project, err := d.instanceNetworkProject(client)
+		if err != nil {
+			return nil, err
+		}
+		if project != d.NetworkProject {
+			return nil, fmt.Errorf("OVN network %s of project %s can not be used by instances of project %s", d.Network, d.NetworkProject, d.Project)
+		}
+	}
+
+	if err := checkOVNHealth(client, network); err != nil {
+		return nil, err
+	}
+
+	if err := checkOVNProjectRestrictions(instanceClient, d.Project, network); err != nil {
+		return nil, err
+	}
+
+	d.NetworkMTU, err = getOVNMTU(client, network)
+	if err != nil {
+		return nil, err
+	}
+
+	d.IsOVN = true
+	return map[string]string{
+		"name":    d.nicName(),
+		"type":    "nic",
+		"network": d.Network,
+		"hwaddr":  d.NICHwaddr,
+	}, nil
+}
+
+// nicName returns the interface name of the NIC inside the instance
+func (d *Driver) nicName() string {
+	if d.NICName == "" {
+		return defaultNICName
+	}
+
+	return d.NICName
+}
+
+// isIPv6Only reports whether the managed network hands out no IPv4 addresses
+func isIPv6Only(network *api.Network) bool {
+	return network.Config["ipv4.address"] == "none" && network.Config["ipv6.address"] != "none"
+}
+
+// generateHwaddr returns a random mac address in the range Incus uses
+func generateHwaddr() (string, error) {
+	buf := make([]byte, 3)
+	if _, err := rand.Read(buf); err != nil {
+		return "", fmt.Errorf("failed to generate mac address: %w", err)
+	}
+
+	return fmt.Sprintf("00:16:3e:%02x:%02x:%02x", buf[0], buf[1], buf[2]), nil
+}
+
+func parseDNSServers(value string) ([]string, error) {
+	servers := []string{}
+	for _, server := range strings.Split(value, ",") {
+		server = strings.TrimSpace(server)
+		if server == "" {
+			continue
+		}
+		if net.ParseIP(server) == nil {
+			return nil, fmt.Errorf("invalid DNS server %q", server)
+		}
+		servers = append(servers, server)
+	}
+
+	return servers, nil
+}
+
+func parseDNSSearch(value string) ([]string, error) {
+	domains := []string{}
+	for _, domain := range strings.Split(value, ",") {
+		domain = strings.TrimSpace(domain)
+		if domain == "" {
+			continue
+		}
+		if !dnsDomainRegexp.MatchString(domain) {
+			return nil, fmt.Errorf("invalid DNS search domain %q", domain)
+		}
+		domains = append(domains, domain)
+	}
+
+	return domains, nil
+}
+
+func parseNICName(value string) (string, error) {
+	if value == "" {
+		return defaultNICName, nil
+	}
+	if !nicNameRegexp.MatchString(value) || value == "." || value == ".." {
+		return "", fmt.Errorf("invalid NIC name %q", value)
+	}
+
+	return value, nil
+}
+
+// parseNetworkName splits the optional project/ prefix of a network name
+func parseNetworkName(value string) (string, string, error) {
+	project, name, found := strings.Cut(value, "/")
+	if !found {
+		return "", value, nil
+	}
+
+	if project == "" || name == "" || strings.Contains(name, "/") {
+		return "", "", fmt.Errorf("invalid network name %q, expected [project/]network", value)
+	}
+
+	return project, name, nil
+}
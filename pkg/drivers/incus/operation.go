@@ -0,0 +1,259 @@
+package incus
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/docker/machine/libmachine/log"
+	incus "github.com/lxc/incus/v6/client"
+	"github.com/lxc/incus/v6/shared/api"
+)
+
+// parseOperationTimeout parses the operation timeout duration, kept in
+// seconds in the machine config
+func parseOperationTimeout(value string) (int, error) {
+	timeout, err := time.ParseDuration(value)
+	if err != nil || timeout < 0 {
+		return 0, fmt.Errorf("invalid operation timeout %q", value)
+	}
+
+	return int(timeout.Seconds()), nil
+}
+
+// waitOperation waits for an Incus operation bounded by the configured
+// operation timeout, cancelling the operation when it expires
+func (d *Driver) waitOperation(op incus.Operation) error {
+	return d.waitOperationTimeout(op, time.Duration(d.OperationTimeout)*time.Second)
+}
+
+// waitOperationTimeout is waitOperation with an explicit timeout, zero or
+// negative waiting forever
+func (d *Driver) waitOperationTimeout(op incus.Operation, timeout time.Duration) error {
+	d.trackOperation(op.Get().ID)
+	defer d.untrackOperation(op.Get().ID)
+
+	if timeout <= 0 {
+		return operationError(op, op.Wait())
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	err := op.WaitContext(ctx)
+	if !errors.Is(err, context.DeadlineExceeded) {
+		return operationError(op, err)
+	}
+
+	id := op.Get().ID
+	if err := op.Cancel(); err != nil {
+		log.Debugf("Unable to cancel operation %s: %s", id, err)
+	}
+
+	return fmt.Errorf("operation %s timed out after %s", id, timeout)
+}
+
+// waitRemoteOperationTimeout is waitOperationTimeout for the operations
+// copying from another server or project, which can only be cancelled
+// through their target operation
+func (d *Driver) waitRemoteOperationTimeout(op incus.RemoteOperation, timeout time.Duration) error {
+	id := "unknown"
+	if target, err := op.GetTarget(); err == nil {
+		id = target.ID
+		d.trackOperation(id)
+		defer d.untrackOperation(id)
+	}
+
+	done := make(chan error, 1)
+	go func() {
+		done <- op.Wait()
+	}()
+
+	var expired <-chan time.Time
+	if timeout > 0 {
+		expired = time.After(timeout)
+	}
+
+	select {
+	case err := <-done:
+		return err
+	case <-expired:
+	}
+
+	if err := op.CancelTarget(); err != nil {
+		log.Debugf("Unable to cancel operation %s: %s", id, err)
+	}
+
+	return fmt.Errorf("operation %s timed out after %s", id, timeout)
+}
+
+// operationError builds an error carrying the failure details the server
+// reported in the operation, or nil when the operation succeeded
+func operationError(op incus.Operation, err error) error {
+	info := op.Get()
+	if err == nil && info.StatusCode != api.Failure {
+		return nil
+	}
+
+	if err == nil {
+		err = errors.New(info.Err)
+		if info.Err == "" {
+			err = errors.New(info.Status)
+		}
+	}
+
+	details := []string{}
+	for key, value := range info.Metadata {
+		if s, ok := value.(string); ok && s != "" {
+			details = append(details, fmt.Sprintf("%s=%s", key, s))
+		}
+	}
+	slices.Sort(details)
+
+	description := info.Description
+	if description == "" {
+		description = "operation"
+	}
+
+	if len(details) == 0 {
+		return fmt.Errorf("%s failed (operation %s): %w", description, info.ID, err)
+	}
+
+	return fmt.Errorf("%s failed (operation %s, %s): %w", description, info.ID, strings.Join(details, ", "), err)
+}
+
+// operationFile keeps the IDs of the operations being waited on in the
+// machine store, one per line, so a new plugin process can reattach when the
+// previous one died
+const operationFile = "incus-operation"
+
+// operationFileMu serializes the updates of the operation file, operations
+// may be waited on concurrently and by copies of the driver
+var operationFileMu sync.Mutex
+
+// trackOperation records an in-flight operation next to the ones already
+// tracked
+func (d *Driver) trackOperation(id string) {
+	d.updateOperations(func(ids []string) []string {
+		return append(ids, id)
+	})
+}
+
+// untrackOperation clears an operation, leaving the other ones tracked
+func (d *Driver) untrackOperation(id string) {
+	d.updateOperations(func(ids []string) []string {
+		return slices.DeleteFunc(ids, func(tracked string) bool {
+			return tracked == id
+		})
+	})
+}
+
+// trackedOperations returns the IDs of the tracked operations
+func (d *Driver) trackedOperations() ([]string, error) {
+	data, err := os.ReadFile(d.ResolveStorePath(operationFile))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return strings.Fields(string(data)), nil
+}
+
+// updateOperations rewrites the tracked operations, removing the file once
+// none is left
+func (d *Driver) updateOperations(update func([]string) []string) {
+	if d.StorePath == "" {
+		return
+	}
+
+	operationFileMu.Lock()
+	defer operationFileMu.Unlock()
+
+	ids, err := d.trackedOperations()
+	if err == nil {
+		ids = update(ids)
+		path := d.ResolveStorePath(operationFile)
+		if len(ids) == 0 {
+			err = os.Remove(path)
+			if os.IsNotExist(err) {
+				err = nil
+			}
+		} else {
+			err = os.WriteFile(path, []byte(strings.Join(ids, "\n")+"\n"), 0600)
+		}
+	}
+
+	if err != nil {
+		log.Debugf("Unable to track operations: %s", err)
+	}
+}
+
+// pendingOperations returns the tracked operations the server still knows
+// about
+func (d *Driver) pendingOperations(client incus.InstanceServer) ([]*api.Operation, error) {
+	if d.StorePath == "" {
+		return nil, nil
+	}
+
+	ids, err := d.trackedOperations()
+	if err != nil {
+		return nil, err
+	}
+
+	ops := []*api.Operation{}
+	for _, id := range ids {
+		op, _, err := client.GetOperation(id)
+		if api.StatusErrorCheck(err, http.StatusNotFound) {
+			d.untrackOperation(id)
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to get operation %s: %w", id, err)
+		}
+
+		ops = append(ops, op)
+	}
+
+	return ops, nil
+}
+
+// resumeOperations waits for the operations left behind by a previous
+// plugin process and reports whether there was any
+func (d *Driver) resumeOperations(client incus.InstanceServer) (bool, error) {
+	ops, err := d.pendingOperations(client)
+	if err != nil || len(ops) == 0 {
+		return false, err
+	}
+
+	for _, op := range ops {
+		id := op.ID
+		if !op.StatusCode.IsFinal() {
+			log.Infof("Reattaching to pending operation %s (%s)", id, op.Description)
+
+			timeout := d.OperationTimeout
+			if timeout <= 0 {
+				timeout = -1
+			}
+
+			op, _, err = client.GetOperationWait(id, timeout)
+			if err != nil {
+				return false, fmt.Errorf("failed to wait for operation %s: %w", id, err)
+			}
+		}
+
+		d.untrackOperation(id)
+		if op.StatusCode == api.Failure {
+			log.Warnf("Pending operation %s failed: %s", id, op.Err)
+		}
+	}
+
+	return true, nil
+}
@@ -0,0 +1,43 @@
+package incus
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+
+	"github.com/docker/machine/libmachine/drivers"
+)
+
+func TestTrackOperation(t *testing.T) {
+	store := t.TempDir()
+	if err := os.MkdirAll(filepath.Join(store, "machines", "machine"), 0700); err != nil {
+		t.Fatal(err)
+	}
+	d := &Driver{BaseDriver: &drivers.BaseDriver{MachineName: "machine", StorePath: store}}
+
+	check := func(want []string) {
+		t.Helper()
+		ids, err := d.trackedOperations()
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if !slices.Equal(ids, want) {
+			t.Errorf("tracked operations = %q, want %q", ids, want)
+		}
+	}
+
+	d.trackOperation("outer")
+	d.trackOperation("inner")
+	check([]string{"outer", "inner"})
+
+	// the inner operation finishing keeps the outer one tracked
+	d.untrackOperation("inner")
+	check([]string{"outer"})
+
+	d.untrackOperation("outer")
+	check(nil)
+	if _, err := os.Stat(d.ResolveStorePath(operationFile)); !os.IsNotExist(err) {
+		t.Errorf("operation file left behind: %v", err)
+	}
+}
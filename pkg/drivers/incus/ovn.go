@@ -0,0 +1,107 @@
+package incus
+
+import (
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/docker/machine/libmachine/log"
+	incus "github.com/lxc/incus/v6/client"
+	"github.com/lxc/incus/v6/shared/api"
+)
+
+// defaultOVNMTU is the mtu Incus uses for OVN networks over a geneve tunnel
+const defaultOVNMTU = 1442
+
+// checkOVNHealth diagnoses a broken OVN setup before create, which would
+// otherwise only show up as a timeout waiting for the instance IP
+func checkOVNHealth(client incus.InstanceServer, network *api.Network) error {
+	if network.Status != "" && network.Status != api.NetworkStatusCreated {
+		return fmt.Errorf("OVN network %s is not ready (status %s)", network.Name, network.Status)
+	}
+
+	if network.Config["network"] == "" {
+		return fmt.Errorf("OVN network %s has no uplink network configured", network.Name)
+	}
+
+	state, err := client.GetNetworkState(network.Name)
+	if err != nil {
+		return fmt.Errorf("failed to get state of OVN network %s: %w", network.Name, err)
+	}
+
+	if state.OVN == nil {
+		log.Debugf("Server does not report OVN state, skipping chassis check for network %s", network.Name)
+		return nil
+	}
+
+	if state.OVN.LogicalRouter == "" {
+		return fmt.Errorf("OVN network %s has no logical router, check the OVN northbound database", network.Name)
+	}
+
+	if state.OVN.Chassis == "" {
+		return fmt.Errorf("OVN network %s has no active chassis, check that ovn-controller is running and the uplink %s is reachable", network.Name, network.Config["network"])
+	}
+
+	return nil
+}
+
+// getOVNMTU returns the mtu the guest must use on an OVN network
+func getOVNMTU(client incus.InstanceServer, network *api.Network) (int, error) {
+	if value := network.Config["bridge.mtu"]; value != "" {
+		mtu, err := strconv.Atoi(value)
+		if err != nil {
+			return 0, fmt.Errorf("invalid bridge.mtu %q on network %s: %w", value, network.Name, err)
+		}
+		return mtu, nil
+	}
+
+	state, err := client.GetNetworkState(network.Name)
+	if err != nil {
+		return 0, fmt.Errorf("failed to get state of OVN network %s: %w", network.Name, err)
+	}
+
+	if state.Mtu > 0 {
+		return state.Mtu, nil
+	}
+
+	return defaultOVNMTU, nil
+}
+
+// checkOVNProjectRestrictions diagnoses project restrictions keeping the
+// instance off the OVN network, which would otherwise only show up as a
+// timeout waiting for the instance IP
+func checkOVNProjectRestrictions(client incus.InstanceServer, projectName string, network *api.Network) error {
+	project, _, err := client.GetProject(projectName)
+	if err != nil {
+		return fmt.Errorf("project %s not found: %w", projectName, err)
+	}
+
+	if project.Config["restricted"] != "true" {
+		return nil
+	}
+
+	if project.Config["restricted.devices.nic"] == "block" {
+		return fmt.Errorf("project %s blocks NIC devices (restricted.devices.nic=block)", projectName)
+	}
+
+	if access := splitList(project.Config["restricted.networks.access"]); len(access) > 0 && !slices.Contains(access, network.Name) {
+		return fmt.Errorf("project %s does not grant access to OVN network %s (restricted.networks.access=%s)", projectName, network.Name, project.Config["restricted.networks.access"])
+	}
+
+	// restricted.networks.uplinks only governs creating OVN networks, the
+	// driver attaches to an existing one
+	return nil
+}
+
+// splitList splits a comma-separated project restriction value
+func splitList(value string) []string {
+	items := []string{}
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+
+	return items
+}
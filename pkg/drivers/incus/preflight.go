@@ -0,0 +1,126 @@
+package incus
+
+import (
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+
+	"github.com/docker/machine/libmachine/log"
+	"github.com/lxc/incus/v6/shared/api"
+	localtls "github.com/lxc/incus/v6/shared/tls"
+)
+
+// checkConnection verifies the client certificate is trusted and allowed to
+// use the project, so authorization problems fail the preflight instead of
+// showing up as a 403 halfway through create
+func (d *Driver) checkConnection() error {
+	if d.trustToken != "" {
+		if err := d.prepareTrustToken(); err != nil {
+			return err
+		}
+	}
+
+	is, err := d.connect()
+	if err != nil {
+		return err
+	}
+
+	server, _, err := is.GetServer()
+	if err != nil {
+		return fmt.Errorf("failed to get server info of %s: %w", d.URL, err)
+	}
+
+	if server.Auth != "trusted" && d.trustToken != "" {
+		if server, err = d.enrollTrustToken(is); err != nil {
+			return err
+		}
+	}
+
+	if server.Auth != "trusted" {
+		return fmt.Errorf("client certificate is not trusted by %s (supported auth methods: %s), add it with `incus config trust add-certificate` or enroll with --incus-trust-token",
+			d.URL, strings.Join(server.AuthMethods, ", "))
+	}
+	d.server = server
+
+	fingerprint := ""
+	if d.TLSClientCert != "" && d.unixSocket() == "" {
+		fingerprint, err = localtls.CertFingerprintStr(d.TLSClientCert)
+		if err != nil {
+			return fmt.Errorf("invalid client certificate: %w", err)
+		}
+	}
+
+	// restricted certificates only see the projects they are bound to
+	if fingerprint != "" {
+		cert, _, err := is.GetCertificate(fingerprint)
+		if err == nil && cert.Restricted && !slices.Contains(cert.Projects, d.Project) {
+			return fmt.Errorf("client certificate is restricted to projects %s and can not use project %s",
+				strings.Join(cert.Projects, ", "), d.Project)
+		}
+
+		if err := d.checkProjectScope(cert, err); err != nil {
+			return err
+		}
+	}
+
+	if _, _, err := is.GetProject(d.Project); err != nil {
+		if api.StatusErrorCheck(err, http.StatusForbidden) {
+			return fmt.Errorf("client certificate has no access to project %s", d.Project)
+		}
+		return fmt.Errorf("project %s not found: %w", d.Project, err)
+	}
+
+	if _, err := is.UseProject(d.Project).GetInstanceNames(api.InstanceTypeAny); err != nil {
+		return fmt.Errorf("client certificate can not list instances of project %s: %w", d.Project, err)
+	}
+
+	role, mode := "unknown", "tls"
+	if fingerprint != "" && is.HasExtension("instance_access") {
+		access, err := is.GetProjectAccess(d.Project)
+		if err != nil {
+			log.Debugf("Unable to get access list of project %s: %s", d.Project, err)
+		}
+		for _, entry := range access {
+			if entry.Identifier == fingerprint {
+				role, mode = entry.Role, entry.Provider
+				break
+			}
+		}
+	}
+
+	log.Infof("Connected to %s as %s via %s (auth mode %s, role %s in project %s)",
+		d.URL, server.AuthUserName, server.AuthUserMethod, mode, role, d.Project)
+	return nil
+}
+
+// checkProjectScope reports client certificates with more rights than the
+// project of the machine needs, refusing them when --incus-project-scoped
+// is set
+func (d *Driver) checkProjectScope(cert *api.Certificate, err error) error {
+	if err != nil {
+		if d.ProjectScoped {
+			return fmt.Errorf("unable to verify the client certificate is restricted to project %s: %w", d.Project, err)
+		}
+		return nil
+	}
+
+	scope := ""
+	switch {
+	case !cert.Restricted:
+		scope = "is not restricted and has full access to the server"
+	case len(cert.Projects) > 1:
+		scope = fmt.Sprintf("also grants access to projects %s", strings.Join(slices.DeleteFunc(slices.Clone(cert.Projects), func(project string) bool {
+			return project == d.Project
+		}), ", "))
+	default:
+		return nil
+	}
+
+	if d.ProjectScoped {
+		return fmt.Errorf("client certificate %s, restrict it to project %s only with `incus config trust edit %s`", scope, d.Project, cert.Fingerprint[:12])
+	}
+
+	log.Warnf("Client certificate %s, only project %s is needed", scope, d.Project)
+	return nil
+}
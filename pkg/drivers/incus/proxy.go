@@ -0,0 +1,128 @@
+package incus
+
+import (
+	"fmt"
+	"maps"
+	"net"
+	"net/url"
+
+	"github.com/docker/machine/libmachine/log"
+	incus "github.com/lxc/incus/v6/client"
+	"github.com/lxc/incus/v6/shared/api"
+)
+
+const dockerPort = 2376
+
+// getProxyAddress returns the Incus host address the docker proxy device
+// listens on, defaulting to the address of the Incus server URL
+func (d *Driver) getProxyAddress() (string, error) {
+	host := d.DockerProxyAddress
+	if host == "" && d.APISSHTunnel != "" {
+		host = d.tunnelHost()
+	}
+	if host == "" && d.unixSocket() != "" {
+		// the Incus server runs on this host
+		host = "127.0.0.1"
+	}
+	if host == "" {
+		u, err := url.Parse(d.URL)
+		if err != nil {
+			return "", fmt.Errorf("failed to parse incus url: %w", err)
+		}
+		host = u.Hostname()
+	}
+
+	if ip := net.ParseIP(host); ip != nil {
+		return ip.String(), nil
+	}
+
+	// nat mode proxy devices can only listen on an ip address
+	ips, err := net.LookupIP(host)
+	if err != nil || len(ips) == 0 {
+		return "", fmt.Errorf("failed to resolve docker proxy address %s: %w", host, err)
+	}
+
+	return ips[0].String(), nil
+}
+
+// updateDockerProxy creates or refreshes the proxy device forwarding the
+// Incus host port to the Docker API of the instance
+func (d *Driver) updateDockerProxy(client incus.InstanceServer) error {
+	if d.DockerProxyPort == 0 {
+		return nil
+	}
+
+	listen, err := d.getProxyAddress()
+	if err != nil {
+		return err
+	}
+	d.DockerProxyAddress = listen
+
+	instance, etag, err := client.GetInstance(d.MachineName)
+	if err != nil {
+		return err
+	}
+
+	// nat mode proxies forward to the static address of the NIC, the DHCP
+	// one may change on the next boot
+	if err := d.pinNICAddress(instance); err != nil {
+		return err
+	}
+
+	name := d.deviceName("docker-proxy")
+	d.recordDevice(name)
+	instance.Devices[name] = map[string]string{
+		"type":    "proxy",
+		"nat":     "true",
+		"listen":  fmt.Sprintf("tcp:%s", net.JoinHostPort(listen, fmt.Sprintf("%d", d.DockerProxyPort))),
+		"connect": fmt.Sprintf("tcp:%s", net.JoinHostPort(d.IPAddress, fmt.Sprintf("%d", dockerPort))),
+	}
+
+	op, err := client.UpdateInstance(d.MachineName, instance.Writable(), etag)
+	if err != nil {
+		return fmt.Errorf("failed to update docker proxy device: %w", d.instancePermissionError(err, "can_edit"))
+	}
+
+	err = d.waitOperation(op)
+	if err != nil {
+		return fmt.Errorf("failed to update docker proxy device: %w", err)
+	}
+	d.recordInstance(instance.Writable())
+
+	log.Infof("Docker API proxied on %s:%d", listen, d.DockerProxyPort)
+	return nil
+}
+
+// pinNICAddress makes the current address of the instance the static
+// address of its NIC, which nat mode proxy devices require, taking the NIC
+// of the profile over into the instance when needed
+func (d *Driver) pinNICAddress(instance *api.Instance) error {
+	nicDevice := d.NICDevice
+	if nicDevice == "" {
+		nicDevice = "eth0"
+	}
+	addressKey := "ipv4.address"
+	if d.IPv6Only {
+		addressKey = "ipv6.address"
+	}
+
+	nic, ok := instance.Devices[nicDevice]
+	if !ok {
+		nic, ok = instance.ExpandedDevices[nicDevice]
+	}
+	// the bridge the driver attaches by its host interface is the managed
+	// one checked at create
+	managedBridge := d.NetworkType == "bridge" && nic["nictype"] == "bridged" && nic["parent"] == d.Network
+	if !ok || (nic["network"] == "" && !managedBridge) {
+		return fmt.Errorf("the docker proxy needs the static address of a NIC on a managed network, %s is not one", nicDevice)
+	}
+	if nic[addressKey] == d.IPAddress {
+		return nil
+	}
+
+	nic = maps.Clone(nic)
+	nic[addressKey] = d.IPAddress
+	instance.Devices[nicDevice] = nic
+
+	return nil
+}
@@ -0,0 +1,66 @@
+package incus
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/docker/machine/libmachine/drivers"
+	"github.com/lxc/incus/v6/shared/api"
+)
+
+func TestPinNICAddress(t *testing.T) {
+	base := &drivers.BaseDriver{MachineName: "machine", IPAddress: "10.0.0.5"}
+	bridge := &Driver{BaseDriver: base, Network: "incusbr0", NetworkType: "bridge", NICDevice: "eth0", NICHwaddr: "00:16:3e:00:00:01"}
+
+	tests := []struct {
+		name    string
+		driver  *Driver
+		devices map[string]map[string]string
+		want    string
+		wantErr string
+	}{
+		{
+			name:    "bridge NIC of the driver",
+			driver:  bridge,
+			devices: map[string]map[string]string{"eth0": bridge.bridgeNIC()},
+			want:    "10.0.0.5",
+		},
+		{
+			name:    "managed network NIC",
+			driver:  &Driver{BaseDriver: base, NICDevice: "eth0"},
+			devices: map[string]map[string]string{"eth0": {"type": "nic", "network": "incusbr0"}},
+			want:    "10.0.0.5",
+		},
+		{
+			name:    "unmanaged bridge",
+			driver:  bridge,
+			devices: map[string]map[string]string{"eth0": {"type": "nic", "nictype": "bridged", "parent": "br0"}},
+			wantErr: "eth0 is not one",
+		},
+		{
+			name:    "missing NIC",
+			driver:  bridge,
+			devices: map[string]map[string]string{},
+			wantErr: "eth0 is not one",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			instance := &api.Instance{InstancePut: api.InstancePut{Devices: tt.devices}}
+			err := tt.driver.pinNICAddress(instance)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("error = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if got := instance.Devices["eth0"]["ipv4.address"]; got != tt.want {
+				t.Errorf("ipv4.address = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,60 @@
+package incus
+
+import (
+	"fmt"
+
+	"github.com/docker/machine/libmachine/log"
+	incus "github.com/lxc/incus/v6/client"
+	"github.com/lxc/incus/v6/shared/api"
+)
+
+const (
+	readyService  = "docker-machine-ready.service"
+	readyUnitPath = "/etc/systemd/system/" + readyService
+	// the guest API marks the instance ready until its next restart, so the
+	// signal is sent on every boot once cloud-init is done
+	readyUnit = `[Unit]
+Description=Signal readiness to Incus
+After=cloud-final.service
+Wants=cloud-final.service
+
+[Service]
+Type=oneshot
+RemainAfterExit=yes
+ExecStart=/usr/bin/curl -sf --unix-socket /dev/incus/sock -X PATCH -H "Content-Type: application/json" -d "{\"state\":\"Ready\"}" http://custom.socket/1.0
+
+[Install]
+WantedBy=cloud-init.target
+`
+)
+
+// waitForReadySignal waits for the guest to mark the instance ready, which
+// GetState reports as running, so libmachine does not give up polling for
+// a running machine while cloud-init still installs packages
+func (d *Driver) waitForReadySignal(client incus.InstanceServer) error {
+	if !d.ReadySignal {
+		return nil
+	}
+
+	log.Infof("Waiting for %s to signal readiness...", d.MachineName)
+	err := retry(d.timeouts().Ready, func() (bool, error) {
+		state, _, err := client.GetInstanceState(d.MachineName)
+		if err != nil {
+			return true, err
+		}
+
+		switch state.StatusCode {
+		case api.Ready:
+			return true, nil
+		case api.Running, api.Starting:
+			return false, nil
+		}
+
+		return true, fmt.Errorf("instance state is %s", state.StatusCode)
+	})
+	if err != nil {
+		return fmt.Errorf("waiting for instance to signal readiness: %w", err)
+	}
+
+	return nil
+}
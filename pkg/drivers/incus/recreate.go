@@ -0,0 +1,251 @@
+package incus
+
+import (
+	"fmt"
+	"maps"
+	"strings"
+
+	"github.com/docker/machine/libmachine/log"
+	incus "github.com/lxc/incus/v6/client"
+	"github.com/lxc/incus/v6/shared/api"
+)
+
+// recreateBackupSuffix names the old instance while its replacement is
+// created
+const recreateBackupSuffix = "-recreate-backup"
+
+// Recreate replaces the instance with a fresh one built from the (possibly
+// newer) image, keeping its name, devices, configuration and address; the
+// old instance is restored when the replacement fails
+func (d *Driver) Recreate() error {
+	if d.ISO != "" {
+		return fmt.Errorf("recreate is not supported for machines installed from an ISO")
+	}
+
+	if d.ImageSource == nil {
+		return fmt.Errorf("image source of %s is unknown, cannot recreate it", d.MachineName)
+	}
+	d.warnDrift()
+
+	client, err := d.getClient()
+	if err != nil {
+		return err
+	}
+
+	// the server may have been upgraded since the machine was created
+	if _, err := d.refreshServer(); err != nil {
+		return err
+	}
+
+	instance, _, err := client.GetInstance(d.MachineName)
+	if err != nil {
+		return err
+	}
+
+	// volatile keys belong to the old instance, except the mac addresses
+	// which keep the DHCP leases
+	put := instance.Writable()
+	put.Config = maps.Clone(put.Config)
+	for key := range put.Config {
+		if strings.HasPrefix(key, "volatile.") && !strings.HasSuffix(key, ".hwaddr") {
+			delete(put.Config, key)
+		}
+	}
+
+	// pin the current address on managed networks
+	nicDevice := d.NICDevice
+	if nicDevice == "" {
+		nicDevice = "eth0"
+	}
+	addressKey := "ipv4.address"
+	if d.IPv6Only {
+		addressKey = "ipv6.address"
+	}
+	if nic, ok := put.Devices[nicDevice]; ok && d.NetworkType != "" && d.IPAddress != "" && nic[addressKey] == "" {
+		nic = maps.Clone(nic)
+		nic[addressKey] = d.IPAddress
+		put.Devices[nicDevice] = nic
+	}
+
+	if err := d.runPreStopHook(client); err != nil {
+		return err
+	}
+
+	if err := d.Kill(); err != nil {
+		return fmt.Errorf("failed to stop instance %s: %w", d.MachineName, err)
+	}
+
+	// the old instance is kept aside until its replacement is ready, so a
+	// failed create or boot rolls back instead of losing the machine
+	backup, handover, err := d.backupInstance(client)
+	if err != nil {
+		return err
+	}
+
+	if err := d.createReplacement(client, instance, put); err != nil {
+		if rollbackErr := d.restoreBackup(client, backup, handover); rollbackErr != nil {
+			return fmt.Errorf("%w, and restoring %s failed: %w", err, backup, rollbackErr)
+		}
+		return err
+	}
+
+	log.Infof("Deleting instance %s replaced by %s...", backup, d.MachineName)
+	op, err := client.DeleteInstance(backup)
+	if err == nil {
+		err = d.waitOperation(op)
+	}
+	if err != nil {
+		log.Warnf("Failed to delete instance %s, remove it manually: %s", backup, err)
+	}
+
+	return nil
+}
+
+// createReplacement creates the instance from the image with the config of
+// the old one, starts it and waits for it to be ready
+func (d *Driver) createReplacement(client incus.InstanceServer, instance *api.Instance, put api.InstancePut) error {
+	req := api.InstancesPost{
+		Name:        d.MachineName,
+		Type:        api.InstanceType(instance.Type),
+		Source:      *d.ImageSource,
+		InstancePut: put,
+	}
+
+	createClient := client
+	if d.Target != "" {
+		createClient = client.UseTarget(d.Target)
+	}
+
+	log.Infof("Recreating instance %s...", d.MachineName)
+	op, err := createClient.CreateInstance(req)
+	if err != nil {
+		return fmt.Errorf("failed to recreate instance %s: %w", d.MachineName, err)
+	}
+
+	err = d.waitOperation(op)
+	if err != nil {
+		return fmt.Errorf("failed to recreate instance %s: %w", d.MachineName, err)
+	}
+
+	if err := d.refreshInstanceInfo(client); err != nil {
+		return err
+	}
+
+	if err := d.startInstance(client); err != nil {
+		return err
+	}
+
+	return d.waitForReady(client)
+}
+
+// backupInstance renames the stopped instance out of the way of its
+// replacement and hands its custom volumes and pinned addresses over, which
+// can not be used by two instances; the devices handed over are returned
+// for the rollback
+func (d *Driver) backupInstance(client incus.InstanceServer) (string, map[string]map[string]string, error) {
+	backup := d.MachineName + recreateBackupSuffix
+	if _, _, err := client.GetInstance(backup); err == nil {
+		return "", nil, fmt.Errorf("instance %s left by a previous recreate exists, remove or rename it first", backup)
+	}
+
+	log.Infof("Renaming instance %s to %s for recreation...", d.MachineName, backup)
+	op, err := client.RenameInstance(d.MachineName, api.InstancePost{Name: backup})
+	if err != nil {
+		return "", nil, d.instancePermissionError(err, "can_edit")
+	}
+	if err := d.waitOperation(op); err != nil {
+		return "", nil, fmt.Errorf("failed to rename instance %s: %w", d.MachineName, err)
+	}
+
+	instance, etag, err := client.GetInstance(backup)
+	if err != nil {
+		return backup, nil, err
+	}
+
+	handover := map[string]map[string]string{}
+	put := instance.Writable()
+	for name, device := range put.Devices {
+		volume := device["type"] == "disk" && device["pool"] != "" && device["source"] != ""
+		address := device["type"] == "nic" && (device["ipv4.address"] != "" || device["ipv6.address"] != "")
+		if volume || address {
+			handover[name] = device
+			delete(put.Devices, name)
+		}
+	}
+	if len(handover) == 0 {
+		return backup, handover, nil
+	}
+
+	op, err = client.UpdateInstance(backup, put, etag)
+	if err == nil {
+		err = d.waitOperation(op)
+	}
+	if err != nil {
+		// the old instance is never left under another name
+		if restoreErr := d.renameBackup(client, backup); restoreErr != nil {
+			return "", nil, fmt.Errorf("failed to detach devices of %s: %w, and renaming it back failed: %w", backup, err, restoreErr)
+		}
+		return "", nil, fmt.Errorf("failed to detach devices of %s: %w", backup, err)
+	}
+
+	return backup, handover, nil
+}
+
+// restoreBackup deletes a partially created replacement, gives the devices
+// handed over back to the old instance and starts it under its name again
+func (d *Driver) restoreBackup(client incus.InstanceServer, backup string, handover map[string]map[string]string) error {
+	log.Warnf("Recreate of %s failed, restoring %s...", d.MachineName, backup)
+
+	if _, _, err := client.GetInstance(d.MachineName); err == nil {
+		if err := d.forceStop(-1); err != nil {
+			return err
+		}
+		op, err := client.DeleteInstance(d.MachineName)
+		if err == nil {
+			err = d.waitOperation(op)
+		}
+		if err != nil {
+			return fmt.Errorf("failed to delete replacement %s: %w", d.MachineName, err)
+		}
+	}
+
+	if len(handover) > 0 {
+		instance, etag, err := client.GetInstance(backup)
+		if err != nil {
+			return err
+		}
+
+		put := instance.Writable()
+		maps.Copy(put.Devices, handover)
+		op, err := client.UpdateInstance(backup, put, etag)
+		if err == nil {
+			err = d.waitOperation(op)
+		}
+		if err != nil {
+			return fmt.Errorf("failed to attach devices to %s: %w", backup, err)
+		}
+	}
+
+	if err := d.renameBackup(client, backup); err != nil {
+		return err
+	}
+
+	if err := d.refreshInstanceInfo(client); err != nil {
+		return err
+	}
+
+	return d.startInstance(client)
+}
+
+// renameBackup gives the old instance its name back
+func (d *Driver) renameBackup(client incus.InstanceServer, backup string) error {
+	op, err := client.RenameInstance(backup, api.InstancePost{Name: d.MachineName})
+	if err == nil {
+		err = d.waitOperation(op)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to rename %s back to %s: %w", backup, d.MachineName, err)
+	}
+
+	return nil
+}
@@ -0,0 +1,56 @@
+package incus
+
+import (
+	"fmt"
+	"slices"
+	"strconv"
+
+	"github.com/lxc/incus/v6/shared/api"
+)
+
+// lvmStorageDrivers are the pool drivers backed by LVM logical volumes
+var lvmStorageDrivers = []string{"lvm", "lvmcluster"}
+
+// hasRootVolumeTuning reports whether a pool driver specific option of the
+// root volume is set
+func (d *Driver) hasRootVolumeTuning() bool {
+	return d.ZFSBlockMode || d.BtrfsNoQuota || d.LVMStripes > 0
+}
+
+// setRootVolumeTuning applies the pool driver specific options to the root
+// disk, refusing the ones the driver of its pool does not know
+func (d *Driver) setRootVolumeTuning(device map[string]string, driver string) error {
+	container := d.instanceType() == api.InstanceTypeContainer
+
+	if d.ZFSBlockMode {
+		if driver != "zfs" {
+			return fmt.Errorf("--incus-zfs-block-mode needs a zfs storage, %s is %s", d.Storage, driver)
+		}
+		// virtual machine disks are always zvols
+		if !container {
+			return fmt.Errorf("--incus-zfs-block-mode only applies to container root volumes")
+		}
+		device["initial.zfs.block_mode"] = "true"
+	}
+
+	if d.BtrfsNoQuota {
+		if driver != "btrfs" {
+			return fmt.Errorf("--incus-btrfs-no-quota needs a btrfs storage, %s is %s", d.Storage, driver)
+		}
+		// the subvolume of a container is only limited by its qgroup, the
+		// disk image of a virtual machine needs its size
+		if !container {
+			return fmt.Errorf("--incus-btrfs-no-quota only applies to container root volumes")
+		}
+		delete(device, "size")
+	}
+
+	if d.LVMStripes > 0 {
+		if !slices.Contains(lvmStorageDrivers, driver) {
+			return fmt.Errorf("--incus-lvm-stripes needs an lvm or lvmcluster storage, %s is %s", d.Storage, driver)
+		}
+		device["initial.lvm.stripes"] = strconv.Itoa(d.LVMStripes)
+	}
+
+	return nil
+}
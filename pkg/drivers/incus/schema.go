@@ -0,0 +1,161 @@
+package incus
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/docker/machine/libmachine/mcnflag"
+)
+
+// SchemaField describes a create flag the way Rancher node driver schemas do
+type SchemaField struct {
+	Type        string      `json:"type"`
+	Default     SchemaValue `json:"default"`
+	Description string      `json:"description"`
+	Group       string      `json:"group"`
+	EnvVar      string      `json:"envVar,omitempty"`
+	Create      bool        `json:"create"`
+	Update      bool        `json:"update"`
+}
+
+// SchemaValue holds the typed default value of a schema field
+type SchemaValue struct {
+	StringValue string `json:"stringValue,omitempty"`
+	IntValue    int    `json:"intValue,omitempty"`
+	BoolValue   bool   `json:"boolValue,omitempty"`
+}
+
+// Schema is the node driver schema generated from the create flags
+type Schema struct {
+	ResourceFields map[string]SchemaField `json:"resourceFields"`
+}
+
+// flagGroups maps flag name prefixes to the UI group they are shown in, the
+// first matching prefix wins
+var flagGroups = []struct {
+	prefix string
+	group  string
+}{
+	{"incus-url-address", "network"},
+	{"incus-url", "connection"},
+	{"incus-tls", "connection"},
+	{"incus-insecure", "connection"},
+	{"incus-trust-token", "connection"},
+	{"incus-api-ssh", "connection"},
+	{"incus-project", "connection"},
+	{"incus-instance-type", "resources"},
+	{"incus-cpu", "resources"},
+	{"incus-memory", "resources"},
+	{"incus-disk", "resources"},
+	{"incus-data-disk", "storage"},
+	{"incus-storage", "storage"},
+	{"incus-root", "storage"},
+	{"incus-no-root-device", "storage"},
+	{"incus-zfs", "storage"},
+	{"incus-btrfs", "storage"},
+	{"incus-lvm", "storage"},
+	{"incus-network", "network"},
+	{"incus-no-nic", "network"},
+	{"incus-nic", "network"},
+	{"incus-management-network", "network"},
+	{"incus-docker-proxy", "network"},
+	{"incus-open-ports", "network"},
+	{"incus-lb", "network"},
+	{"incus-dns", "network"},
+	{"incus-ssh", "ssh"},
+	{"incus-cloudinit", "cloud-init"},
+	{"incus-prepull", "cloud-init"},
+	{"incus-engine", "cloud-init"},
+	{"incus-hostname", "cloud-init"},
+	{"incus-meta-data", "cloud-init"},
+	{"incus-write-file", "cloud-init"},
+	{"incus-trusted-ca", "cloud-init"},
+	{"incus-machine-cluster", "lifecycle"},
+	{"incus-target", "placement"},
+	{"incus-require", "placement"},
+	{"incus-max", "placement"},
+	{"incus-architecture", "placement"},
+	{"incus-firmware", "resources"},
+	{"incus-boot-priority", "storage"},
+	{"incus-no-start", "lifecycle"},
+	{"incus-stop", "lifecycle"},
+	{"incus-remove", "lifecycle"},
+	{"incus-stateful", "lifecycle"},
+	{"incus-snapshot", "lifecycle"},
+	{"incus-restore-evacuated", "lifecycle"},
+	{"incus-ready-signal", "lifecycle"},
+	{"incus-timeout", "lifecycle"},
+	{"incus-console-log", "lifecycle"},
+	{"incus-pre-stop", "lifecycle"},
+}
+
+// GenerateSchema renders the create flags as a Rancher node driver schema
+func GenerateSchema() ([]byte, error) {
+	schema := Schema{
+		ResourceFields: map[string]SchemaField{},
+	}
+
+	for _, flag := range NewDriver("", "").GetCreateFlags() {
+		field := SchemaField{
+			Group:  flagGroup(flag.String()),
+			Create: true,
+		}
+
+		switch f := flag.(type) {
+		case mcnflag.StringFlag:
+			field.Type = "string"
+			field.Default.StringValue = f.Value
+			field.Description = f.Usage
+			field.EnvVar = f.EnvVar
+		case mcnflag.IntFlag:
+			field.Type = "int"
+			field.Default.IntValue = f.Value
+			field.Description = f.Usage
+			field.EnvVar = f.EnvVar
+		case mcnflag.BoolFlag:
+			field.Type = "boolean"
+			field.Description = f.Usage
+			field.EnvVar = f.EnvVar
+		case mcnflag.StringSliceFlag:
+			field.Type = "array[string]"
+			field.Description = f.Usage
+			field.EnvVar = f.EnvVar
+		default:
+			return nil, fmt.Errorf("unsupported flag type %T for %s", flag, flag)
+		}
+
+		schema.ResourceFields[schemaFieldName(flag.String())] = field
+	}
+
+	return json.MarshalIndent(schema, "", "  ")
+}
+
+// schemaFieldName converts a flag name to the camel case field name Rancher
+// uses, ex: incus-cpu-count becomes cpuCount
+func schemaFieldName(name string) string {
+	parts := strings.Split(strings.TrimPrefix(name, driverName+"-"), "-")
+	for i := 1; i < len(parts); i++ {
+		if parts[i] != "" {
+			parts[i] = strings.ToUpper(parts[i][:1]) + parts[i][1:]
+		}
+	}
+
+	return strings.Join(parts, "")
+}
+
+func flagGroup(name string) string {
+	for _, g := range flagGroups {
+		if strings.HasPrefix(name, g.prefix) {
+			return g.group
+		}
+	}
+
+	// the flags turning off a site default are shown with the flag they
+	// turn off
+	if rest, ok := strings.CutPrefix(name, driverName+"-no-"); ok {
+		return flagGroup(driverName + "-" + rest)
+	}
+
+	return "general"
+}
@@ -0,0 +1,45 @@
+package incus
+
+import (
+	"fmt"
+
+	"github.com/lxc/incus/v6/shared/api"
+)
+
+// getServer returns the server info, only querying the server the first
+// time it is needed
+func (d *Driver) getServer() (*api.Server, error) {
+	if d.server != nil {
+		return d.server, nil
+	}
+
+	client, err := d.getClient()
+	if err != nil {
+		return nil, err
+	}
+
+	server, _, err := client.GetServer()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get server info: %w", err)
+	}
+
+	d.server = server
+	return server, nil
+}
+
+// refreshServer drops the cached server info and queries it again
+func (d *Driver) refreshServer() (*api.Server, error) {
+	d.server = nil
+	return d.getServer()
+}
+
+// hasExtension reports whether the server supports the API extension, from
+// the server info the client fetched when connecting
+func (d *Driver) hasExtension(extension string) (bool, error) {
+	client, err := d.getClient()
+	if err != nil {
+		return false, err
+	}
+
+	return client.HasExtension(extension), nil
+}
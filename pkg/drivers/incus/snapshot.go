@@ -0,0 +1,118 @@
+package incus
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/docker/machine/libmachine/log"
+	incus "github.com/lxc/incus/v6/client"
+	"github.com/lxc/incus/v6/shared/api"
+)
+
+const (
+	defaultSnapshotRetention = 3
+	snapshotPrefix           = "docker-machine-"
+)
+
+// takeSnapshot snapshots the instance before the given operation and prunes
+// the snapshots of older operations beyond the retention count, returning
+// an empty name when snapshots are disabled
+func (d *Driver) takeSnapshot(client incus.InstanceServer, operation string) (string, error) {
+	if d.SnapshotRetention <= 0 {
+		return "", nil
+	}
+
+	name := fmt.Sprintf("%s%s-%s", snapshotPrefix, operation, time.Now().UTC().Format("20060102-150405"))
+	log.Infof("Snapshotting %s as %s...", d.MachineName, name)
+	op, err := client.CreateInstanceSnapshot(d.MachineName, api.InstanceSnapshotsPost{Name: name})
+	if err != nil {
+		return "", fmt.Errorf("failed to snapshot instance %s: %w", d.MachineName, d.instancePermissionError(err, "can_manage_snapshots"))
+	}
+	if err := d.waitOperation(op); err != nil {
+		return "", fmt.Errorf("failed to snapshot instance %s: %w", d.MachineName, err)
+	}
+
+	if err := d.pruneSnapshots(client); err != nil {
+		log.Warnf("Failed to prune the snapshots of %s: %s", d.MachineName, err)
+	}
+
+	return name, nil
+}
+
+// pruneSnapshots deletes the oldest snapshots taken by the driver, other
+// snapshots are left alone
+func (d *Driver) pruneSnapshots(client incus.InstanceServer) error {
+	snapshots, err := client.GetInstanceSnapshots(d.MachineName)
+	if err != nil {
+		return err
+	}
+
+	snapshots = slices.DeleteFunc(snapshots, func(snapshot api.InstanceSnapshot) bool {
+		return !strings.HasPrefix(snapshotName(snapshot), snapshotPrefix)
+	})
+	slices.SortFunc(snapshots, func(a, b api.InstanceSnapshot) int {
+		return a.CreatedAt.Compare(b.CreatedAt)
+	})
+
+	for len(snapshots) > d.SnapshotRetention {
+		name := snapshotName(snapshots[0])
+		snapshots = snapshots[1:]
+
+		log.Debugf("Deleting snapshot %s of %s", name, d.MachineName)
+		op, err := client.DeleteInstanceSnapshot(d.MachineName, name)
+		if err != nil {
+			return err
+		}
+		if err := d.waitOperation(op); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// restoreSnapshot rolls the instance back to the snapshot, records its
+// config as the applied one and starts it again
+func (d *Driver) restoreSnapshot(client incus.InstanceServer, name string) error {
+	log.Warnf("Restoring %s from snapshot %s...", d.MachineName, name)
+	if err := d.Kill(); err != nil {
+		return err
+	}
+
+	instance, etag, err := client.GetInstance(d.MachineName)
+	if err != nil {
+		return err
+	}
+
+	put := instance.Writable()
+	put.Restore = name
+	op, err := client.UpdateInstance(d.MachineName, put, etag)
+	if err != nil {
+		return d.instancePermissionError(err, "can_manage_snapshots")
+	}
+	if err := d.waitOperation(op); err != nil {
+		return err
+	}
+
+	// the rollback also reverts the config and devices to the snapshot ones,
+	// record them so the restore is not reported as drift
+	instance, _, err = client.GetInstance(d.MachineName)
+	if err != nil {
+		return err
+	}
+	d.recordInstance(instance.Writable())
+
+	return d.startInstance(client)
+}
+
+// snapshotName returns the name of the snapshot without the instance name
+func snapshotName(snapshot api.InstanceSnapshot) string {
+	_, name, _ := strings.Cut(snapshot.Name, "/")
+	if name == "" {
+		return snapshot.Name
+	}
+
+	return name
+}
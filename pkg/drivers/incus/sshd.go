@@ -0,0 +1,105 @@
+package incus
+
+import (
+	"fmt"
+	"regexp"
+	"slices"
+	"strings"
+)
+
+const sshdConfigPath = "/etc/ssh/sshd_config.d/10-docker-machine.conf"
+
+var sshAlgorithmRegexp = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9@._-]*$`)
+
+// algorithms the native Go SSH client negotiates, at least one of each
+// configured list must be part of them or provisioning can not connect
+var (
+	clientCiphers = []string{
+		"aes128-ctr", "aes192-ctr", "aes256-ctr",
+		"aes128-gcm@openssh.com", "aes256-gcm@openssh.com",
+		"chacha20-poly1305@openssh.com",
+	}
+	clientMACs = []string{
+		"hmac-sha2-256-etm@openssh.com", "hmac-sha2-512-etm@openssh.com",
+		"hmac-sha2-256", "hmac-sha2-512", "hmac-sha1", "hmac-sha1-96",
+	}
+	clientKexAlgorithms = []string{
+		"curve25519-sha256", "curve25519-sha256@libssh.org",
+		"ecdh-sha2-nistp256", "ecdh-sha2-nistp384", "ecdh-sha2-nistp521",
+		"diffie-hellman-group14-sha256", "diffie-hellman-group16-sha512",
+		"diffie-hellman-group14-sha1", "diffie-hellman-group-exchange-sha256",
+	}
+)
+
+// hasSSHHardening reports whether an sshd drop-in needs to be rendered
+func (d *Driver) hasSSHHardening() bool {
+	return len(d.SSHCiphers) > 0 || len(d.SSHMACs) > 0 || len(d.SSHKexAlgorithms) > 0 || d.SSHNoPasswordAuth
+}
+
+// getSSHDConfig renders the sshd drop-in restricting the negotiated
+// algorithms of the guest
+func (d *Driver) getSSHDConfig() string {
+	lines := []string{}
+	if len(d.SSHCiphers) > 0 {
+		lines = append(lines, "Ciphers "+strings.Join(d.SSHCiphers, ","))
+	}
+	if len(d.SSHMACs) > 0 {
+		lines = append(lines, "MACs "+strings.Join(d.SSHMACs, ","))
+	}
+	if len(d.SSHKexAlgorithms) > 0 {
+		lines = append(lines, "KexAlgorithms "+strings.Join(d.SSHKexAlgorithms, ","))
+	}
+	if d.SSHNoPasswordAuth {
+		lines = append(lines,
+			"PasswordAuthentication no",
+			"KbdInteractiveAuthentication no",
+			"PermitRootLogin prohibit-password",
+		)
+	}
+
+	return strings.Join(lines, "\n") + "\n"
+}
+
+// parseSSHAlgorithms parses a comma-separated algorithm list and makes sure
+// the driver is still able to connect with one of them
+func parseSSHAlgorithms(kind, value string, supported []string) ([]string, error) {
+	algorithms := []string{}
+	compatible := false
+	for _, algorithm := range strings.Split(value, ",") {
+		algorithm = strings.TrimSpace(algorithm)
+		if algorithm == "" {
+			continue
+		}
+		if !sshAlgorithmRegexp.MatchString(algorithm) {
+			return nil, fmt.Errorf("invalid SSH %s %q", kind, algorithm)
+		}
+		if slices.Contains(supported, algorithm) {
+			compatible = true
+		}
+		algorithms = append(algorithms, algorithm)
+	}
+
+	if len(algorithms) > 0 && !compatible {
+		return nil, fmt.Errorf("none of the SSH %ss is supported by docker-machine, allow one of: %s",
+			kind, strings.Join(supported, ","))
+	}
+
+	return algorithms, nil
+}
+
+// clientAlgorithms returns the configured algorithms usable by the native
+// client, or nil to keep its defaults
+func clientAlgorithms(configured, supported []string) []string {
+	if len(configured) == 0 {
+		return nil
+	}
+
+	algorithms := []string{}
+	for _, algorithm := range configured {
+		if slices.Contains(supported, algorithm) {
+			algorithms = append(algorithms, algorithm)
+		}
+	}
+
+	return algorithms
+}
@@ -0,0 +1,193 @@
+package incus
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/docker/machine/libmachine/log"
+	mcnssh "github.com/docker/machine/libmachine/ssh"
+	incus "github.com/lxc/incus/v6/client"
+)
+
+// authorizedKeyScript adds ($2) or removes ($3) a key of the authorized_keys
+// of the user ($1), matching keys by type and blob only
+const authorizedKeyScript = `set -e
+home=$(getent passwd "$1" | cut -d: -f6)
+keys="$home/.ssh/authorized_keys"
+if [ -n "$2" ]; then
+  mkdir -p "$home/.ssh"
+  chmod 700 "$home/.ssh"
+  grep -qF "$2" "$keys" 2>/dev/null || echo "$2" >>"$keys"
+  chmod 600 "$keys"
+  chown -R "$1" "$home/.ssh"
+fi
+if [ -n "$3" ] && [ -f "$keys" ]; then
+  grep -vF "$3" "$keys" >"$keys.tmp" || true
+  cat "$keys.tmp" >"$keys"
+  rm -f "$keys.tmp"
+fi
+`
+
+// RotateSSHKey replaces the SSH key of the machine: the new key is pushed
+// through the Incus agent and must allow to log in, then replaces the old
+// one in the machine store before the old one is removed from the guest
+func (d *Driver) RotateSSHKey() error {
+	client, err := d.getClient()
+	if err != nil {
+		return err
+	}
+
+	if err := d.waitForAgent(client); err != nil {
+		return err
+	}
+
+	oldKey, err := os.ReadFile(d.publicSSHKeyPath())
+	if err != nil {
+		return fmt.Errorf("failed to read the current SSH key: %w", err)
+	}
+
+	newPath := d.GetSSHKeyPath() + ".new"
+	log.Infof("Generating new SSH key on %s...", newPath)
+	if err := mcnssh.GenerateSSHKey(newPath); err != nil {
+		return err
+	}
+	newKey, err := os.ReadFile(newPath + ".pub")
+	if err != nil {
+		return err
+	}
+
+	log.Infof("Authorizing the new SSH key on %s...", d.MachineName)
+	if err := d.updateAuthorizedKeys(client, string(newKey), ""); err != nil {
+		d.removeKeyFiles(newPath)
+		return fmt.Errorf("failed to authorize the new SSH key: %w", err)
+	}
+
+	if err := d.checkSSHLogin(newPath); err != nil {
+		return d.abortRotation(client, newPath, string(newKey), fmt.Errorf("login with the new SSH key failed, keeping the current one: %w", err))
+	}
+
+	// the store holds the new key before the guest stops accepting the old
+	// one, which stays as backup until then
+	backupPath := d.GetSSHKeyPath() + ".old"
+	if err := renameKeyFiles(d.GetSSHKeyPath(), backupPath); err != nil {
+		return d.abortRotation(client, newPath, string(newKey), fmt.Errorf("failed to back up the current SSH key: %w", err))
+	}
+	if err := renameKeyFiles(newPath, d.GetSSHKeyPath()); err != nil {
+		d.restoreKeyFiles(backupPath)
+		return d.abortRotation(client, newPath, string(newKey), fmt.Errorf("failed to store the new SSH key: %w", err))
+	}
+
+	// recreated instances get the key from the vendor-data
+	d.sshPublicKey = string(newKey)
+	if err := d.updateVendorData(client); err != nil {
+		return fmt.Errorf("the new SSH key is in use but updating the vendor-data failed, the old one kept in %s is still accepted: %w", backupPath, err)
+	}
+
+	log.Infof("Removing the old SSH key from %s...", d.MachineName)
+	if err := d.updateAuthorizedKeys(client, "", string(oldKey)); err != nil {
+		// the new key logs in whether or not the old one is still accepted
+		return fmt.Errorf("the new SSH key is in use but removing the old one, kept in %s, failed: %w", backupPath, err)
+	}
+	d.removeKeyFiles(backupPath)
+
+	return nil
+}
+
+// updateAuthorizedKeys adds and removes keys, given in authorized_keys
+// format, for the SSH user of the guest
+func (d *Driver) updateAuthorizedKeys(client incus.InstanceServer, add, remove string) error {
+	_, err := d.exec(client, "sh", "-c", authorizedKeyScript, "sh", d.GetSSHUsername(), keyBlob(add), keyBlob(remove))
+	return err
+}
+
+// checkSSHLogin logs in to the guest with the private key
+func (d *Driver) checkSSHLogin(keyPath string) error {
+	conn, err := d.dialSSH(keyPath)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	session, err := conn.NewSession()
+	if err != nil {
+		return err
+	}
+	defer session.Close()
+
+	return session.Run("true")
+}
+
+// updateVendorData renders the vendor-data again into the instance config
+func (d *Driver) updateVendorData(client incus.InstanceServer) error {
+	vendorData, err := d.getVendorData()
+	if err != nil {
+		return err
+	}
+
+	instance, etag, err := client.GetInstance(d.MachineName)
+	if err != nil {
+		return err
+	}
+
+	put := instance.Writable()
+	put.Config[d.cloudInitKey("vendor-data")] = vendorData
+	op, err := client.UpdateInstance(d.MachineName, put, etag)
+	if err != nil {
+		return d.instancePermissionError(err, "can_edit")
+	}
+	if err := d.waitOperation(op); err != nil {
+		return err
+	}
+
+	d.recordInstance(put)
+	return nil
+}
+
+// abortRotation removes the new key from the guest and the store, the
+// current key staying in use
+func (d *Driver) abortRotation(client incus.InstanceServer, newPath, newKey string, err error) error {
+	if cleanupErr := d.updateAuthorizedKeys(client, "", newKey); cleanupErr != nil {
+		log.Warnf("Failed to remove the new SSH key from %s: %s", d.MachineName, cleanupErr)
+	}
+	d.removeKeyFiles(newPath)
+
+	return err
+}
+
+// restoreKeyFiles puts the backed up key pair back in place
+func (d *Driver) restoreKeyFiles(backupPath string) {
+	if err := renameKeyFiles(backupPath, d.GetSSHKeyPath()); err != nil {
+		log.Warnf("Failed to restore the SSH key of %s from %s: %s", d.MachineName, backupPath, err)
+	}
+}
+
+// renameKeyFiles renames a key pair, moving the private key back when the
+// public one can not be renamed
+func renameKeyFiles(from, to string) error {
+	if err := os.Rename(from, to); err != nil {
+		return err
+	}
+	if err := os.Rename(from+".pub", to+".pub"); err != nil {
+		_ = os.Rename(to, from)
+		return err
+	}
+
+	return nil
+}
+
+func (d *Driver) removeKeyFiles(path string) {
+	os.Remove(path)
+	os.Remove(path + ".pub")
+}
+
+// keyBlob returns the type and base64 blob of an authorized key, dropping
+// its comment so keys match whatever comment the guest recorded
+func keyBlob(key string) string {
+	fields := strings.Fields(key)
+	if len(fields) < 2 {
+		return ""
+	}
+
+	return fields[0] + " " + fields[1]
+}
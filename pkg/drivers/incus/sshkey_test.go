@@ -0,0 +1,168 @@
+package incus
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/rand"
+	"errors"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+
+	"github.com/docker/machine/libmachine/drivers"
+	mcnssh "github.com/docker/machine/libmachine/ssh"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+)
+
+func TestRenameKeyFiles(t *testing.T) {
+	tests := []struct {
+		name     string
+		files    []string
+		wantErr  bool
+		wantKept []string
+	}{
+		{name: "key pair", files: []string{"id_rsa", "id_rsa.pub"}, wantKept: []string{"moved", "moved.pub"}},
+		{name: "missing public key", files: []string{"id_rsa"}, wantErr: true, wantKept: []string{"id_rsa"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			for _, file := range tt.files {
+				if err := os.WriteFile(filepath.Join(dir, file), []byte(file), 0600); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			err := renameKeyFiles(filepath.Join(dir, "id_rsa"), filepath.Join(dir, "moved"))
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("error = %v, want error %v", err, tt.wantErr)
+			}
+
+			// a failed rename leaves the private key where it was
+			for _, file := range tt.wantKept {
+				if _, err := os.Stat(filepath.Join(dir, file)); err != nil {
+					t.Errorf("%s missing: %v", file, err)
+				}
+			}
+		})
+	}
+}
+
+// startSSHServer serves SSH logins with the authorized key on a local port,
+// running no command but reporting success
+func startSSHServer(t *testing.T, authorized ssh.PublicKey) int {
+	t.Helper()
+
+	_, hostKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	signer, err := ssh.NewSignerFromKey(hostKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	config := &ssh.ServerConfig{
+		PublicKeyCallback: func(_ ssh.ConnMetadata, key ssh.PublicKey) (*ssh.Permissions, error) {
+			if !bytes.Equal(key.Marshal(), authorized.Marshal()) {
+				return nil, os.ErrPermission
+			}
+			return nil, nil
+		},
+	}
+	config.AddHostKey(signer)
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { listener.Close() })
+
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go serveSSH(conn, config)
+		}
+	}()
+
+	return listener.Addr().(*net.TCPAddr).Port
+}
+
+func serveSSH(conn net.Conn, config *ssh.ServerConfig) {
+	_, channels, requests, err := ssh.NewServerConn(conn, config)
+	if err != nil {
+		return
+	}
+	go ssh.DiscardRequests(requests)
+
+	for newChannel := range channels {
+		channel, channelRequests, err := newChannel.Accept()
+		if err != nil {
+			continue
+		}
+		go func() {
+			for req := range channelRequests {
+				_ = req.Reply(req.Type == "exec", nil)
+				if req.Type == "exec" {
+					_, _ = channel.SendRequest("exit-status", false, ssh.Marshal(struct{ Status uint32 }{0}))
+					channel.Close()
+				}
+			}
+		}()
+	}
+}
+
+func TestCheckSSHLoginWithoutKnownHosts(t *testing.T) {
+	store := t.TempDir()
+	if err := os.MkdirAll(filepath.Join(store, "machines", "machine"), 0700); err != nil {
+		t.Fatal(err)
+	}
+	d := &Driver{BaseDriver: &drivers.BaseDriver{MachineName: "machine", StorePath: store, IPAddress: "127.0.0.1"}}
+
+	keyPath := filepath.Join(store, "id_rsa.new")
+	if err := mcnssh.GenerateSSHKey(keyPath); err != nil {
+		t.Fatal(err)
+	}
+	publicKey, err := os.ReadFile(keyPath + ".pub")
+	if err != nil {
+		t.Fatal(err)
+	}
+	authorized, _, _, _, err := ssh.ParseAuthorizedKey(publicKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	d.SSHPort = startSSHServer(t, authorized)
+
+	// machines created before host keys were recorded have no known_hosts
+	if d.hasHostKeys() {
+		t.Fatal("known_hosts unexpectedly present")
+	}
+	if err := d.checkSSHLogin(keyPath); err != nil {
+		t.Fatalf("login without known_hosts: %v", err)
+	}
+
+	// a recorded host key is enforced
+	otherKey, _, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	other, err := ssh.NewPublicKey(otherKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	address := net.JoinHostPort(d.IPAddress, strconv.Itoa(d.SSHPort))
+	if err := os.WriteFile(d.GetSSHKnownHostsPath(), []byte(knownhosts.Line([]string{knownhosts.Normalize(address)}, other)+"\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	var keyErr *knownhosts.KeyError
+	if err := d.checkSSHLogin(keyPath); !errors.As(err, &keyErr) {
+		t.Fatalf("error = %v, want a host key mismatch", err)
+	}
+}
@@ -0,0 +1,81 @@
+package incus
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/docker/machine/libmachine/log"
+)
+
+const sshProbeTimeout = 5 * time.Second
+
+// sshProbePorts returns the ports probed for the SSH server, the configured
+// one first and 22, which sshd listens on until its config is applied
+func (d *Driver) sshProbePorts() []int {
+	port, _ := d.GetSSHPort()
+	// the input firewall only lets the configured port through, a probe of
+	// 22 could never be answered
+	if port == defaultSSHPort || len(d.OpenPorts) > 0 {
+		return []int{port}
+	}
+
+	return []int{port, defaultSSHPort}
+}
+
+// probeSSH reports whether an SSH server answers on the port, reading its
+// version banner so a listening socket alone is not taken for sshd
+func (d *Driver) probeSSH(port int) bool {
+	conn, err := net.DialTimeout("tcp", net.JoinHostPort(d.IPAddress, strconv.Itoa(port)), sshProbeTimeout)
+	if err != nil {
+		return false
+	}
+	defer conn.Close()
+
+	_ = conn.SetReadDeadline(time.Now().Add(sshProbeTimeout))
+	banner, err := bufio.NewReader(conn).ReadString('\n')
+	if err != nil {
+		return false
+	}
+
+	return strings.HasPrefix(banner, "SSH-")
+}
+
+// waitForSSH waits for the SSH server to answer on the configured port,
+// reporting an sshd still on port 22 as its config converges during the
+// first boot
+func (d *Driver) waitForSSH() error {
+	ports := d.sshProbePorts()
+	answered := []int{}
+	waiting := false
+
+	err := retry(d.timeouts().SSH, func() (bool, error) {
+		answered = slices.DeleteFunc(slices.Clone(ports), func(port int) bool { return !d.probeSSH(port) })
+
+		if slices.Contains(answered, ports[0]) {
+			log.Infof("SSH server of %s answering on port %d", d.MachineName, ports[0])
+			return true, nil
+		}
+
+		if len(answered) > 0 && !waiting {
+			log.Infof("SSH server of %s answering on port %d but not yet on port %d, waiting for sshd to apply its config",
+				d.MachineName, answered[0], ports[0])
+			waiting = true
+		}
+
+		return false, nil
+	})
+	if err == nil {
+		return nil
+	}
+
+	if len(answered) > 0 {
+		return fmt.Errorf("SSH server of %s only answers on port %d, check its sshd_config listens on port %d", d.MachineName, answered[0], ports[0])
+	}
+
+	return fmt.Errorf("waiting for SSH server of %s on port %d: %w", d.MachineName, ports[0], err)
+}
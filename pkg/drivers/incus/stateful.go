@@ -0,0 +1,61 @@
+package incus
+
+import (
+	"fmt"
+
+	"github.com/docker/machine/libmachine/log"
+	incus "github.com/lxc/incus/v6/client"
+	"github.com/lxc/incus/v6/shared/api"
+)
+
+// stateOverhead is the room left in the state volume next to the guest RAM
+// for the device state of the virtual machine
+const stateOverhead = 256
+
+// stateSize returns the size in MiB of the volume holding the runtime state
+// of a stateful stop, which has to fit the whole guest memory
+func (d *Driver) stateSize() int {
+	return d.Memory + stateOverhead
+}
+
+// statefulConfig enables the stateful stop and snapshots of the instance
+func (d *Driver) statefulConfig(config map[string]string) error {
+	if !d.Stateful {
+		return nil
+	}
+
+	if d.instanceType() != api.InstanceTypeVM {
+		return fmt.Errorf("stateful operations are only supported for virtual machine instances")
+	}
+	config["migration.stateful"] = "true"
+
+	return nil
+}
+
+// checkPoolSpace verifies the storage pool has room for the root disk and
+// its state volume, pools not reporting their usage are not checked
+func (d *Driver) checkPoolSpace(client incus.InstanceServer, pool string) error {
+	resources, err := client.GetStoragePoolResources(pool)
+	if err != nil {
+		log.Debugf("Unable to get resources of storage %s: %s", pool, err)
+		return nil
+	}
+
+	space := resources.Space
+	if space.Total == 0 {
+		return nil
+	}
+
+	var free uint64
+	if space.Total > space.Used {
+		free = space.Total - space.Used
+	}
+
+	required := uint64(d.DiskSize+d.stateSize()) * 1024 * 1024
+	if required > free {
+		return fmt.Errorf("storage %s has %dMiB free, the root disk and its %dMiB state volume need %dMiB",
+			pool, free/1024/1024, d.stateSize(), required/1024/1024)
+	}
+
+	return nil
+}
@@ -0,0 +1,270 @@
+package incus
+
+import (
+	"errors"
+	"fmt"
+	"maps"
+	"net/http"
+	"slices"
+	"strings"
+
+	"github.com/docker/machine/libmachine/log"
+	incus "github.com/lxc/incus/v6/client"
+	"github.com/lxc/incus/v6/shared/api"
+)
+
+// remoteStorageDrivers are the pool drivers shared between cluster members
+var remoteStorageDrivers = []string{"ceph", "cephfs", "lvmcluster"}
+
+// Volume is a custom storage volume created by the driver for the machine
+type Volume struct {
+	Pool string
+	Name string
+}
+
+func (d *Driver) getStorage() (map[string]string, error) {
+	if d.NoRootDevice {
+		if d.hasRootVolumeTuning() {
+			return nil, fmt.Errorf("--incus-zfs-block-mode, --incus-btrfs-no-quota and --incus-lvm-stripes can not be used with --incus-no-root-device")
+		}
+		return d.getProfileStorage()
+	}
+
+	if d.Storage == "" {
+		return nil, fmt.Errorf("storage is required")
+	}
+
+	client, err := d.getClient()
+	if err != nil {
+		return nil, err
+	}
+
+	// a second disk on / would conflict with the root disk of the profile
+	profileDevices, err := d.getProfileDevices(client)
+	if err != nil {
+		return nil, fmt.Errorf("profile %s not found: %w", d.Profile, err)
+	}
+	d.RootDevice = d.deviceName("root")
+	if name, _ := findRootDevice(profileDevices); name != "" {
+		d.RootDevice = name
+	}
+
+	pool, _, err := client.GetStoragePool(d.Storage)
+	if err != nil {
+		return nil, fmt.Errorf("storage %s not found: %w", d.Storage, err)
+	}
+	d.StorageDriver = pool.Driver
+
+	device := map[string]string{
+		"type": "disk",
+		"path": "/",
+		"pool": d.Storage,
+		"size": fmt.Sprintf("%dMiB", d.DiskSize),
+	}
+	d.setBootPriority(device, "root")
+
+	if err := d.setRootVolumeTuning(device, pool.Driver); err != nil {
+		return nil, err
+	}
+
+	if d.Stateful {
+		if err := d.checkPoolSpace(client, d.Storage); err != nil {
+			return nil, err
+		}
+		device["size.state"] = fmt.Sprintf("%dMiB", d.stateSize())
+	}
+
+	if len(d.StorageVolumeOptions) > 0 {
+		if !slices.Contains(remoteStorageDrivers, pool.Driver) {
+			return nil, fmt.Errorf("storage volume options are not supported on %s storage %s", pool.Driver, d.Storage)
+		}
+
+		// applied when incus creates the root volume
+		for key, value := range d.StorageVolumeOptions {
+			device["initial."+key] = value
+		}
+	}
+
+	return device, nil
+}
+
+// checkStorageTarget verifies the storage pool is usable from the cluster
+// member the instance is created on, as the pool status returned without a
+// target does not reflect the per-member state
+func (d *Driver) checkStorageTarget() error {
+	if d.Storage == "" || d.NoRootDevice {
+		return nil
+	}
+
+	client, err := d.getClient()
+	if err != nil {
+		return err
+	}
+
+	if !client.IsClustered() {
+		return nil
+	}
+
+	if member := d.targetMember(); member != "" {
+		return d.checkMemberPool(client, member)
+	}
+
+	// the scheduler may pick any online member of the target group
+	members, err := d.candidateMembers(client)
+	if err != nil {
+		return err
+	}
+
+	available := []string{}
+	var errs []error
+	for _, member := range members {
+		if err := d.checkMemberPool(client, member.ServerName); err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		available = append(available, member.ServerName)
+	}
+
+	if len(errs) == 0 {
+		return nil
+	}
+	if len(available) == 0 {
+		return fmt.Errorf("storage %s is not usable on any online cluster member: %w", d.Storage, errors.Join(errs...))
+	}
+
+	return fmt.Errorf("%w, set --incus-target to one of: %s", errors.Join(errs...), strings.Join(available, ", "))
+}
+
+// checkMemberPool verifies the storage pool is created on the member
+func (d *Driver) checkMemberPool(client incus.InstanceServer, member string) error {
+	pool, _, err := client.UseTarget(member).GetStoragePool(d.Storage)
+	if err != nil {
+		return fmt.Errorf("storage %s not available on %s: %w", d.Storage, member, err)
+	}
+
+	if pool.Status != api.StoragePoolStatusCreated {
+		return fmt.Errorf("storage %s is %s on %s", d.Storage, pool.Status, member)
+	}
+
+	return nil
+}
+
+// getProfileStorage checks the profile provides the root disk and returns
+// an override of it when the disk size must still be applied
+func (d *Driver) getProfileStorage() (map[string]string, error) {
+	client, err := d.getClient()
+	if err != nil {
+		return nil, err
+	}
+
+	profile, _, err := client.GetProfile(d.Profile)
+	if err != nil {
+		return nil, fmt.Errorf("profile %s not found: %w", d.Profile, err)
+	}
+
+	name, device := findRootDevice(profile.Devices)
+	if device == nil {
+		return nil, fmt.Errorf("profile %s has no root disk device", d.Profile)
+	}
+
+	if !d.RootSizeOverride && !d.Stateful {
+		return nil, nil
+	}
+
+	// override the profile device keeping its pool
+	d.RootDevice = name
+	override := maps.Clone(device)
+	if d.RootSizeOverride {
+		override["size"] = fmt.Sprintf("%dMiB", d.DiskSize)
+	}
+	if d.Stateful {
+		if err := d.checkPoolSpace(client, device["pool"]); err != nil {
+			return nil, err
+		}
+		override["size.state"] = fmt.Sprintf("%dMiB", d.stateSize())
+	}
+	d.setBootPriority(override, "root")
+	return override, nil
+}
+
+// volumeName returns the name of a custom volume dedicated to the machine
+func (d *Driver) volumeName(suffix string) string {
+	return fmt.Sprintf("%s-%s", d.MachineName, suffix)
+}
+
+// createVolume creates a custom volume dedicated to the machine and records
+// it so Remove deletes it along with the instance
+func (d *Driver) createVolume(client incus.InstanceServer, pool string, suffix string, contentType string, config map[string]string) (string, error) {
+	name := d.volumeName(suffix)
+
+	req := api.StorageVolumesPost{
+		Name:        name,
+		Type:        "custom",
+		ContentType: contentType,
+		StorageVolumePut: api.StorageVolumePut{
+			Description: fmt.Sprintf("Created by Rancher Machine for %s", d.MachineName),
+			Config:      config,
+		},
+	}
+
+	if err := client.CreateStoragePoolVolume(pool, req); err != nil {
+		return "", fmt.Errorf("failed to create volume %s on storage %s: %w", name, pool, d.projectPermissionError(err, "can_create_storage_volumes"))
+	}
+
+	d.Volumes = append(d.Volumes, Volume{Pool: pool, Name: name})
+	return name, nil
+}
+
+// removeVolumes deletes exactly the custom volumes the driver created
+func (d *Driver) removeVolumes(client incus.InstanceServer) error {
+	remaining := []Volume{}
+	var errs []error
+	for _, volume := range d.Volumes {
+		err := client.DeleteStoragePoolVolume(volume.Pool, "custom", volume.Name)
+		if err != nil && !api.StatusErrorCheck(err, http.StatusNotFound) {
+			errs = append(errs, fmt.Errorf("failed to delete volume %s on storage %s: %w", volume.Name, volume.Pool, err))
+			remaining = append(remaining, volume)
+			continue
+		}
+
+		log.Debugf("Volume %s on storage %s deleted", volume.Name, volume.Pool)
+	}
+
+	d.Volumes = remaining
+	return errors.Join(errs...)
+}
+
+// getDataDisk creates the data volume and returns its device
+func (d *Driver) getDataDisk(client incus.InstanceServer) (map[string]string, error) {
+	config := map[string]string{
+		"size": fmt.Sprintf("%dMiB", d.DataDiskSize),
+	}
+
+	if d.instanceType() == api.InstanceTypeContainer {
+		name, err := d.createVolume(client, d.Storage, "data", "filesystem", config)
+		if err != nil {
+			return nil, err
+		}
+
+		return map[string]string{
+			"type":   "disk",
+			"pool":   d.Storage,
+			"source": name,
+			"path":   containerDataPath,
+		}, nil
+	}
+
+	name, err := d.createVolume(client, d.Storage, "data", "block", config)
+	if err != nil {
+		return nil, err
+	}
+
+	device := map[string]string{
+		"type":   "disk",
+		"pool":   d.Storage,
+		"source": name,
+	}
+	d.setBootPriority(device, "data")
+
+	return device, nil
+}
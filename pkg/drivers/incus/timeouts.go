@@ -0,0 +1,148 @@
+package incus
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/docker/machine/libmachine/drivers"
+	"github.com/docker/machine/libmachine/log"
+	"github.com/docker/machine/libmachine/mcnflag"
+	incus "github.com/lxc/incus/v6/client"
+)
+
+// pollInterval is the delay between two checks while waiting on the guest
+const pollInterval = 5 * time.Second
+
+// Timeouts bounds each phase of bringing up a machine
+type Timeouts struct {
+	Connect       time.Duration
+	Preflight     time.Duration
+	ImageDownload time.Duration
+	Boot          time.Duration
+	Agent         time.Duration
+	CloudInit     time.Duration
+	Ready         time.Duration
+	SSH           time.Duration
+	ISOInstall    time.Duration
+}
+
+// timeoutPhase describes the flag of one phase and where its value lives
+type timeoutPhase struct {
+	name   string
+	usage  string
+	value  time.Duration
+	target func(t *Timeouts) *time.Duration
+}
+
+var timeoutPhases = []timeoutPhase{
+	{"connect", "connecting to the Incus server", 30 * time.Second, func(t *Timeouts) *time.Duration { return &t.Connect }},
+	{"preflight", "the pre-create checks", 5 * time.Minute, func(t *Timeouts) *time.Duration { return &t.Preflight }},
+	{"image-download", "the instance creation, including the image download", 30 * time.Minute, func(t *Timeouts) *time.Duration { return &t.ImageDownload }},
+	{"boot", "the instance to get an IP address", 500 * time.Second, func(t *Timeouts) *time.Duration { return &t.Boot }},
+	{"agent", "the Incus agent and Docker to answer", 5 * time.Minute, func(t *Timeouts) *time.Duration { return &t.Agent }},
+	{"cloud-init", "cloud-init to finish before provisioning, 0 does not wait", 0, func(t *Timeouts) *time.Duration { return &t.CloudInit }},
+	{"ready", "the guest to signal readiness with --incus-ready-signal", 30 * time.Minute, func(t *Timeouts) *time.Duration { return &t.Ready }},
+	{"ssh", "the SSH server to answer and present its host key", 5 * time.Minute, func(t *Timeouts) *time.Duration { return &t.SSH }},
+	{"iso-install", "the ISO installer to power off the instance", time.Hour, func(t *Timeouts) *time.Duration { return &t.ISOInstall }},
+}
+
+// timeoutFlags returns one duration flag per phase
+func timeoutFlags() []mcnflag.Flag {
+	flags := []mcnflag.Flag{}
+	for _, phase := range timeoutPhases {
+		flags = append(flags, mcnflag.StringFlag{
+			EnvVar: "INCUS_TIMEOUT_" + strings.ToUpper(strings.ReplaceAll(phase.name, "-", "_")),
+			Name:   "incus-timeout-" + phase.name,
+			Usage:  fmt.Sprintf("Time to wait for %s, %s by default (ex: 90s, 10m)", phase.usage, phase.value),
+			Value:  phase.value.String(),
+		})
+	}
+
+	return flags
+}
+
+func parseTimeouts(flags drivers.DriverOptions) (Timeouts, error) {
+	timeouts := Timeouts{}
+	for _, phase := range timeoutPhases {
+		value := flags.String("incus-timeout-" + phase.name)
+		if value == "" {
+			continue
+		}
+
+		// zero only means something for the phases not waited on by default,
+		// the others would silently fall back to their default
+		timeout, err := time.ParseDuration(value)
+		if err != nil || timeout < 0 || (timeout == 0 && phase.value != 0) {
+			return timeouts, fmt.Errorf("invalid --incus-timeout-%s %q, expected a positive duration", phase.name, value)
+		}
+		*phase.target(&timeouts) = timeout
+	}
+
+	return timeouts, nil
+}
+
+// timeouts returns the configured timeouts, using the defaults for phases
+// machines created by older drivers have no value for
+func (d *Driver) timeouts() Timeouts {
+	timeouts := d.Timeouts
+	if timeouts.ISOInstall == 0 && d.ISOInstallTimeout > 0 {
+		timeouts.ISOInstall = time.Duration(d.ISOInstallTimeout) * time.Second
+	}
+	for _, phase := range timeoutPhases {
+		if target := phase.target(&timeouts); *target == 0 {
+			*target = phase.value
+		}
+	}
+
+	return timeouts
+}
+
+// retry calls fn every pollInterval until it reports done or the timeout
+// expires; fn returns done with an error to give up immediately
+func retry(timeout time.Duration, fn func() (bool, error)) error {
+	deadline := time.Now().Add(timeout)
+	for {
+		done, err := fn()
+		if done {
+			return err
+		}
+
+		if time.Now().Add(pollInterval).After(deadline) {
+			if err == nil {
+				err = errors.New("no response")
+			}
+			return fmt.Errorf("timed out after %s: %w", timeout, err)
+		}
+
+		time.Sleep(pollInterval)
+	}
+}
+
+// waitForCloudInit waits for cloud-init to finish when the cloud-init
+// timeout is set, so provisioning does not race with package installs
+func (d *Driver) waitForCloudInit(client incus.InstanceServer) error {
+	timeout := d.timeouts().CloudInit
+	if timeout == 0 && d.EnginePreinstall {
+		timeout = engineInstallTimeout
+	}
+	if timeout == 0 {
+		return nil
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	log.Infof("Waiting for cloud-init of %s to finish...", d.MachineName)
+	_, err := d.execContext(ctx, client, "cloud-init", "status", "--wait")
+	if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+		return fmt.Errorf("timeout waiting for cloud-init after %s", timeout)
+	}
+	if err != nil {
+		log.Warnf("cloud-init of %s did not finish cleanly: %s", d.MachineName, err)
+	}
+
+	return nil
+}
@@ -0,0 +1,68 @@
+package incus
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/docker/machine/libmachine/drivers"
+)
+
+func TestParseTimeouts(t *testing.T) {
+	tests := []struct {
+		name    string
+		values  map[string]interface{}
+		want    Timeouts
+		wantErr string
+	}{
+		{
+			name:   "defaults",
+			values: map[string]interface{}{},
+			want:   Timeouts{Connect: 30 * time.Second, Preflight: 5 * time.Minute, ImageDownload: 30 * time.Minute, Boot: 500 * time.Second, Agent: 5 * time.Minute, Ready: 30 * time.Minute, SSH: 5 * time.Minute, ISOInstall: time.Hour},
+		},
+		{
+			name:   "phase set",
+			values: map[string]interface{}{"incus-timeout-boot": "90s", "incus-timeout-cloud-init": "10m"},
+			want:   Timeouts{Connect: 30 * time.Second, Preflight: 5 * time.Minute, ImageDownload: 30 * time.Minute, Boot: 90 * time.Second, Agent: 5 * time.Minute, CloudInit: 10 * time.Minute, Ready: 30 * time.Minute, SSH: 5 * time.Minute, ISOInstall: time.Hour},
+		},
+		{
+			name:   "zero disables the cloud-init wait",
+			values: map[string]interface{}{"incus-timeout-cloud-init": "0s"},
+			want:   Timeouts{Connect: 30 * time.Second, Preflight: 5 * time.Minute, ImageDownload: 30 * time.Minute, Boot: 500 * time.Second, Agent: 5 * time.Minute, Ready: 30 * time.Minute, SSH: 5 * time.Minute, ISOInstall: time.Hour},
+		},
+		{
+			name:    "zero",
+			values:  map[string]interface{}{"incus-timeout-ssh": "0s"},
+			wantErr: "--incus-timeout-ssh",
+		},
+		{
+			name:    "negative",
+			values:  map[string]interface{}{"incus-timeout-boot": "-1m"},
+			wantErr: "--incus-timeout-boot",
+		},
+		{
+			name:    "not a duration",
+			values:  map[string]interface{}{"incus-timeout-agent": "5"},
+			wantErr: "--incus-timeout-agent",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			flags := &drivers.CheckDriverOptions{FlagsValues: tt.values, CreateFlags: timeoutFlags()}
+			timeouts, err := parseTimeouts(flags)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("error = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if timeouts != tt.want {
+				t.Errorf("timeouts = %+v, want %+v", timeouts, tt.want)
+			}
+		})
+	}
+}
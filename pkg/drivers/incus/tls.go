@@ -0,0 +1,111 @@
+package incus
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/docker/machine/libmachine/log"
+)
+
+// readPEM returns a PEM document given inline or as a file path, so the
+// machine config keeps the content and not a path to it
+func readPEM(kind, value string) ([]byte, error) {
+	if strings.Contains(value, "-----BEGIN") {
+		return []byte(value), nil
+	}
+
+	content, err := os.ReadFile(value)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s: %w", kind, err)
+	}
+
+	return content, nil
+}
+
+// loadCertificate reads a PEM certificate given inline or as a file path
+func loadCertificate(kind, value string) (string, error) {
+	if value == "" {
+		return "", nil
+	}
+
+	content, err := readPEM(kind, value)
+	if err != nil {
+		return "", err
+	}
+
+	block, _ := pem.Decode(content)
+	if block == nil || block.Type != "CERTIFICATE" {
+		return "", fmt.Errorf("invalid %s, no PEM certificate found", kind)
+	}
+	if _, err := x509.ParseCertificate(block.Bytes); err != nil {
+		return "", fmt.Errorf("invalid %s: %w", kind, err)
+	}
+
+	return string(content), nil
+}
+
+// parseClientCertificate sets the client certificate and key, given inline
+// or as file paths, and checks they form a key pair
+func (d *Driver) parseClientCertificate(cert, key string) error {
+	if (cert == "") != (key == "") {
+		return fmt.Errorf("the TLS client certificate and key have to be set together")
+	}
+	if cert == "" {
+		d.TLSClientCert, d.TLSClientKey = "", ""
+		return nil
+	}
+
+	certPEM, err := readPEM("client certificate", cert)
+	if err != nil {
+		return err
+	}
+	keyPEM, err := readPEM("client key", key)
+	if err != nil {
+		return err
+	}
+
+	if _, err := tls.X509KeyPair(certPEM, keyPEM); err != nil {
+		return fmt.Errorf("invalid TLS client certificate: %w", err)
+	}
+	d.TLSClientCert, d.TLSClientKey = string(certPEM), string(keyPEM)
+
+	return nil
+}
+
+// parseTLSVerify sets how the certificate of the Incus server is verified,
+// against the pinned server certificate, the CA or the system roots
+func (d *Driver) parseTLSVerify(serverCert, ca string, insecure bool) error {
+	var err error
+	if d.TLSServerCert, err = loadCertificate("server certificate", serverCert); err != nil {
+		return err
+	}
+	if d.TLSCA, err = loadCertificate("CA certificate", ca); err != nil {
+		return err
+	}
+
+	if insecure && (d.TLSServerCert != "" || d.TLSCA != "") {
+		return fmt.Errorf("--incus-insecure can not be used with --incus-tls-server-cert or --incus-tls-ca")
+	}
+	if insecure && d.unixSocket() == "" {
+		log.Warnf("TLS verification of %s is disabled, the connection is open to man-in-the-middle attacks", d.URL)
+	}
+	d.TLSVerify = !insecure
+
+	return nil
+}
+
+// tlsFromEnv sets the client certificate and the server verification from
+// the INCUS_TLS_* and INCUS_INSECURE environment
+func (d *Driver) tlsFromEnv() error {
+	if err := d.parseClientCertificate(os.Getenv("INCUS_TLS_CLIENT_CERT"), os.Getenv("INCUS_TLS_CLIENT_KEY")); err != nil {
+		return err
+	}
+
+	insecure, _ := strconv.ParseBool(os.Getenv("INCUS_INSECURE"))
+	return d.parseTLSVerify(os.Getenv("INCUS_TLS_SERVER_CERT"), os.Getenv("INCUS_TLS_CA"), insecure)
+}
@@ -0,0 +1,101 @@
+package incus
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestParseClientCertificate(t *testing.T) {
+	dir := t.TempDir()
+	writeFile := func(name, content string) string {
+		path := filepath.Join(dir, name)
+		if err := os.WriteFile(path, []byte(content), 0600); err != nil {
+			t.Fatal(err)
+		}
+		return path
+	}
+
+	cert, key := testCertificate(t, "client", false)
+	_, otherKey := testCertificate(t, "other", false)
+	certFile := writeFile("client.crt", cert)
+	keyFile := writeFile("client.key", key)
+
+	tests := []struct {
+		name    string
+		cert    string
+		key     string
+		want    bool
+		wantErr string
+	}{
+		{name: "none"},
+		{name: "inline", cert: cert, key: key, want: true},
+		{name: "file paths", cert: certFile, key: keyFile, want: true},
+		{name: "inline certificate and key file", cert: cert, key: keyFile, want: true},
+		{name: "certificate without key", cert: cert, wantErr: "have to be set together"},
+		{name: "missing file", cert: filepath.Join(dir, "missing.crt"), key: key, wantErr: "failed to read client certificate"},
+		{name: "key of another certificate", cert: cert, key: otherKey, wantErr: "invalid TLS client certificate"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d := &Driver{}
+			err := d.parseClientCertificate(tt.cert, tt.key)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("error = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if tt.want && (d.TLSClientCert != cert || d.TLSClientKey != key) {
+				t.Errorf("client certificate and key not stored as PEM")
+			}
+			if !tt.want && (d.TLSClientCert != "" || d.TLSClientKey != "") {
+				t.Errorf("client certificate and key set without flags")
+			}
+		})
+	}
+}
+
+func TestLoadCertificate(t *testing.T) {
+	cert, key := testCertificate(t, "server", false)
+	certFile := filepath.Join(t.TempDir(), "server.crt")
+	if err := os.WriteFile(certFile, []byte(cert), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		value   string
+		want    string
+		wantErr string
+	}{
+		{name: "none", value: "", want: ""},
+		{name: "inline", value: cert, want: cert},
+		{name: "file path", value: certFile, want: cert},
+		{name: "key", value: key, wantErr: "no PEM certificate found"},
+		{name: "missing file", value: certFile + ".missing", wantErr: "failed to read server certificate"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := loadCertificate("server certificate", tt.value)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("error = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("certificate = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
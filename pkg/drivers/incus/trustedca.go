@@ -0,0 +1,123 @@
+package incus
+
+import (
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+)
+
+const dockerCertsPath = "/etc/docker/certs.d"
+
+var registryHostRegexp = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9.-]*(:[0-9]+)?$`)
+
+// TrustedCA is a CA certificate added to the trust store of the guest,
+// optionally scoped to a registry through the Docker certs.d directory
+type TrustedCA struct {
+	Registry    string
+	Certificate string
+}
+
+// parseTrustedCAs parses [registry=]ca-file entries, reading and validating
+// the PEM certificates so the machine config is enough to recreate the instance
+func parseTrustedCAs(values []string) ([]TrustedCA, error) {
+	cas := []TrustedCA{}
+	for _, value := range values {
+		if strings.TrimSpace(value) == "" {
+			continue
+		}
+
+		ca := TrustedCA{}
+		source := value
+		if registry, file, found := strings.Cut(value, "="); found {
+			if !registryHostRegexp.MatchString(registry) {
+				return nil, fmt.Errorf("invalid registry %q of trusted CA, expected host[:port]", registry)
+			}
+			ca.Registry = registry
+			source = file
+		}
+
+		content, err := os.ReadFile(source)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read trusted CA: %w", err)
+		}
+		if err := checkCACertificates(content); err != nil {
+			return nil, fmt.Errorf("invalid trusted CA %s: %w", source, err)
+		}
+		ca.Certificate = string(content)
+
+		cas = append(cas, ca)
+	}
+
+	return cas, nil
+}
+
+// checkCACertificates makes sure the bundle only holds CA certificates, as
+// cloud-init silently skips what update-ca-certificates can not parse
+func checkCACertificates(content []byte) error {
+	count := 0
+	for rest := content; ; {
+		var block *pem.Block
+		block, rest = pem.Decode(rest)
+		if block == nil {
+			break
+		}
+		if block.Type != "CERTIFICATE" {
+			return fmt.Errorf("unexpected %s block", block.Type)
+		}
+
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return err
+		}
+		if !cert.IsCA {
+			return fmt.Errorf("certificate %q is not a CA", cert.Subject.CommonName)
+		}
+		count++
+	}
+
+	if count == 0 {
+		return fmt.Errorf("no PEM certificate found")
+	}
+
+	return nil
+}
+
+// getTrustedCAs returns the certificates of the cloud-init ca_certs module
+func (d *Driver) getTrustedCAs() []string {
+	certs := make([]string, 0, len(d.TrustedCAs))
+	for _, ca := range d.TrustedCAs {
+		certs = append(certs, ca.Certificate)
+	}
+
+	return certs
+}
+
+// getRegistryCAFiles returns the write_files entries of the registry scoped
+// CAs, concatenating the certificates given for the same registry
+func (d *Driver) getRegistryCAFiles() []map[string]string {
+	registries := []string{}
+	bundles := map[string]string{}
+	for _, ca := range d.TrustedCAs {
+		if ca.Registry == "" {
+			continue
+		}
+		if _, ok := bundles[ca.Registry]; !ok {
+			registries = append(registries, ca.Registry)
+		}
+		bundles[ca.Registry] += strings.TrimSuffix(ca.Certificate, "\n") + "\n"
+	}
+
+	files := make([]map[string]string, 0, len(registries))
+	for _, registry := range registries {
+		files = append(files, map[string]string{
+			"path":        dockerCertsPath + "/" + registry + "/ca.crt",
+			"content":     bundles[registry],
+			"permissions": "0644",
+		})
+	}
+
+	return files
+}
@@ -0,0 +1,107 @@
+package incus
+
+import (
+	"encoding/pem"
+	"fmt"
+	"time"
+
+	"github.com/docker/machine/libmachine/log"
+	incus "github.com/lxc/incus/v6/client"
+	"github.com/lxc/incus/v6/shared/api"
+	localtls "github.com/lxc/incus/v6/shared/tls"
+)
+
+// parseTrustToken validates the trust token before create, the URL of the
+// machine defaulting to the first address of the server it was issued by
+func (d *Driver) parseTrustToken(value string) error {
+	d.trustToken = value
+	if value == "" {
+		return nil
+	}
+
+	token, err := localtls.CertificateTokenDecode(value)
+	if err != nil {
+		return fmt.Errorf("invalid trust token: %w", err)
+	}
+	if !token.ExpiresAt.IsZero() && token.ExpiresAt.Before(time.Now()) {
+		return fmt.Errorf("trust token of %s expired at %s", token.ClientName, token.ExpiresAt.Format(time.RFC3339))
+	}
+	if d.unixSocket() != "" {
+		return fmt.Errorf("--incus-trust-token can not be used with a unix:// URL")
+	}
+
+	if d.URL == "" && d.APISSHTunnel == "" {
+		if len(token.Addresses) == 0 {
+			return fmt.Errorf("trust token has no server address, set --incus-url")
+		}
+		d.URL = "https://" + token.Addresses[0]
+	}
+
+	return nil
+}
+
+// prepareTrustToken sets up the credentials the trust token enrolls, a new
+// client certificate when none is set and the server certificate the token
+// names, both persisted with the machine for the calls after create
+func (d *Driver) prepareTrustToken() error {
+	token, err := localtls.CertificateTokenDecode(d.trustToken)
+	if err != nil {
+		return fmt.Errorf("invalid trust token: %w", err)
+	}
+
+	if d.TLSClientCert == "" || d.TLSClientKey == "" {
+		log.Infof("Generating client certificate for trust token of %s...", token.ClientName)
+		cert, key, err := localtls.GenerateMemCert(true, false)
+		if err != nil {
+			return fmt.Errorf("failed to generate client certificate: %w", err)
+		}
+		d.TLSClientCert, d.TLSClientKey = string(cert), string(key)
+	}
+
+	if d.TLSServerCert != "" || d.TLSCA != "" {
+		return nil
+	}
+	if d.APISSHTunnel != "" {
+		return fmt.Errorf("--incus-trust-token through the SSH tunnel needs --incus-tls-server-cert or --incus-tls-ca")
+	}
+
+	cert, err := localtls.GetRemoteCertificate(d.URL, "")
+	if err != nil {
+		return fmt.Errorf("failed to get server certificate of %s: %w", d.URL, err)
+	}
+	if fingerprint := localtls.CertFingerprint(cert); fingerprint != token.Fingerprint {
+		return fmt.Errorf("certificate of %s has fingerprint %.12s, the trust token was issued by %.12s", d.URL, fingerprint, token.Fingerprint)
+	}
+	d.TLSServerCert = string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cert.Raw}))
+
+	return nil
+}
+
+// enrollTrustToken adds the client certificate to the trust store of the
+// server with the trust token, the token being single use
+func (d *Driver) enrollTrustToken(is incus.InstanceServer) (*api.Server, error) {
+	token, err := localtls.CertificateTokenDecode(d.trustToken)
+	if err != nil {
+		return nil, fmt.Errorf("invalid trust token: %w", err)
+	}
+
+	log.Infof("Enrolling client certificate %s with trust token...", token.ClientName)
+	err = is.CreateCertificate(api.CertificatesPost{
+		CertificatePut: api.CertificatePut{
+			Name: token.ClientName,
+			Type: api.CertificateTypeClient,
+		},
+		TrustToken: d.trustToken,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to enroll with trust token: %w", err)
+	}
+	d.trustToken = ""
+
+	server, _, err := is.GetServer()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get server info of %s: %w", d.URL, err)
+	}
+
+	return server, nil
+}
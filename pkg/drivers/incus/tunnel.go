@@ -0,0 +1,291 @@
+package incus
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io/fs"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	incus "github.com/lxc/incus/v6/client"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
+	"golang.org/x/crypto/ssh/knownhosts"
+)
+
+// parseSSHTunnel validates a user@host[:port] tunnel endpoint
+func parseSSHTunnel(value string) (string, error) {
+	if value == "" {
+		return "", nil
+	}
+
+	user, host, found := strings.Cut(value, "@")
+	if !found || user == "" || host == "" {
+		return "", fmt.Errorf("invalid API SSH tunnel %q, expected user@host[:port]", value)
+	}
+
+	return value, nil
+}
+
+// tunnelEndpoint returns the user and the host:port of the tunnel
+func (d *Driver) tunnelEndpoint() (string, string) {
+	user, host, _ := strings.Cut(d.APISSHTunnel, "@")
+	if _, _, err := net.SplitHostPort(host); err != nil {
+		host = net.JoinHostPort(strings.Trim(host, "[]"), "22")
+	}
+
+	return user, host
+}
+
+// tunnelHost returns the host name of the tunnel endpoint
+func (d *Driver) tunnelHost() string {
+	_, address := d.tunnelEndpoint()
+	host, _, _ := net.SplitHostPort(address)
+	return host
+}
+
+// dialTunnel opens the SSH connection to the Incus host, authenticating with
+// the configured key or the SSH agent and checking the host key against the
+// known_hosts of the user
+func (d *Driver) dialTunnel() (*ssh.Client, error) {
+	user, address := d.tunnelEndpoint()
+
+	auth := []ssh.AuthMethod{}
+	if d.APISSHKey != "" {
+		key, err := os.ReadFile(d.APISSHKey)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read API SSH key: %w", err)
+		}
+		signer, err := ssh.ParsePrivateKey(key)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse API SSH key: %w", err)
+		}
+		auth = append(auth, ssh.PublicKeys(signer))
+	} else if socket := os.Getenv("SSH_AUTH_SOCK"); socket != "" {
+		conn, err := net.Dial("unix", socket)
+		if err != nil {
+			return nil, fmt.Errorf("failed to connect to the SSH agent: %w", err)
+		}
+		// the agent only signs the authentication
+		defer conn.Close()
+		auth = append(auth, ssh.PublicKeysCallback(agent.NewClient(conn).Signers))
+	} else {
+		return nil, fmt.Errorf("the API SSH tunnel needs --incus-api-ssh-key or a running SSH agent")
+	}
+
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return nil, err
+	}
+	knownHosts := filepath.Join(home, ".ssh", knownHostsFile)
+	hostKeyCallback, err := knownhosts.New(knownHosts)
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil, fmt.Errorf("%s not found, the API SSH tunnel checks the host key of %s against it, add it with ssh-keyscan or a first ssh login", knownHosts, d.tunnelHost())
+		}
+		return nil, fmt.Errorf("failed to load the known hosts of the API SSH tunnel: %w", err)
+	}
+
+	client, err := ssh.Dial("tcp", address, &ssh.ClientConfig{
+		User:            user,
+		Auth:            auth,
+		HostKeyCallback: hostKeyCallback,
+		Timeout:         d.timeouts().Connect,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to open the API SSH tunnel to %s: %w", address, err)
+	}
+
+	return client, nil
+}
+
+// getTunnel returns the SSH tunnel of the driver, opening its first
+// connection
+func (d *Driver) getTunnel() (*apiTunnel, error) {
+	if d.tunnel != nil {
+		return d.tunnel, nil
+	}
+
+	tunnel := &apiTunnel{dial: d.dialTunnel}
+	if err := tunnel.redial(); err != nil {
+		return nil, err
+	}
+
+	d.tunnel = tunnel
+	return d.tunnel, nil
+}
+
+// Close closes the API SSH tunnel, for the programs embedding the driver;
+// the plugin exits instead
+func (d *Driver) Close() error {
+	if d.tunnel == nil {
+		return nil
+	}
+
+	err := d.tunnel.Close()
+	d.tunnel = nil
+	d.incus = nil
+	return err
+}
+
+// connectTunnel connects to the Incus API through the SSH tunnel, to the
+// HTTPS address of the URL as seen from the Incus host, or to the unix
+// socket when no URL is set
+func (d *Driver) connectTunnel(ctx context.Context, args *incus.ConnectionArgs) (incus.InstanceServer, error) {
+	tunnel, err := d.getTunnel()
+	if err != nil {
+		return nil, err
+	}
+
+	dial := func(_ context.Context, network, addr string) (net.Conn, error) {
+		return tunnel.Dial(network, addr)
+	}
+
+	if d.URL == "" {
+		// the client only dials the socket of this host, swap its dialer
+		// before the first request
+		args.HTTPClient = &http.Client{}
+		args.SkipGetServer = true
+		server, err := incus.ConnectIncusUnixWithContext(ctx, d.APISSHSocket, args)
+		if err != nil {
+			return nil, err
+		}
+		args.HTTPClient.Transport.(*http.Transport).DialContext = func(ctx context.Context, _, _ string) (net.Conn, error) {
+			return dial(ctx, "unix", d.APISSHSocket)
+		}
+
+		if _, _, err := server.GetServer(); err != nil {
+			return nil, err
+		}
+
+		return server, nil
+	}
+
+	args.TransportWrapper = func(t *http.Transport) incus.HTTPTransporter {
+		// websockets dial on their own and only use the TLS config
+		t.Proxy = nil
+		t.DialContext = dial
+		t.DialTLSContext = func(ctx context.Context, network, addr string) (net.Conn, error) {
+			conn, err := dial(ctx, network, addr)
+			if err != nil {
+				return nil, err
+			}
+
+			config := t.TLSClientConfig.Clone()
+			// a pinned server certificate sets its own name
+			if host, _, err := net.SplitHostPort(addr); err == nil && config.ServerName == "" {
+				config.ServerName = host
+			}
+
+			tlsConn := tls.Client(conn, config)
+			if err := tlsConn.HandshakeContext(ctx); err != nil {
+				_ = conn.Close()
+				return nil, err
+			}
+
+			return tlsConn, nil
+		}
+
+		return tunnelTransport{t}
+	}
+
+	return incus.ConnectIncusWithContext(ctx, d.URL, args)
+}
+
+// apiTunnel is the SSH connection the API requests go through, dialed
+// again when the connection died, ex: the Incus host rebooted
+type apiTunnel struct {
+	dial func() (*ssh.Client, error)
+
+	mu     sync.Mutex
+	client *ssh.Client
+}
+
+// Dial opens a connection from the Incus host, reconnecting the tunnel once
+// when it no longer answers
+func (t *apiTunnel) Dial(network, addr string) (net.Conn, error) {
+	t.mu.Lock()
+	client := t.client
+	t.mu.Unlock()
+
+	// a closed tunnel is reconnected too
+	if client != nil {
+		conn, err := client.Dial(network, addr)
+		if err == nil {
+			return conn, nil
+		}
+
+		if _, _, keepaliveErr := client.SendRequest("keepalive@openssh.com", true, nil); keepaliveErr == nil {
+			return nil, err
+		}
+	}
+
+	t.mu.Lock()
+	// another request may have reconnected it meanwhile
+	if t.client == client {
+		if err := t.redialLocked(); err != nil {
+			t.mu.Unlock()
+			return nil, err
+		}
+	}
+	client = t.client
+	t.mu.Unlock()
+
+	return client.Dial(network, addr)
+}
+
+func (t *apiTunnel) redial() error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	return t.redialLocked()
+}
+
+func (t *apiTunnel) redialLocked() error {
+	client, err := t.dial()
+	if err != nil {
+		return err
+	}
+
+	_ = t.closeLocked()
+	t.client = client
+	return nil
+}
+
+// Close closes the SSH connection
+func (t *apiTunnel) Close() error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	return t.closeLocked()
+}
+
+func (t *apiTunnel) closeLocked() error {
+	if t.client == nil {
+		return nil
+	}
+
+	err := t.client.Close()
+	t.client = nil
+	return err
+}
+
+// tunnelTransport hands the transport dialing through the tunnel back to
+// the Incus client
+type tunnelTransport struct {
+	transport *http.Transport
+}
+
+func (t tunnelTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	return t.transport.RoundTrip(req)
+}
+
+func (t tunnelTransport) Transport() *http.Transport {
+	return t.transport
+}
@@ -0,0 +1,40 @@
+package incus
+
+import (
+	"fmt"
+	"path"
+	"strings"
+)
+
+const (
+	unixURLScheme     = "unix://"
+	defaultUnixSocket = "/var/lib/incus/unix.socket"
+)
+
+// parseIncusURL validates the Incus URL, a unix:// URL without path using
+// the socket of the default install
+func parseIncusURL(value string) (string, error) {
+	socket, found := strings.CutPrefix(value, unixURLScheme)
+	if !found {
+		return value, nil
+	}
+
+	if socket == "" {
+		return unixURLScheme + defaultUnixSocket, nil
+	}
+	if !path.IsAbs(socket) {
+		return "", fmt.Errorf("invalid Incus URL %q, expected unix:///path/to/unix.socket", value)
+	}
+
+	return unixURLScheme + path.Clean(socket), nil
+}
+
+// unixSocket returns the path of the local Incus socket the driver connects
+// to, or an empty string for HTTPS URLs
+func (d *Driver) unixSocket() string {
+	if socket, found := strings.CutPrefix(d.URL, unixURLScheme); found {
+		return socket
+	}
+
+	return ""
+}
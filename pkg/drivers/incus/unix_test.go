@@ -0,0 +1,56 @@
+package incus
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestParseIncusURL(t *testing.T) {
+	tests := []struct {
+		name    string
+		value   string
+		want    string
+		wantErr string
+	}{
+		{name: "https", value: "https://incus.example.com:8443", want: "https://incus.example.com:8443"},
+		{name: "default socket", value: "unix://", want: "unix:///var/lib/incus/unix.socket"},
+		{name: "socket", value: "unix:///run/incus/unix.socket", want: "unix:///run/incus/unix.socket"},
+		{name: "socket cleaned", value: "unix:///var/lib/incus//../incus/unix.socket", want: "unix:///var/lib/incus/unix.socket"},
+		{name: "relative socket", value: "unix://unix.socket", wantErr: "expected unix:///path/to/unix.socket"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			url, err := parseIncusURL(tt.value)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("error = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if url != tt.want {
+				t.Errorf("url = %q, want %q", url, tt.want)
+			}
+		})
+	}
+}
+
+func TestUnixSocket(t *testing.T) {
+	tests := []struct {
+		url  string
+		want string
+	}{
+		{url: "unix:///var/lib/incus/unix.socket", want: "/var/lib/incus/unix.socket"},
+		{url: "https://incus.example.com:8443", want: ""},
+	}
+
+	for _, tt := range tests {
+		d := &Driver{URL: tt.url}
+		if got := d.unixSocket(); got != tt.want {
+			t.Errorf("unixSocket() of %q = %q, want %q", tt.url, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,103 @@
+package incus
+
+import (
+	"fmt"
+
+	"github.com/docker/machine/libmachine/log"
+	incus "github.com/lxc/incus/v6/client"
+)
+
+// osUpgradeScript upgrades the guest packages with whatever package manager
+// the distribution ships
+const osUpgradeScript = `set -e
+if command -v apt-get >/dev/null; then
+  export DEBIAN_FRONTEND=noninteractive
+  apt-get update
+  apt-get -y -o Dpkg::Options::=--force-confold dist-upgrade
+elif command -v dnf >/dev/null; then
+  dnf -y upgrade
+elif command -v yum >/dev/null; then
+  yum -y update
+elif command -v zypper >/dev/null; then
+  zypper --non-interactive update
+elif command -v apk >/dev/null; then
+  apk upgrade --update
+else
+  echo "no supported package manager found" >&2
+  exit 1
+fi
+`
+
+// UpgradeOS upgrades the guest operating system in place, reboots the
+// machine and waits for it to be healthy again
+func (d *Driver) UpgradeOS() error {
+	client, err := d.getClient()
+	if err != nil {
+		return err
+	}
+
+	if err := d.waitForAgent(client); err != nil {
+		return err
+	}
+	d.warnDrift()
+
+	snapshot, err := d.takeSnapshot(client, "upgrade")
+	if err != nil {
+		return err
+	}
+
+	err = d.upgradeOS(client)
+	if err != nil {
+		d.saveConsoleLog()
+	}
+	if err == nil || snapshot == "" {
+		return err
+	}
+
+	if restoreErr := d.restoreSnapshot(client, snapshot); restoreErr != nil {
+		return fmt.Errorf("%w, restoring snapshot %s failed: %s", err, snapshot, restoreErr)
+	}
+
+	return fmt.Errorf("%w, instance restored from snapshot %s", err, snapshot)
+}
+
+func (d *Driver) upgradeOS(client incus.InstanceServer) error {
+	log.Infof("Upgrading the operating system of %s...", d.MachineName)
+	if _, err := d.exec(client, "sh", "-c", osUpgradeScript); err != nil {
+		return fmt.Errorf("failed to upgrade the operating system: %w", err)
+	}
+
+	log.Infof("Rebooting %s...", d.MachineName)
+	if err := d.Restart(); err != nil {
+		return err
+	}
+
+	return d.waitForHealthy(client)
+}
+
+// waitForHealthy waits for the agent, the instance address and, when it is
+// installed, the Docker daemon to be back after a reboot
+func (d *Driver) waitForHealthy(client incus.InstanceServer) error {
+	if err := d.waitForAgent(client); err != nil {
+		return err
+	}
+
+	if err := d.waitForIP(client); err != nil {
+		return err
+	}
+
+	if err := d.updateDockerProxy(client); err != nil {
+		return err
+	}
+
+	err := retry(d.timeouts().Agent, func() (bool, error) {
+		_, err := d.exec(client, "sh", "-c", "! command -v docker >/dev/null || docker info >/dev/null")
+		return err == nil, err
+	})
+	if err != nil {
+		return fmt.Errorf("docker did not come back after reboot: %w", err)
+	}
+
+	log.Infof("Instance %s is healthy", d.MachineName)
+	return nil
+}
@@ -0,0 +1,105 @@
+package incus
+
+import (
+	"fmt"
+	"net"
+	"slices"
+	"strings"
+
+	"github.com/docker/machine/libmachine/log"
+	"github.com/lxc/incus/v6/shared/api"
+)
+
+// urlAddressPolicies are the --incus-url-address choices besides a subnet
+var urlAddressPolicies = []string{"ipv4", "ipv6", "management"}
+
+func parseURLAddressPolicy(value string) (string, error) {
+	if value == "" || slices.Contains(urlAddressPolicies, value) {
+		return value, nil
+	}
+
+	if _, subnet, err := net.ParseCIDR(value); err == nil {
+		return subnet.String(), nil
+	}
+
+	return "", fmt.Errorf("invalid URL address %q, expected one of %s or a subnet", value, strings.Join(urlAddressPolicies, ", "))
+}
+
+// resolveURLAddress records the address GetURL advertises, keeping the one
+// chosen before as long as the instance still has it
+func (d *Driver) resolveURLAddress(state *api.InstanceState) {
+	if d.URLAddressPolicy == "" {
+		return
+	}
+
+	addresses := d.policyAddresses(state)
+	if d.URLAddress != "" && slices.Contains(addresses, d.URLAddress) {
+		return
+	}
+
+	if len(addresses) == 0 {
+		log.Warnf("Instance %s has no address matching %s, advertising %s", d.MachineName, d.URLAddressPolicy, d.IPAddress)
+		d.URLAddress = ""
+		return
+	}
+
+	d.URLAddress = addresses[0]
+	log.Infof("Docker URL address: %s", d.URLAddress)
+}
+
+// policyAddresses returns the global addresses of the instance matching the
+// URL address policy, in a stable order; the load balancer VIP is never
+// advertised as it spreads the Docker API over all its backends, machines
+// created with the former lb policy get their IPv4 address
+func (d *Driver) policyAddresses(state *api.InstanceState) []string {
+	var subnet *net.IPNet
+	if _, cidr, err := net.ParseCIDR(d.URLAddressPolicy); err == nil {
+		subnet = cidr
+	}
+
+	hwaddr := d.NICHwaddr
+	if d.URLAddressPolicy == "management" {
+		if d.ManagementHwaddr == "" {
+			return nil
+		}
+		hwaddr = d.ManagementHwaddr
+	}
+
+	names := make([]string, 0, len(state.Network))
+	for name := range state.Network {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+
+	addresses := []string{}
+	for _, name := range names {
+		nic := state.Network[name]
+		// a subnet matches the addresses of any NIC
+		if subnet == nil && hwaddr != "" && !strings.EqualFold(nic.Hwaddr, hwaddr) {
+			continue
+		}
+
+		for _, addr := range nic.Addresses {
+			if addr.Scope != "global" {
+				continue
+			}
+
+			switch {
+			case subnet != nil:
+				if ip := net.ParseIP(addr.Address); ip == nil || !subnet.Contains(ip) {
+					continue
+				}
+			case d.URLAddressPolicy == "ipv6":
+				if addr.Family != "inet6" {
+					continue
+				}
+			case addr.Family != "inet":
+				continue
+			}
+
+			addresses = append(addresses, addr.Address)
+		}
+	}
+
+	return addresses
+}
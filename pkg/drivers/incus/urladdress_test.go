@@ -0,0 +1,62 @@
+package incus
+
+import (
+	"net"
+	"net/url"
+	"testing"
+
+	"github.com/docker/machine/libmachine/drivers"
+)
+
+func TestDockerURLMatchesIP(t *testing.T) {
+	tests := []struct {
+		name   string
+		driver *Driver
+		want   string
+	}{
+		{
+			name:   "instance address",
+			driver: &Driver{BaseDriver: &drivers.BaseDriver{IPAddress: "10.0.0.5"}},
+			want:   "tcp://10.0.0.5:2376",
+		},
+		{
+			name:   "URL address policy",
+			driver: &Driver{BaseDriver: &drivers.BaseDriver{IPAddress: "10.0.0.5"}, URLAddressPolicy: "ipv6", URLAddress: "fd42::5"},
+			want:   "tcp://[fd42::5]:2376",
+		},
+		{
+			name:   "docker proxy",
+			driver: &Driver{BaseDriver: &drivers.BaseDriver{IPAddress: "10.0.0.5"}, URLAddress: "fd42::5", DockerProxyPort: 12376, DockerProxyAddress: "203.0.113.1"},
+			want:   "tcp://203.0.113.1:12376",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := tt.driver.dockerURL()
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("url = %q, want %q", got, tt.want)
+			}
+
+			// libmachine names GetIP in the server certificate
+			ip, err := tt.driver.GetIP()
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			u, err := url.Parse(got)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if host, _, _ := net.SplitHostPort(u.Host); host != ip {
+				t.Errorf("url host = %q, GetIP = %q", host, ip)
+			}
+
+			if ssh, _ := tt.driver.GetSSHHostname(); ssh != "10.0.0.5" {
+				t.Errorf("ssh hostname = %q, want the instance address", ssh)
+			}
+		})
+	}
+}
@@ -0,0 +1,137 @@
+package incus
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/mail"
+	"os"
+	"strings"
+)
+
+const jinjaHeader = "## template: jinja"
+
+// userDataHeaders maps the first line prefixes cloud-init recognizes to the
+// format they select, longer prefixes first
+var userDataHeaders = []struct {
+	prefix string
+	format string
+}{
+	{"#cloud-config-archive", "cloud-config-archive"},
+	{"#cloud-config", "cloud-config"},
+	{"#cloud-boothook", "boothook"},
+	{"#include-once", "include"},
+	{"#include", "include"},
+	{"#part-handler", "part-handler"},
+	{"#!", "script"},
+}
+
+// readUserData reads the user-data file and checks cloud-init recognizes
+// its format, returning it unmodified
+func readUserData(path string) (string, string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return "", "", fmt.Errorf("failed to read user-data: %w", err)
+	}
+
+	format, err := userDataFormat(data)
+	if err != nil {
+		return "", "", fmt.Errorf("invalid user-data %s: %w", path, err)
+	}
+
+	return string(data), format, nil
+}
+
+// userDataFormat detects the format of a user-data document the way
+// cloud-init does, from its first line, MIME headers or gzip magic
+func userDataFormat(data []byte) (string, error) {
+	if bytes.HasPrefix(data, []byte{0x1f, 0x8b}) {
+		reader, err := gzip.NewReader(bytes.NewReader(data))
+		if err != nil {
+			return "", err
+		}
+		defer reader.Close()
+
+		inner, err := io.ReadAll(reader)
+		if err != nil {
+			return "", err
+		}
+
+		format, err := userDataFormat(inner)
+		if err != nil {
+			return "", err
+		}
+		return "gzip " + format, nil
+	}
+
+	line, rest, _ := strings.Cut(string(data), "\n")
+	line = strings.TrimSpace(line)
+
+	if strings.EqualFold(line, jinjaHeader) {
+		format, err := userDataFormat([]byte(rest))
+		if err != nil {
+			return "", fmt.Errorf("jinja template: %w", err)
+		}
+		return "jinja " + format, nil
+	}
+
+	for _, header := range userDataHeaders {
+		if strings.HasPrefix(line, header.prefix) {
+			return header.format, nil
+		}
+	}
+
+	if strings.HasPrefix(strings.ToLower(line), "content-type:") || strings.HasPrefix(strings.ToLower(line), "mime-version:") {
+		if err := checkMultipart(data); err != nil {
+			return "", err
+		}
+		return "mime", nil
+	}
+
+	return "", errors.New("unknown format, the first line must be #cloud-config, #! or another cloud-init header")
+}
+
+// checkMultipart parses a MIME multi-part user-data down to the headers of
+// its parts, which must carry a content type
+func checkMultipart(data []byte) error {
+	msg, err := mail.ReadMessage(bufio.NewReader(bytes.NewReader(data)))
+	if err != nil {
+		return fmt.Errorf("invalid MIME headers: %w", err)
+	}
+
+	mediaType, params, err := mime.ParseMediaType(msg.Header.Get("Content-Type"))
+	if err != nil {
+		return fmt.Errorf("invalid MIME content type: %w", err)
+	}
+	if !strings.HasPrefix(mediaType, "multipart/") {
+		return nil
+	}
+
+	reader := multipart.NewReader(msg.Body, params["boundary"])
+	parts := 0
+	for {
+		part, err := reader.NextPart()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return fmt.Errorf("invalid MIME part %d: %w", parts+1, err)
+		}
+
+		parts++
+		if part.Header.Get("Content-Type") == "" {
+			return fmt.Errorf("MIME part %d has no content type", parts)
+		}
+	}
+
+	if parts == 0 {
+		return errors.New("MIME multi-part without parts")
+	}
+
+	return nil
+}
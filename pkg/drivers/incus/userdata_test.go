@@ -0,0 +1,90 @@
+package incus
+
+import (
+	"bytes"
+	"compress/gzip"
+	"strings"
+	"testing"
+)
+
+func gzipped(t *testing.T, data string) string {
+	t.Helper()
+
+	var buf bytes.Buffer
+	writer := gzip.NewWriter(&buf)
+	if _, err := writer.Write([]byte(data)); err != nil {
+		t.Fatal(err)
+	}
+	if err := writer.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	return buf.String()
+}
+
+func TestUserDataFormat(t *testing.T) {
+	multipart := "Content-Type: multipart/mixed; boundary=\"b\"\nMIME-Version: 1.0\n\n" +
+		"--b\nContent-Type: text/cloud-config\n\n#cloud-config\npackages: [jq]\n" +
+		"--b\nContent-Type: text/x-shellscript\n\n#!/bin/sh\necho ready\n--b--\n"
+
+	tests := []struct {
+		name    string
+		data    string
+		want    string
+		wantErr string
+	}{
+		{name: "cloud-config", data: "#cloud-config\npackages: [jq]\n", want: "cloud-config"},
+		{name: "cloud-config archive", data: "#cloud-config-archive\n- type: text/cloud-config\n", want: "cloud-config-archive"},
+		{name: "script", data: "#!/bin/bash\necho ready\n", want: "script"},
+		{name: "boothook", data: "#cloud-boothook\necho boot\n", want: "boothook"},
+		{name: "include once", data: "#include-once\nhttps://example.com/user-data\n", want: "include"},
+		{name: "header surrounded by spaces", data: "  #cloud-config  \n", want: "cloud-config"},
+		{name: "jinja template", data: "## template: jinja\n#cloud-config\nhostname: {{ v1.local_hostname }}\n", want: "jinja cloud-config"},
+		{name: "gzip", data: gzipped(t, "#!/bin/sh\necho ready\n"), want: "gzip script"},
+		{name: "mime multi-part", data: multipart, want: "mime"},
+		{name: "mime single part", data: "Content-Type: text/x-shellscript\n\n#!/bin/sh\n", want: "mime"},
+		{
+			name:    "unknown format",
+			data:    "packages: [jq]\n",
+			wantErr: "unknown format",
+		},
+		{
+			name:    "jinja template of an unknown format",
+			data:    "## template: jinja\npackages: [jq]\n",
+			wantErr: "jinja template: unknown format",
+		},
+		{
+			name:    "mime part without content type",
+			data:    "Content-Type: multipart/mixed; boundary=\"b\"\n\n--b\nX-Name: one\n\nbody\n--b--\n",
+			wantErr: "MIME part 1 has no content type",
+		},
+		{
+			name:    "mime multi-part without parts",
+			data:    "Content-Type: multipart/mixed; boundary=\"b\"\n\n--b--\n",
+			wantErr: "MIME multi-part without parts",
+		},
+		{
+			name:    "invalid mime content type",
+			data:    "Content-Type: multipart/\n\n",
+			wantErr: "invalid MIME content type",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			format, err := userDataFormat([]byte(tt.data))
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("error = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if format != tt.want {
+				t.Errorf("format = %q, want %q", format, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,83 @@
+package incus
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"regexp"
+	"strings"
+)
+
+var (
+	fileModeRegexp  = regexp.MustCompile(`^0?[0-7]{3}$`)
+	fileOwnerRegexp = regexp.MustCompile(`^[a-z_][a-z0-9_-]*(:[a-z_][a-z0-9_-]*)?$`)
+)
+
+// WriteFile is a file dropped into the guest by cloud-init
+type WriteFile struct {
+	Path        string
+	Content     string
+	Permissions string
+	Owner       string
+}
+
+// parseWriteFiles parses path=content-file[,mode,owner] entries, reading the
+// content files so the machine config is enough to recreate the instance
+func parseWriteFiles(values []string) ([]WriteFile, error) {
+	files := []WriteFile{}
+	for _, value := range values {
+		if strings.TrimSpace(value) == "" {
+			continue
+		}
+
+		target, source, found := strings.Cut(value, "=")
+		if !found || !path.IsAbs(target) || source == "" {
+			return nil, fmt.Errorf("invalid write file %q, expected path=content-file[,mode,owner]", value)
+		}
+
+		fields := strings.Split(source, ",")
+		if len(fields) > 3 {
+			return nil, fmt.Errorf("invalid write file %q, expected path=content-file[,mode,owner]", value)
+		}
+
+		file := WriteFile{Path: path.Clean(target)}
+		if len(fields) > 1 && fields[1] != "" {
+			if !fileModeRegexp.MatchString(fields[1]) {
+				return nil, fmt.Errorf("invalid mode %q of write file %s", fields[1], target)
+			}
+			file.Permissions = fields[1]
+		}
+		if len(fields) > 2 && fields[2] != "" {
+			if !fileOwnerRegexp.MatchString(fields[2]) {
+				return nil, fmt.Errorf("invalid owner %q of write file %s", fields[2], target)
+			}
+			file.Owner = fields[2]
+		}
+
+		content, err := os.ReadFile(fields[0])
+		if err != nil {
+			return nil, fmt.Errorf("failed to read content of write file %s: %w", target, err)
+		}
+		file.Content = string(content)
+
+		files = append(files, file)
+	}
+
+	return files, nil
+}
+
+// cloudConfig returns the write_files entry of the file
+func (f WriteFile) cloudConfig() map[string]string {
+	entry := map[string]string{
+		"path":    f.Path,
+		"content": f.Content,
+	}
+	if f.Permissions != "" {
+		entry["permissions"] = f.Permissions
+	}
+	if f.Owner != "" {
+		entry["owner"] = f.Owner
+	}
+
+	return entry
+}
@@ -0,0 +1,92 @@
+package incus
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseWriteFiles(t *testing.T) {
+	content := filepath.Join(t.TempDir(), "daemon.json")
+	if err := os.WriteFile(content, []byte("{}\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		values  []string
+		want    []WriteFile
+		wantErr string
+	}{
+		{
+			name:   "none",
+			values: []string{""},
+			want:   []WriteFile{},
+		},
+		{
+			name:   "content only",
+			values: []string{"/etc/docker/daemon.json=" + content},
+			want:   []WriteFile{{Path: "/etc/docker/daemon.json", Content: "{}\n"}},
+		},
+		{
+			name:   "mode and owner",
+			values: []string{"/etc/app//config.json=" + content + ",0640,root:docker"},
+			want:   []WriteFile{{Path: "/etc/app/config.json", Content: "{}\n", Permissions: "0640", Owner: "root:docker"}},
+		},
+		{
+			name:   "owner without mode",
+			values: []string{"/etc/app.json=" + content + ",,app"},
+			want:   []WriteFile{{Path: "/etc/app.json", Content: "{}\n", Owner: "app"}},
+		},
+		{
+			name:    "relative path",
+			values:  []string{"etc/app.json=" + content},
+			wantErr: "expected path=content-file[,mode,owner]",
+		},
+		{
+			name:    "missing content file",
+			values:  []string{"/etc/app.json"},
+			wantErr: "expected path=content-file[,mode,owner]",
+		},
+		{
+			name:    "too many fields",
+			values:  []string{"/etc/app.json=" + content + ",0640,root,extra"},
+			wantErr: "expected path=content-file[,mode,owner]",
+		},
+		{
+			name:    "invalid mode",
+			values:  []string{"/etc/app.json=" + content + ",rw"},
+			wantErr: `invalid mode "rw"`,
+		},
+		{
+			name:    "invalid owner",
+			values:  []string{"/etc/app.json=" + content + ",0640,Root"},
+			wantErr: `invalid owner "Root"`,
+		},
+		{
+			name:    "unreadable content file",
+			values:  []string{"/etc/app.json=" + content + ".missing"},
+			wantErr: "failed to read content of write file /etc/app.json",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			files, err := parseWriteFiles(tt.values)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("error = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if !reflect.DeepEqual(files, tt.want) {
+				t.Errorf("files = %+v, want %+v", files, tt.want)
+			}
+		})
+	}
+}